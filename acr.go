@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// acrScope is the Microsoft Entra ID scope accepted by the ACR token exchange.
+const acrScope = "https://containerregistry.azure.net/.default"
+
+// acrRefreshTokenUsername is the username ACR expects alongside a refresh token.
+const acrRefreshTokenUsername = "00000000-0000-0000-0000-000000000000"
+
+// acrExchangeTimeout bounds a single call to a registry's /oauth2/exchange endpoint.
+const acrExchangeTimeout = 30 * time.Second
+
+// acrHTTPClient sends ACR exchange requests. It is a variable so tests can trust a
+// local TLS stub.
+var acrHTTPClient = &http.Client{Timeout: acrExchangeTimeout}
+
+// acrCredential exchanges a Microsoft Entra ID access token for an ACR refresh token,
+// which docker and other registry clients accept as a password. The access token
+// itself never leaves the local machine.
+func acrCredential(ctx context.Context, _ CredentialProvider, host string, token azcore.AccessToken) (gitCredential, error) {
+	refreshToken, err := exchangeACRRefreshToken(ctx, host, token.Token)
+	if err != nil {
+		return gitCredential{}, err
+	}
+	return gitCredential{Username: acrRefreshTokenUsername, Password: refreshToken}, nil
+}
+
+// exchangeACRRefreshToken calls https://<registry>/oauth2/exchange with accessToken.
+// The registry must be a DNS name with an optional port, so the token can't be sent to
+// a host other than the one it was issued for.
+func exchangeACRRefreshToken(ctx context.Context, registry, accessToken string) (string, error) {
+	_, registry, err := parseCredentialHost(registry)
+	if err != nil {
+		return "", fmt.Errorf("ACR token exchange: %w", err)
+	}
+	exchangeURL := url.URL{Scheme: "https", Host: registry, Path: "/oauth2/exchange"}
+
+	form := url.Values{
+		"grant_type":   {"access_token"},
+		"service":      {registry},
+		"access_token": {accessToken},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, exchangeURL.String(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("build ACR exchange request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := acrHTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("ACR token exchange with %s failed: %w", registry, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("read ACR exchange response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("ACR token exchange with %s returned %s: %s", registry, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var result struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("decode ACR exchange response: %w", err)
+	}
+	if result.RefreshToken == "" {
+		return "", fmt.Errorf("ACR token exchange with %s returned no refresh token", registry)
+	}
+	return result.RefreshToken, nil
+}
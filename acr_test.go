@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubACRExchange serves /oauth2/exchange over TLS and points acrHTTPClient at it.
+// It returns the registry host to request.
+func stubACRExchange(t *testing.T, handler http.HandlerFunc) string {
+	t.Helper()
+
+	server := httptest.NewTLSServer(handler)
+	t.Cleanup(server.Close)
+
+	original := acrHTTPClient
+	acrHTTPClient = server.Client()
+	t.Cleanup(func() { acrHTTPClient = original })
+
+	return strings.TrimPrefix(server.URL, "https://")
+}
+
+func TestExchangeACRRefreshToken(t *testing.T) {
+	var gotForm map[string]string
+	registry := stubACRExchange(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/oauth2/exchange" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		r.ParseForm()
+		gotForm = map[string]string{
+			"grant_type":   r.PostForm.Get("grant_type"),
+			"service":      r.PostForm.Get("service"),
+			"access_token": r.PostForm.Get("access_token"),
+		}
+		w.Write([]byte(`{"refresh_token":"acr-refresh-token"}`))
+	})
+
+	refreshToken, err := exchangeACRRefreshToken(context.Background(), registry, "aad-token")
+	if err != nil {
+		t.Fatalf("exchangeACRRefreshToken() error = %v", err)
+	}
+	if refreshToken != "acr-refresh-token" {
+		t.Errorf("expected refresh token, got %q", refreshToken)
+	}
+
+	want := map[string]string{"grant_type": "access_token", "service": registry, "access_token": "aad-token"}
+	for key, value := range want {
+		if gotForm[key] != value {
+			t.Errorf("form %s = %q, want %q", key, gotForm[key], value)
+		}
+	}
+}
+
+func TestExchangeACRRefreshToken_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr string
+	}{
+		{name: "unauthorized", status: http.StatusUnauthorized, body: `{"errors":[{"code":"UNAUTHORIZED"}]}`, wantErr: "401"},
+		{name: "missing token", status: http.StatusOK, body: `{}`, wantErr: "no refresh token"},
+		{name: "malformed", status: http.StatusOK, body: `not json`, wantErr: "decode"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := stubACRExchange(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+
+			_, err := exchangeACRRefreshToken(context.Background(), registry, "aad-token")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestExchangeACRRefreshToken_RejectsInvalidRegistry(t *testing.T) {
+	requests := 0
+	stubACRExchange(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	})
+
+	for _, registry := range []string{"x.azurecr.io:1@evil.com", "evil.com/.azurecr.io", "evil.com?.azurecr.io"} {
+		if _, err := exchangeACRRefreshToken(context.Background(), registry, "aad-token"); err == nil || !strings.Contains(err.Error(), "invalid") {
+			t.Errorf("exchangeACRRefreshToken(%q) error = %v, want invalid host", registry, err)
+		}
+	}
+	if requests != 0 {
+		t.Errorf("expected no exchange requests, got %d", requests)
+	}
+}
+
+func TestHandleConnection_ReturnsACRCredential(t *testing.T) {
+	registry := stubACRExchange(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.Write([]byte(`{"refresh_token":"refresh-for-` + r.PostForm.Get("access_token") + `"}`))
+	})
+
+	cred := &fakeCredential{expiresAt: time.Now().Add(time.Hour)}
+	auth := newTestAuthServer(cred)
+	auth.providers = newProviderRegistry(MergeCredentialProviders([]CredentialProvider{{Host: "127.0.0.1", Type: providerTypeACR}}))
+
+	resp := exchangeAuthMessage(t, auth, `{"secret":"test-secret","type":"getCredential","data":{"host":"`+registry+`"}}`)
+	if resp["type"] != "credential" {
+		t.Fatalf("expected credential response, got %v", resp)
+	}
+	data, _ := resp["data"].(map[string]interface{})
+	if data["username"] != acrRefreshTokenUsername || data["password"] != "refresh-for-token-1" {
+		t.Errorf("unexpected credential %v", data)
+	}
+}
+
+func TestDefaultCredentialProviders_ACR(t *testing.T) {
+	provider, ok := newProviderRegistry(MergeCredentialProviders(DefaultCredentialProviders)).lookup("contoso.azurecr.io")
+	if !ok || provider.Type != providerTypeACR || provider.Scope != acrScope || provider.Username != acrRefreshTokenUsername {
+		t.Errorf("expected default ACR provider, got %+v (found %v)", provider, ok)
+	}
+}
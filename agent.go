@@ -0,0 +1,579 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// agentStartTimeout bounds how long 'agent start' waits for the agent to answer.
+	// Startup includes the login probe and preparing the codespace.
+	agentStartTimeout = 2 * time.Minute
+	// agentStopTimeout bounds how long 'agent stop' waits for the agent to exit.
+	agentStopTimeout = 15 * time.Second
+	// agentRequestTimeout bounds a single control request to a running agent.
+	agentRequestTimeout = 5 * time.Second
+)
+
+// agentRecord is written next to a running agent's control socket so other runs can
+// find it. It holds the control secret, so it is only readable by the current user.
+type agentRecord struct {
+	Codespace string        `json:"codespace"`
+	PID       int           `json:"pid"`
+	StartedAt time.Time     `json:"startedAt"`
+	Control   localEndpoint `json:"control"`
+	Secret    string        `json:"secret"`
+	LogDir    string        `json:"logDir,omitempty"`
+}
+
+// AgentStatus is what a running agent or interactive session reports about itself.
+type AgentStatus struct {
+	Codespace string `json:"codespace"`
+	// Mode is "agent" for a background agent and "session" for an interactive session
+	// sharing its services.
+	Mode        string       `json:"mode"`
+	PID         int          `json:"pid"`
+	Sessions    int          `json:"sessions"`
+	StartedAt   time.Time    `json:"startedAt"`
+	SocketPaths []string     `json:"socketPaths"`
+	LogDir      string       `json:"logDir,omitempty"`
+	Metrics     *MetricsInfo `json:"metrics,omitempty"`
+}
+
+// agentDir returns the private directory holding agent records and control sockets.
+func agentDir() (string, error) {
+	parent, err := runtimeUserDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(parent, "agents")
+	if err := ensurePrivateDir(dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// agentFileName returns the base name of a codespace's agent files. Codespace names are
+// hashed so the control socket path stays within the Unix socket length limit.
+func agentFileName(codespace string) string {
+	sum := sha256.Sum256([]byte(codespace))
+	return hex.EncodeToString(sum[:6])
+}
+
+// agentRecordPath returns the path of the agent record for codespace.
+func agentRecordPath(codespace string) (string, error) {
+	dir, err := agentDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, agentFileName(codespace)+".json"), nil
+}
+
+// readAgentRecord reads the agent record at path.
+func readAgentRecord(path string) (*agentRecord, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var record agentRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("invalid agent record %s: %w", path, err)
+	}
+	return &record, nil
+}
+
+// agentClient returns an HTTP client that talks to the agent's control endpoint.
+func agentClient(record *agentRecord) *http.Client {
+	return &http.Client{
+		Timeout: agentRequestTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return record.Control.DialContext(ctx)
+			},
+		},
+	}
+}
+
+// agentRequest sends a control request to the agent and decodes a JSON reply into out.
+func agentRequest(ctx context.Context, record *agentRecord, method, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, "http://agent"+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(sessionSecretHeader, record.Secret)
+
+	resp, err := agentClient(record).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("agent returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// queryAgent returns the status of the agent described by the record at path. Records
+// whose agent doesn't answer are stale and are removed.
+func queryAgent(ctx context.Context, path string) (*agentRecord, *AgentStatus, error) {
+	record, err := readAgentRecord(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var status AgentStatus
+	if err := agentRequest(ctx, record, http.MethodGet, "/status", &status); err != nil {
+		logDebug("Removing stale agent record %s: %v", path, err)
+		os.Remove(path)
+		if record.Control.Network == "unix" {
+			os.Remove(record.Control.Address)
+		}
+		return record, nil, fmt.Errorf("agent for %s is not running", record.Codespace)
+	}
+	return record, &status, nil
+}
+
+// findRunningAgent returns the status of the agent serving codespace, or nil.
+func findRunningAgent(ctx context.Context, codespace string) *AgentStatus {
+	path, err := agentRecordPath(codespace)
+	if err != nil {
+		return nil
+	}
+	_, status, err := queryAgent(ctx, path)
+	if err != nil {
+		return nil
+	}
+	return status
+}
+
+// agentControl serves the control endpoint of a running agent and owns its record.
+type agentControl struct {
+	recordPath string
+	listener   net.Listener
+	server     *http.Server
+
+	mu       sync.Mutex
+	sessions int
+	changed  chan struct{} // closed and replaced whenever sessions changes
+}
+
+// startAgentControl publishes this process as the agent for codespace. stop is called
+// when 'agent stop' asks the agent to exit.
+func startAgentControl(codespace string, status func() AgentStatus, stop func()) (*agentControl, error) {
+	dir, err := agentDir()
+	if err != nil {
+		return nil, err
+	}
+	name := agentFileName(codespace)
+	if _, _, err := queryAgent(context.Background(), filepath.Join(dir, name+".json")); err == nil {
+		return nil, fmt.Errorf("another process already serves %s", codespace)
+	}
+	secret, err := newSessionSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	var listener net.Listener
+	var local localEndpoint
+	if useTCPLocalServices {
+		listener, err = net.Listen("tcp", localServiceHost+":0")
+		if err == nil {
+			local = localEndpoint{Network: "tcp", Address: listener.Addr().String()}
+		}
+	} else {
+		socketPath := filepath.Join(dir, name+".sock")
+		os.Remove(socketPath)
+		listener, err = net.Listen("unix", socketPath)
+		if err == nil {
+			os.Chmod(socketPath, 0o600)
+			local = localEndpoint{Network: "unix", Address: socketPath}
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for agent control requests: %w", err)
+	}
+
+	control := &agentControl{changed: make(chan struct{})}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", requireSessionSecret(secret, func(w http.ResponseWriter, r *http.Request) {
+		current := status()
+		current.Sessions = control.attachedSessions()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(current)
+	}))
+	mux.HandleFunc("/attach", requireSessionSecret(secret, func(w http.ResponseWriter, r *http.Request) {
+		control.attach(w, r)
+	}))
+	mux.HandleFunc("/stop", requireSessionSecret(secret, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		logDebug("Agent stop requested")
+		w.WriteHeader(http.StatusOK)
+		stop()
+	}))
+
+	control.recordPath = filepath.Join(dir, name+".json")
+	control.listener = listener
+	control.server = &http.Server{Handler: mux}
+	record := agentRecord{
+		Codespace: codespace,
+		PID:       os.Getpid(),
+		StartedAt: time.Now(),
+		Control:   local,
+		Secret:    secret,
+		LogDir:    getSessionLogDirectory(),
+	}
+	data, err := json.Marshal(record)
+	if err == nil {
+		err = writeAgentRecord(control.recordPath, data)
+	}
+	if err != nil {
+		// The socket path may belong to the process that published its record first
+		if unixListener, ok := listener.(*net.UnixListener); ok {
+			unixListener.SetUnlinkOnClose(false)
+		}
+		listener.Close()
+		return nil, fmt.Errorf("failed to write agent record: %w", err)
+	}
+
+	go control.server.Serve(listener)
+	return control, nil
+}
+
+// writeAgentRecord creates the record at path without replacing one published by
+// another process in the meantime. Records whose agent stopped answering are replaced.
+func writeAgentRecord(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".record-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Link(tmp.Name(), path)
+	if errors.Is(err, os.ErrExist) {
+		if _, _, queryErr := queryAgent(context.Background(), path); queryErr == nil {
+			return errors.New("another process already serves this codespace")
+		}
+		err = os.Link(tmp.Name(), path)
+	}
+	return err
+}
+
+// attach holds an attached session's lease until its request ends, which also
+// happens when the session's process dies without detaching.
+func (c *agentControl) attach(w http.ResponseWriter, r *http.Request) {
+	c.addSessions(1)
+	defer c.addSessions(-1)
+
+	w.WriteHeader(http.StatusOK)
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	<-r.Context().Done()
+}
+
+// addSessions changes the number of attached sessions by delta.
+func (c *agentControl) addSessions(delta int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sessions += delta
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// attachedSessions returns the number of sessions attached to this agent.
+func (c *agentControl) attachedSessions() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sessions
+}
+
+// waitForSessions blocks until no sessions are attached or ctx is canceled.
+func (c *agentControl) waitForSessions(ctx context.Context) {
+	for {
+		c.mu.Lock()
+		sessions, changed := c.sessions, c.changed
+		c.mu.Unlock()
+		if sessions == 0 {
+			return
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Close stops serving control requests and removes the agent's record.
+func (c *agentControl) Close() {
+	os.Remove(c.recordPath)
+	c.server.Close()
+	if addr, ok := c.listener.Addr().(*net.UnixAddr); ok {
+		os.Remove(addr.Name)
+	}
+}
+
+// runAgentCommand implements 'gh ado-codespaces agent <start|status|stop>' and
+// returns the process exit code.
+func runAgentCommand(ctx context.Context, args []string) int {
+	usage := "usage: gh ado-codespaces agent start -c <codespace> [flags]\n" +
+		"       gh ado-codespaces agent status [-c <codespace>]\n" +
+		"       gh ado-codespaces agent stop -c <codespace>\n"
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return 1
+	}
+
+	codespace := codespaceFlagValue(args[1:])
+	switch args[0] {
+	case "start":
+		if codespace == "" {
+			fmt.Fprint(os.Stderr, "agent start needs a codespace name (-c <codespace>)\n\n"+usage)
+			return 1
+		}
+		return startAgent(ctx, codespace, args[1:])
+	case "status":
+		return printAgentStatus(ctx, codespace)
+	case "stop":
+		if codespace == "" {
+			fmt.Fprint(os.Stderr, "agent stop needs a codespace name (-c <codespace>)\n\n"+usage)
+			return 1
+		}
+		return stopAgent(ctx, codespace)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown agent command %q\n\n%s", args[0], usage)
+		return 1
+	}
+}
+
+// codespaceFlagValue returns the value of -c or --codespace in args, in any of the
+// forms the flag package accepts.
+func codespaceFlagValue(args []string) string {
+	for i, arg := range args {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || (name != "c" && name != "codespace") {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// startAgent launches a detached agent for codespace with the given flags and waits
+// until it answers.
+func startAgent(ctx context.Context, codespace string, flags []string) int {
+	if status := findRunningAgent(ctx, codespace); status != nil {
+		fmt.Fprintf(os.Stderr, "Agent already running for %s (pid %d)\n", codespace, status.PID)
+		return 0
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return reportAgentError(err)
+	}
+	logDir := getLogDirectory()
+	if err := os.MkdirAll(logDir, 0o755); err != nil {
+		return reportAgentError(err)
+	}
+	logPath := filepath.Join(logDir, "agent-"+agentFileName(codespace)+".log")
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return reportAgentError(err)
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(exe, append([]string{"--agent"}, flags...)...)
+	cmd.Stdout, cmd.Stderr = logFile, logFile
+	cmd.SysProcAttr = detachedProcAttr()
+	if err := cmd.Start(); err != nil {
+		return reportAgentError(fmt.Errorf("failed to start agent: %w", err))
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	deadline := time.After(agentStartTimeout)
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-exited:
+			fmt.Fprintf(os.Stderr, "Agent for %s exited during startup (%v); see %s\n", codespace, err, logPath)
+			return 1
+		case <-deadline:
+			fmt.Fprintf(os.Stderr, "Agent for %s did not start within %s; see %s\n", codespace, agentStartTimeout, logPath)
+			return 1
+		case <-ctx.Done():
+			return 1
+		case <-ticker.C:
+			if status := findRunningAgent(ctx, codespace); status != nil {
+				fmt.Fprintf(os.Stderr, "Agent started for %s (pid %d); output is in %s\n", codespace, status.PID, logPath)
+				return 0
+			}
+		}
+	}
+}
+
+// stopAgent asks the agent for codespace to exit and waits for its record to go away.
+func stopAgent(ctx context.Context, codespace string) int {
+	path, err := agentRecordPath(codespace)
+	if err != nil {
+		return reportAgentError(err)
+	}
+	record, _, err := queryAgent(ctx, path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "No agent running for %s\n", codespace)
+		return 1
+	}
+	if err := agentRequest(ctx, record, http.MethodPost, "/stop", nil); err != nil {
+		return reportAgentError(err)
+	}
+
+	deadline := time.Now().Add(agentStopTimeout)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "Stopped agent for %s (pid %d)\n", codespace, record.PID)
+			return 0
+		}
+		if sleepContext(ctx, 200*time.Millisecond) != nil {
+			return 1
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Agent for %s (pid %d) did not exit within %s\n", codespace, record.PID, agentStopTimeout)
+	return 1
+}
+
+// printAgentStatus lists running agents, or just the one for codespace. It exits 0
+// when at least one agent is running.
+func printAgentStatus(ctx context.Context, codespace string) int {
+	var paths []string
+	if codespace != "" {
+		path, err := agentRecordPath(codespace)
+		if err != nil {
+			return reportAgentError(err)
+		}
+		paths = []string{path}
+	} else {
+		dir, err := agentDir()
+		if err != nil {
+			return reportAgentError(err)
+		}
+		paths, _ = filepath.Glob(filepath.Join(dir, "*.json"))
+	}
+
+	running := 0
+	for _, path := range paths {
+		_, status, err := queryAgent(ctx, path)
+		if err != nil {
+			continue
+		}
+		running++
+		fmt.Println(formatAgentStatus(*status, time.Now()))
+	}
+	if running == 0 {
+		if codespace != "" {
+			fmt.Printf("No agent running for %s\n", codespace)
+		} else {
+			fmt.Println("No agents running")
+		}
+		return 1
+	}
+	return 0
+}
+
+// formatAgentStatus renders one agent as a status line.
+func formatAgentStatus(status AgentStatus, now time.Time) string {
+	mode := status.Mode
+	if mode == "" {
+		mode = "agent"
+	}
+	line := fmt.Sprintf("%s\t%s\tpid %d\tup %s", status.Codespace, mode, status.PID, now.Sub(status.StartedAt).Round(time.Second))
+	if status.Sessions > 0 {
+		line += fmt.Sprintf("\t%d attached", status.Sessions)
+	}
+	if status.Metrics != nil {
+		total := 0
+		for _, n := range status.Metrics.Requests {
+			total += n
+		}
+		line += fmt.Sprintf("\t%d token requests", total)
+	}
+	if status.LogDir != "" {
+		line += "\tlogs " + status.LogDir
+	}
+	return line
+}
+
+// reportAgentError prints err and returns the failure exit code.
+func reportAgentError(err error) int {
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	return 1
+}
+
+// runAttachedSession opens an interactive SSH session that relies on a running agent's
+// or session's forwards instead of starting its own services. The session stays
+// attached until it ends, so the services outlive the session that started them.
+func runAttachedSession(ctx context.Context, args CommandLineArgs, agent *AgentStatus) {
+	fmt.Fprintf(os.Stderr, "Sharing the services of the running %s for %s (pid %d)\n", agent.Mode, args.CodespaceName, agent.PID)
+	if detach, err := attachToAgent(ctx, args.CodespaceName); err != nil {
+		logDebug("Failed to attach to pid %d: %v", agent.PID, err)
+	} else {
+		defer detach()
+	}
+
+	tmuxSession := ""
+	if args.Reconnect && len(args.RemainingArgs) == 0 {
+		tmuxSession = "gh-ado-" + uuid.NewString()[:8]
+	}
+
+	connect := func(ctx context.Context, attempt int) error {
+		sshArgs := []string{"--"}
+		if supportsX11Tunneling() {
+			sshArgs = append(sshArgs, "-Y")
+		}
+		sshArgs = append(sshArgs, "-t")
+		sshArgs = append(sshArgs, args.RemainingArgs...)
+		if tmuxSession != "" {
+			sshArgs = append(sshArgs, tmuxSessionCommand(tmuxSession)...)
+		}
+		return execSSHSession(ctx, append(args.BuildGHFlags(), sshArgs...)...)
+	}
+
+	if !args.Reconnect {
+		connect(ctx, 0)
+		return
+	}
+	newSessionSupervisor(connect).run(ctx)
+}
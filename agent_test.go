@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useTestRuntimeDir points the runtime directory at a short temporary directory, so
+// agent sockets stay within the Unix socket path limit.
+func useTestRuntimeDir(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "ado")
+	if err != nil {
+		t.Fatalf("failed to create runtime dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	t.Setenv("XDG_RUNTIME_DIR", dir)
+	return dir
+}
+
+func TestCodespaceFlagValue(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{args: []string{"-c", "fuzzy-space"}, want: "fuzzy-space"},
+		{args: []string{"--codespace", "fuzzy-space", "--feed-auth"}, want: "fuzzy-space"},
+		{args: []string{"--feed-auth", "-c=fuzzy-space"}, want: "fuzzy-space"},
+		{args: []string{"--codespace=fuzzy-space"}, want: "fuzzy-space"},
+		{args: []string{"--feed-auth"}, want: ""},
+		{args: []string{"-c"}, want: ""},
+		{args: []string{"--config", "c"}, want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := codespaceFlagValue(tt.args); got != tt.want {
+			t.Errorf("codespaceFlagValue(%v) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestAgentFileName(t *testing.T) {
+	name := agentFileName("fuzzy-space-waddle-1234567890")
+	if len(name) != 12 {
+		t.Errorf("agentFileName() = %q, want 12 characters", name)
+	}
+	if name != agentFileName("fuzzy-space-waddle-1234567890") {
+		t.Error("agentFileName() should be stable")
+	}
+	if name == agentFileName("other-codespace") {
+		t.Error("agentFileName() should differ between codespaces")
+	}
+}
+
+func TestAgentControl_StatusAndStop(t *testing.T) {
+	useTestRuntimeDir(t)
+	ctx := context.Background()
+
+	started := time.Now()
+	stopped := make(chan struct{})
+	control, err := startAgentControl("fuzzy-space", func() AgentStatus {
+		return AgentStatus{Codespace: "fuzzy-space", PID: 42, StartedAt: started}
+	}, func() { close(stopped) })
+	if err != nil {
+		t.Fatalf("startAgentControl() error = %v", err)
+	}
+	defer control.Close()
+
+	info, err := os.Stat(control.recordPath)
+	if err != nil {
+		t.Fatalf("agent record missing: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("expected agent record permissions 0600, got %o", perm)
+	}
+
+	status := findRunningAgent(ctx, "fuzzy-space")
+	if status == nil || status.PID != 42 || status.Codespace != "fuzzy-space" {
+		t.Fatalf("findRunningAgent() = %+v, want the running agent", status)
+	}
+	if findRunningAgent(ctx, "other-codespace") != nil {
+		t.Error("findRunningAgent() should not find an agent for another codespace")
+	}
+
+	record, err := readAgentRecord(control.recordPath)
+	if err != nil {
+		t.Fatalf("readAgentRecord() error = %v", err)
+	}
+	wrongSecret := *record
+	wrongSecret.Secret = "wrong"
+	if err := agentRequest(ctx, &wrongSecret, http.MethodGet, "/status", nil); err == nil {
+		t.Error("agentRequest() with the wrong secret should fail")
+	}
+
+	if err := agentRequest(ctx, record, http.MethodPost, "/stop", nil); err != nil {
+		t.Fatalf("stop request error = %v", err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("stop request did not stop the agent")
+	}
+
+	control.Close()
+	if _, err := os.Stat(control.recordPath); !os.IsNotExist(err) {
+		t.Errorf("Close() should remove the agent record, stat error = %v", err)
+	}
+	if findRunningAgent(ctx, "fuzzy-space") != nil {
+		t.Error("findRunningAgent() should not find a closed agent")
+	}
+}
+
+func TestQueryAgent_RemovesStaleRecord(t *testing.T) {
+	useTestRuntimeDir(t)
+
+	path, err := agentRecordPath("fuzzy-space")
+	if err != nil {
+		t.Fatalf("agentRecordPath() error = %v", err)
+	}
+	socketPath := filepath.Join(filepath.Dir(path), "gone.sock")
+	record := `{"codespace":"fuzzy-space","pid":1,"control":{"Network":"unix","Address":"` + socketPath + `"},"secret":"s"}`
+	if err := os.WriteFile(path, []byte(record), 0o600); err != nil {
+		t.Fatalf("failed to write record: %v", err)
+	}
+
+	if _, _, err := queryAgent(context.Background(), path); err == nil {
+		t.Fatal("queryAgent() should fail for an agent that isn't running")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("queryAgent() should remove the stale record, stat error = %v", err)
+	}
+}
+
+func TestFormatAgentStatus(t *testing.T) {
+	started := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	status := AgentStatus{
+		Codespace: "fuzzy-space",
+		PID:       42,
+		StartedAt: started,
+		Metrics:   &MetricsInfo{Requests: map[string]int{"getAccessToken": 3, "getCredential": 2}},
+	}
+
+	got := formatAgentStatus(status, started.Add(90*time.Minute))
+	for _, want := range []string{"fuzzy-space", "pid 42", "up 1h30m0s", "5 token requests"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("formatAgentStatus() = %q, want it to contain %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// detachedProcAttr starts the agent without a console, in its own process group, so it
+// outlives the terminal that ran 'agent start'.
+func detachedProcAttr() *syscall.SysProcAttr {
+	const detachedProcess = 0x00000008
+	return &syscall.SysProcAttr{CreationFlags: detachedProcess | syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// processRunning reports whether a process with pid exists. FindProcess opens the
+// process on Windows, so it fails once the process is gone.
+func processRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release()
+	return true
+}
@@ -11,36 +11,51 @@ const localServiceHost = "127.0.0.1"
 
 // CommandLineArgs holds all the command line arguments
 type CommandLineArgs struct {
+	Agent               bool
 	CodespaceName       string
 	Config              bool
 	Debug               bool
 	DebugFile           string
+	FeedAuth            bool
 	AzureSubscriptionId string
+	AzureTenantId       string
+	AzLogin             bool
 	Logs                bool
 	Profile             string
+	Reconnect           bool
 	Repo                string
 	RepoOwner           string
 	ServerPort          int
+	Stats               bool
+	TCPServices         bool
 	RemainingArgs       []string
 }
 
 // ParseArgs parses command line arguments and returns a CommandLineArgs struct
 func ParseArgs() CommandLineArgs {
+	agent := flag.Bool("agent", false, "Run as a background agent without a shell (used by 'agent start')")
 	codespaceName := flag.String("codespace", "", "Name of the codespace")
 	cFlag := flag.String("c", "", "Name of the codespace (shorthand for --codespace)")
 	configFlag := flag.Bool("config", false, "Write OpenSSH configuration to stdout")
 	debugFlag := flag.Bool("debug", false, "Log debug data to a file")
 	dFlag := flag.Bool("d", false, "Log debug data to a file (shorthand for --debug)")
 	debugFile := flag.String("debug-file", "", "Path of the file log to")
+	feedAuth := flag.Bool("feed-auth", false, "Install npm, NuGet, pip and twine shims in the codespace that authenticate Azure Artifacts feeds")
 	logsFlag := flag.Bool("logs", false, "List recent log files and exit")
 	azureSub := flag.String("azure-subscription-id", "", "Azure subscription ID to use for authentication (persisted per GitHub account)")
 	// Allow an alternate flag name without -id suffix for convenience
 	azureSubAlt := flag.String("azure-subscription", "", "Azure subscription ID to use for authentication (alias of --azure-subscription-id)")
+	azureTenant := flag.String("azure-tenant-id", "", "Azure tenant ID to request tokens from (persisted per GitHub account)")
+	azLogin := flag.Bool("az-login", false, "Run 'az login' interactively when the Azure CLI can't get a token at startup")
+	azureTenantAlt := flag.String("azure-tenant", "", "Azure tenant ID to request tokens from (alias of --azure-tenant-id)")
 	profile := flag.String("profile", "", "Name of the SSH profile to use")
+	reconnect := flag.Bool("reconnect", false, "Reconnect with backoff when the SSH connection drops, keeping local services running and reattaching to tmux")
 	repo := flag.String("repo", "", "Filter codespace selection by repository name (user/repo)")
 	RFlag := flag.String("R", "", "Filter codespace selection by repository name (user/repo) (shorthand for --repo)")
 	repoOwner := flag.String("repo-owner", "", "Filter codespace selection by repository owner (username or org)")
 	serverPort := flag.Int("server-port", 0, "SSH server port number (0 => pick unused)")
+	stats := flag.Bool("stats", false, "Print token request counts, cache hits, failures and latency when the session ends")
+	tcpServices := flag.Bool("tcp-services", false, "Serve the local auth, browser and notification services on loopback TCP instead of private Unix sockets")
 
 	flag.Parse()
 
@@ -63,17 +78,30 @@ func ParseArgs() CommandLineArgs {
 		actualAzureSub = *azureSubAlt
 	}
 
+	// Resolve azure tenant flag precedence (primary then alias)
+	actualAzureTenant := *azureTenant
+	if actualAzureTenant == "" && *azureTenantAlt != "" {
+		actualAzureTenant = *azureTenantAlt
+	}
+
 	return CommandLineArgs{
+		Agent:               *agent,
 		CodespaceName:       actualCodespaceName,
 		Config:              *configFlag,
 		Debug:               actualDebug,
 		DebugFile:           *debugFile,
+		FeedAuth:            *feedAuth,
 		AzureSubscriptionId: strings.TrimSpace(actualAzureSub),
+		AzureTenantId:       strings.TrimSpace(actualAzureTenant),
+		AzLogin:             *azLogin,
 		Logs:                *logsFlag,
 		Profile:             *profile,
+		Reconnect:           *reconnect,
 		Repo:                actualRepo,
 		RepoOwner:           *repoOwner,
 		ServerPort:          *serverPort,
+		Stats:               *stats,
+		TCPServices:         *tcpServices,
 		RemainingArgs:       flag.Args(),
 	}
 }
@@ -118,23 +146,20 @@ func (args *CommandLineArgs) BuildGHFlags() []string {
 }
 
 // BuildSSHArgs builds the arguments for the SSH command
-func (args *CommandLineArgs) BuildSSHArgs(socketPath string, port int, browserService *BrowserService, notificationService *NotificationService) []string {
+func (args *CommandLineArgs) BuildSSHArgs(socketPath string, local localEndpoint, browserService *BrowserService, notificationService *NotificationService) []string {
 	sshArgs := []string{"--"} // Start with the separator
 
 	// Add the auth socket forward
-	forwardSpec := fmt.Sprintf("%s:%s:%d", socketPath, localServiceHost, port)
-	sshArgs = append(sshArgs, "-R", forwardSpec)
+	sshArgs = append(sshArgs, "-R", socketForwardSpec(socketPath, local))
 
 	// Add browser socket forward if browser service is available
 	if browserService != nil {
-		browserForwardSpec := fmt.Sprintf("%s:%s:%d", browserService.SocketPath, localServiceHost, browserService.Port)
-		sshArgs = append(sshArgs, "-R", browserForwardSpec)
+		sshArgs = append(sshArgs, "-R", socketForwardSpec(browserService.SocketPath, browserService.Local))
 	}
 
 	// Add notification socket forward if notification service is available
 	if notificationService != nil {
-		notificationForwardSpec := fmt.Sprintf("%s:%s:%d", notificationService.SocketPath, localServiceHost, notificationService.Port)
-		sshArgs = append(sshArgs, "-R", notificationForwardSpec)
+		sshArgs = append(sshArgs, "-R", socketForwardSpec(notificationService.SocketPath, notificationService.Local))
 	}
 
 	// Detect and add reverse port forwards for local AI services
@@ -145,6 +170,11 @@ func (args *CommandLineArgs) BuildSSHArgs(socketPath string, port int, browserSe
 		sshArgs = append(sshArgs, reverseArgs...)
 	}
 
+	// An agent only keeps the forwards up; it has no terminal and runs no command
+	if args.Agent {
+		return append(sshArgs, "-N")
+	}
+
 	if supportsX11Tunneling() {
 		sshArgs = append(sshArgs, "-Y")
 	}
@@ -157,6 +187,12 @@ func (args *CommandLineArgs) BuildSSHArgs(socketPath string, port int, browserSe
 	return sshArgs
 }
 
+// socketForwardSpec builds an ssh -R spec forwarding the remote socket to a local
+// service. OpenSSH accepts both socket-to-socket and socket-to-host:port forwards.
+func socketForwardSpec(remoteSocket string, local localEndpoint) string {
+	return remoteSocket + ":" + local.ForwardTarget()
+}
+
 // supportsX11Tunneling reports whether the host has an X11 display available for forwarding.
 func supportsX11Tunneling() bool {
 	display, present := os.LookupEnv("DISPLAY")
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"fmt"
 	"os"
 	"testing"
 )
@@ -97,30 +96,40 @@ func TestCommandLineArgs_BuildGHFlags(t *testing.T) {
 
 func TestCommandLineArgs_BuildSSHArgs(t *testing.T) {
 	tests := []struct {
-		name       string
-		args       CommandLineArgs
-		socketPath string
-		port       int
+		name                  string
+		args                  CommandLineArgs
+		socketPath            string
+		local                 localEndpoint
+		expectedSocketForward string
 	}{
 		{
-			name:       "basic SSH args",
-			args:       CommandLineArgs{},
-			socketPath: "/tmp/socket",
-			port:       8080,
+			name:                  "basic SSH args",
+			args:                  CommandLineArgs{},
+			socketPath:            "/tmp/socket",
+			local:                 localEndpoint{Network: "unix", Address: "/run/user/1000/gh-ado-1000/abcd/auth.sock"},
+			expectedSocketForward: "/tmp/socket:/run/user/1000/gh-ado-1000/abcd/auth.sock",
 		},
 		{
 			name: "with remaining args",
 			args: CommandLineArgs{
 				RemainingArgs: []string{"echo", "hello"},
 			},
-			socketPath: "/tmp/socket",
-			port:       9090,
+			socketPath:            "/tmp/socket",
+			local:                 localEndpoint{Network: "unix", Address: "/tmp/gh-ado-1000/abcd/auth.sock"},
+			expectedSocketForward: "/tmp/socket:/tmp/gh-ado-1000/abcd/auth.sock",
+		},
+		{
+			name:                  "TCP fallback",
+			args:                  CommandLineArgs{},
+			socketPath:            "/tmp/socket",
+			local:                 localEndpoint{Network: "tcp", Address: localServiceHost + ":9090"},
+			expectedSocketForward: "/tmp/socket:" + localServiceHost + ":9090",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := tt.args.BuildSSHArgs(tt.socketPath, tt.port, nil, nil)
+			result := tt.args.BuildSSHArgs(tt.socketPath, tt.local, nil, nil)
 			// Check that result starts with "--"
 			if len(result) < 1 || result[0] != "--" {
 				t.Errorf("BuildSSHArgs() should start with '--', got %v", result)
@@ -129,7 +138,7 @@ func TestCommandLineArgs_BuildSSHArgs(t *testing.T) {
 
 			// Check that the socket forward is present
 			foundSocketForward := false
-			expectedSocketForward := tt.socketPath + ":" + localServiceHost + ":" + fmt.Sprint(tt.port)
+			expectedSocketForward := tt.expectedSocketForward
 			for i := 0; i < len(result)-1; i++ {
 				if result[i] == "-R" && result[i+1] == expectedSocketForward {
 					foundSocketForward = true
@@ -206,7 +215,7 @@ func TestCommandLineArgs_BuildSSHArgsWithX11Tunneling(t *testing.T) {
 	t.Setenv("DISPLAY", ":0")
 
 	args := CommandLineArgs{}
-	sshArgs := args.BuildSSHArgs("/tmp/socket", 8080, nil, nil)
+	sshArgs := args.BuildSSHArgs("/tmp/socket", localEndpoint{Network: "unix", Address: "/tmp/gh-ado-1000/abcd/auth.sock"}, nil, nil)
 	want := []string{"-Y", "-t"}
 
 	for i := 0; i <= len(sshArgs)-len(want); i++ {
@@ -225,6 +234,22 @@ func TestCommandLineArgs_BuildSSHArgsWithX11Tunneling(t *testing.T) {
 	t.Errorf("BuildSSHArgs() = %v, want X11 options %v", sshArgs, want)
 }
 
+func TestCommandLineArgs_BuildSSHArgsForAgent(t *testing.T) {
+	t.Setenv("DISPLAY", ":0")
+
+	args := CommandLineArgs{Agent: true, RemainingArgs: []string{"echo", "hello"}}
+	sshArgs := args.BuildSSHArgs("/tmp/socket", testAuthEndpoint, nil, nil)
+
+	if last := sshArgs[len(sshArgs)-1]; last != "-N" {
+		t.Errorf("BuildSSHArgs() should end with -N for an agent, got %v", sshArgs)
+	}
+	for _, arg := range sshArgs {
+		if arg == "-t" || arg == "-Y" || arg == "echo" {
+			t.Errorf("BuildSSHArgs() for an agent should not contain %q, got %v", arg, sshArgs)
+		}
+	}
+}
+
 // Test helper function to capture os.Args manipulation
 func withArgs(args []string, fn func()) {
 	oldArgs := os.Args
@@ -243,12 +268,18 @@ func TestParseArgs_StructFields(t *testing.T) {
 		Config:              true,
 		Debug:               true,
 		DebugFile:           "test.log",
+		FeedAuth:            true,
 		AzureSubscriptionId: "test-sub",
+		AzureTenantId:       "test-tenant",
+		AzLogin:             true,
 		Logs:                true,
 		Profile:             "test-profile",
+		Reconnect:           true,
 		Repo:                "test/repo",
 		RepoOwner:           "test-owner",
 		ServerPort:          8080,
+		Stats:               true,
+		TCPServices:         true,
 		RemainingArgs:       []string{"arg1", "arg2"},
 	}
 
@@ -265,15 +296,27 @@ func TestParseArgs_StructFields(t *testing.T) {
 	if args.DebugFile != "test.log" {
 		t.Errorf("Expected DebugFile to be 'test.log', got %s", args.DebugFile)
 	}
+	if !args.FeedAuth {
+		t.Error("Expected FeedAuth to be true")
+	}
 	if args.AzureSubscriptionId != "test-sub" {
 		t.Errorf("Expected AzureSubscriptionId to be 'test-sub', got %s", args.AzureSubscriptionId)
 	}
+	if args.AzureTenantId != "test-tenant" {
+		t.Errorf("Expected AzureTenantId to be 'test-tenant', got %s", args.AzureTenantId)
+	}
+	if !args.AzLogin {
+		t.Error("Expected AzLogin to be true")
+	}
 	if !args.Logs {
 		t.Error("Expected Logs to be true")
 	}
 	if args.Profile != "test-profile" {
 		t.Errorf("Expected Profile to be 'test-profile', got %s", args.Profile)
 	}
+	if !args.Reconnect {
+		t.Error("Expected Reconnect to be true")
+	}
 	if args.Repo != "test/repo" {
 		t.Errorf("Expected Repo to be 'test/repo', got %s", args.Repo)
 	}
@@ -283,6 +326,12 @@ func TestParseArgs_StructFields(t *testing.T) {
 	if args.ServerPort != 8080 {
 		t.Errorf("Expected ServerPort to be 8080, got %d", args.ServerPort)
 	}
+	if !args.Stats {
+		t.Error("Expected Stats to be true")
+	}
+	if !args.TCPServices {
+		t.Error("Expected TCPServices to be true")
+	}
 	if len(args.RemainingArgs) != 2 || args.RemainingArgs[0] != "arg1" || args.RemainingArgs[1] != "arg2" {
 		t.Errorf("Expected RemainingArgs to be ['arg1', 'arg2'], got %v", args.RemainingArgs)
 	}
@@ -1,45 +1,126 @@
 package main
 
 import (
-	"context"
-	_ "embed"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
 	"fmt"
-	"os"
-
-	"github.com/cli/go-gh/v2"
+	"io"
+	"slices"
+	"strings"
 )
 
-//go:embed ado-auth-helper.py
-var adoAuthHelperScript string
+//go:generate go run gen-helper.go
+
+// helperBinaries holds gzip-compressed linux builds of cmd/ado-auth-helper produced by
+// go generate. The files are named explicitly so a build without them fails with "no
+// matching files found" instead of shipping an extension that can't install the
+// helper; run 'go generate ./...' first.
+//
+//go:embed helperbin/ado-auth-helper-linux-amd64.gz helperbin/ado-auth-helper-linux-arm64.gz
+var helperBinaries embed.FS
+
+// authHelperArchitectures maps `uname -m` patterns in the codespace to helper builds.
+var authHelperArchitectures = []struct {
+	unamePattern string
+	goarch       string
+}{
+	{unamePattern: "x86_64|amd64", goarch: "amd64"},
+	{unamePattern: "aarch64|arm64", goarch: "arm64"},
+}
+
+// authHelperSkippedWarning starts the line the preparation script prints when it has
+// no helper build for the codespace's architecture.
+const authHelperSkippedWarning = "Warning: ado-auth-helper not installed: "
 
-// uploadAuthHelperFiles uploads the ADO and Azure auth helper scripts to the specified codespace.
-func uploadAuthHelperFiles(ctx context.Context, codespaceName string) error {
-	// Create temporary files for both helper scripts
-	adoHelperFile, err := os.CreateTemp("", "ado-auth-helper*.py")
+// gzippedAuthHelper returns the gzip-compressed helper binary for goarch.
+func gzippedAuthHelper(goarch string) ([]byte, error) {
+	gz, err := helperBinaries.ReadFile("helperbin/ado-auth-helper-linux-" + goarch + ".gz")
 	if err != nil {
-		return fmt.Errorf("failed to create temporary file for ADO helper: %w", err)
+		return nil, fmt.Errorf("no ado-auth-helper build for linux/%s: %w", goarch, err)
 	}
-	defer os.Remove(adoHelperFile.Name())
+	return gz, nil
+}
 
-	// Both helpers use the same script
-	if _, err = adoHelperFile.WriteString(adoAuthHelperScript); err != nil {
-		return fmt.Errorf("failed to write ADO helper script to temporary file: %w", err)
+// authHelperGoarch returns the helper build for a codespace's `uname -m`.
+func authHelperGoarch(machine string) (string, bool) {
+	for _, arch := range authHelperArchitectures {
+		if slices.Contains(strings.Split(arch.unamePattern, "|"), machine) {
+			return arch.goarch, true
+		}
 	}
-	adoHelperFile.Close()
+	return "", false
+}
 
-	// Upload the ADO helper script
-	args := []string{"codespace", "cp", "-c", codespaceName, "-e", adoHelperFile.Name(), "remote:~/ado-auth-helper"}
-	_, stderr, err := gh.Exec(args...)
+// authHelperSHA256 returns the hex SHA-256 of the uncompressed helper for goarch, as
+// sha256sum reports it for the installed helper.
+func authHelperSHA256(goarch string) (string, error) {
+	gz, err := gzippedAuthHelper(goarch)
 	if err != nil {
-		return fmt.Errorf("error copying ADO helper script to codespace: %w\nStderr: %s", err, stderr.String())
+		return "", err
 	}
-
-	// Upload the same script as Azure helper
-	args = []string{"codespace", "cp", "-c", codespaceName, "-e", adoHelperFile.Name(), "remote:~/azure-auth-helper"}
-	_, stderr, err = gh.Exec(args...)
+	zr, err := gzip.NewReader(bytes.NewReader(gz))
 	if err != nil {
-		return fmt.Errorf("error copying Azure helper script to codespace: %w\nStderr: %s", err, stderr.String())
+		return "", err
 	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, zr); err != nil {
+		return "", fmt.Errorf("decompress ado-auth-helper for linux/%s: %w", goarch, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
 
-	return nil
+// Prefixes of the lines authHelperProbeScript prints. They keep the values apart from
+// anything the login shell prints.
+const (
+	authHelperProbeArch   = "gh-ado-arch="
+	authHelperProbeSHA256 = "gh-ado-helper-sha256="
+)
+
+// authHelperProbeScript prints the codespace's architecture and the hash of the
+// installed helper, if there is one.
+const authHelperProbeScript = `printf 'gh-ado-arch=%s\n' "$(uname -m)"
+if [ -f ~/ado-auth-helper ] && command -v sha256sum >/dev/null 2>&1; then
+printf 'gh-ado-helper-sha256=%s\n' "$(sha256sum < ~/ado-auth-helper | cut -d ' ' -f 1)"
+fi
+`
+
+// parseAuthHelperProbe reads the architecture and installed helper hash from the
+// output of authHelperProbeScript.
+func parseAuthHelperProbe(output string) (machine, installedSHA256 string) {
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if value, ok := strings.CutPrefix(line, authHelperProbeArch); ok {
+			machine = value
+		} else if value, ok := strings.CutPrefix(line, authHelperProbeSHA256); ok {
+			installedSHA256 = value
+		}
+	}
+	return machine, installedSHA256
+}
+
+// feedShimDir holds the package tool shims in the codespace. Each one links to the
+// helper, which finds the real tool further along PATH.
+const feedShimDir = "~/.gh-ado/shims"
+
+// feedShimTools lists the tools cmd/ado-auth-helper can stand in for to authenticate
+// Azure Artifacts feeds. It must match the helper's shimTools.
+var feedShimTools = []string{"npm", "npx", "dotnet", "nuget", "pip", "pip3", "twine"}
+
+// buildFeedShimInstallCommand returns a command that links each feed shim to the helper
+// and puts feedShimDir at the front of PATH for new bash and zsh shells.
+func buildFeedShimInstallCommand() string {
+	var b strings.Builder
+	b.WriteString("mkdir -p " + feedShimDir)
+	for _, tool := range feedShimTools {
+		fmt.Fprintf(&b, " && ln -sf ~/ado-auth-helper %s/%s", feedShimDir, tool)
+	}
+	pathLine := quoteForShell(`export PATH="$HOME/.gh-ado/shims:$PATH"`)
+	fmt.Fprintf(&b, "\n(grep -qs '.gh-ado/shims' ~/.bashrc || printf '%%s\\n' %s >> ~/.bashrc)", pathLine)
+	fmt.Fprintf(&b, "\n(test ! -f ~/.zshrc || grep -qs '.gh-ado/shims' ~/.zshrc || printf '%%s\\n' %s >> ~/.zshrc)", pathLine)
+	return b.String()
 }
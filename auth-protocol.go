@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// defaultADOScope is the Azure DevOps resource scope requested when a client doesn't specify one.
+const defaultADOScope = "499b84ac-1321-427f-aa17-267ca6975798/.default"
+
+// Error codes returned to auth clients in ErrorResponse.Data.Code.
+const (
+	authErrNotLoggedIn    = "not_logged_in"
+	authErrWrongTenant    = "wrong_tenant"
+	authErrScopeRejected  = "scope_rejected"
+	authErrScopeDenied    = "scope_denied"
+	authErrAzMissing      = "az_missing"
+	authErrInvalidRequest = "invalid_request"
+	authErrUnknownType    = "unknown_type"
+	authErrUnavailable    = "token_unavailable"
+	authErrUnauthorized   = "unauthorized"
+	authErrNoProvider     = "no_provider"
+)
+
+// TokenRequest is a message sent by auth clients over the \f-delimited protocol.
+type TokenRequest struct {
+	Type string `json:"type"`
+	// Secret is the per-session secret the codespace reads from ~/.gh-ado/secrets.
+	Secret string `json:"secret,omitempty"`
+	Data   struct {
+		Scopes *string `json:"scopes"`
+		// Organization is the ADO organization the token is for, used to pick a tenant/credential.
+		Organization string `json:"organization,omitempty"`
+		// Host is the host a getCredential request wants credentials for.
+		Host string `json:"host,omitempty"`
+	} `json:"data"`
+}
+
+// TokenResponse carries an access token back to the client. ExpiresOn is a Unix
+// timestamp in seconds so clients know when to request a new token.
+type TokenResponse struct {
+	Type      string `json:"type"`
+	Data      string `json:"data"`
+	ExpiresOn int64  `json:"expiresOn,omitempty"`
+	TokenType string `json:"tokenType,omitempty"`
+}
+
+// newTokenResponse builds a response carrying token and its expiry.
+func newTokenResponse(token azcore.AccessToken) TokenResponse {
+	resp := TokenResponse{
+		Type:      "accessToken",
+		Data:      token.Token,
+		TokenType: "Bearer",
+	}
+	if !token.ExpiresOn.IsZero() {
+		resp.ExpiresOn = token.ExpiresOn.Unix()
+	}
+	return resp
+}
+
+// CredentialResponse answers a getCredential request with a username and password
+// for git or other tools. ExpiresOn is a Unix timestamp in seconds.
+type CredentialResponse struct {
+	Type      string         `json:"type"`
+	Data      CredentialData `json:"data"`
+	ExpiresOn int64          `json:"expiresOn,omitempty"`
+}
+
+// CredentialData is the credential in a CredentialResponse.
+type CredentialData struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// newCredentialResponse builds a response carrying cred, which expires with token.
+func newCredentialResponse(cred gitCredential, token azcore.AccessToken) CredentialResponse {
+	resp := CredentialResponse{
+		Type: "credential",
+		Data: CredentialData{Username: cred.Username, Password: cred.Password},
+	}
+	if !token.ExpiresOn.IsZero() {
+		resp.ExpiresOn = token.ExpiresOn.Unix()
+	}
+	return resp
+}
+
+// StatusInfo describes the auth server in response to ping and status requests.
+// CanMintToken, TokenError and Metrics are only set for status requests, which try to
+// get a token for the default ADO scope.
+type StatusInfo struct {
+	Version           string       `json:"version"`
+	SessionID         string       `json:"sessionId"`
+	Login             string       `json:"login,omitempty"`
+	Subscription      string       `json:"subscription,omitempty"`
+	Tenant            string       `json:"tenant,omitempty"`
+	CredentialSources []string     `json:"credentialSources,omitempty"`
+	ActiveCredential  string       `json:"activeCredential,omitempty"`
+	CanMintToken      *bool        `json:"canMintToken,omitempty"`
+	TokenError        *AuthError   `json:"tokenError,omitempty"`
+	Metrics           *MetricsInfo `json:"metrics,omitempty"`
+}
+
+// MetricsInfo counts the token requests a session has served. Requests are keyed by
+// space-separated scopes and failures by error code.
+type MetricsInfo struct {
+	Requests    map[string]int `json:"requests"`
+	Failures    map[string]int `json:"failures"`
+	CacheHits   int            `json:"cacheHits"`
+	CacheMisses int            `json:"cacheMisses"`
+	// GetToken is how long requests waited for a token; Mint covers only new tokens
+	// from the credential chain.
+	GetToken LatencyStats `json:"getToken"`
+	Mint     LatencyStats `json:"mint"`
+}
+
+// LatencyStats summarizes recent latencies in milliseconds.
+type LatencyStats struct {
+	Count int     `json:"count"`
+	P50Ms float64 `json:"p50Ms"`
+	P95Ms float64 `json:"p95Ms"`
+	MaxMs float64 `json:"maxMs"`
+}
+
+// StatusResponse answers ping and status requests.
+type StatusResponse struct {
+	Type string     `json:"type"`
+	Data StatusInfo `json:"data"`
+}
+
+// AuthError describes why a request could not be served.
+type AuthError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ErrorResponse reports a failed request so clients can fail fast instead of timing out.
+type ErrorResponse struct {
+	Type string    `json:"type"`
+	Data AuthError `json:"data"`
+}
+
+// newErrorResponse builds an error response with the given code and message.
+func newErrorResponse(code, message string) ErrorResponse {
+	return ErrorResponse{Type: "error", Data: AuthError{Code: code, Message: message}}
+}
+
+// writeAuthResponse marshals v and writes it to the client followed by the \f delimiter.
+func writeAuthResponse(writer *bufio.Writer, v interface{}) error {
+	respBytes, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal response: %w", err)
+	}
+	if _, err := writer.Write(append(respBytes, '\f')); err != nil {
+		return fmt.Errorf("write response: %w", err)
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("flush response: %w", err)
+	}
+	return nil
+}
+
+// classifyTokenError maps a credential error to a machine-readable code and a human message.
+func classifyTokenError(err error, scopes []string) AuthError {
+	detail := summarizeError(err)
+	lower := strings.ToLower(err.Error())
+	scope := strings.Join(scopes, " ")
+
+	// Tenant and scope errors are checked before the generic login hint because
+	// az often suggests 'az login' alongside those AADSTS codes.
+	switch {
+	case strings.Contains(lower, "azure cli not found"),
+		strings.Contains(lower, "executable file not found"):
+		return AuthError{
+			Code:    authErrAzMissing,
+			Message: "Azure CLI (az) was not found on the local machine. Install it and run 'az login'.",
+		}
+	case strings.Contains(lower, "aadsts90002"), // tenant not found
+		strings.Contains(lower, "aadsts50020"),  // user not in tenant
+		strings.Contains(lower, "aadsts700016"), // application not found in tenant
+		strings.Contains(lower, "aadsts50128"):  // invalid domain name
+		return AuthError{
+			Code:    authErrWrongTenant,
+			Message: fmt.Sprintf("The Azure CLI tenant can't issue this token. Log in to the tenant that owns the resource with 'az login --tenant <tenant>'. (%s)", detail),
+		}
+	case strings.Contains(lower, "aadsts500011"), // resource principal not found
+		strings.Contains(lower, "aadsts70011"), // invalid scope
+		strings.Contains(lower, "aadsts65001"), // consent required
+		strings.Contains(lower, "aadsts28000"), // invalid scope combination
+		strings.Contains(lower, "aadsts28003"): // empty scope
+		return AuthError{
+			Code:    authErrScopeRejected,
+			Message: fmt.Sprintf("Microsoft Entra ID rejected the requested scope '%s'. (%s)", scope, detail),
+		}
+	case strings.Contains(lower, "az login"),
+		strings.Contains(lower, "aadsts700082"), // refresh token expired
+		strings.Contains(lower, "aadsts50173"),  // grant expired after password change
+		strings.Contains(lower, "aadsts50078"),  // MFA re-authentication required
+		strings.Contains(lower, "interaction_required"):
+		return AuthError{
+			Code:    authErrNotLoggedIn,
+			Message: fmt.Sprintf("Azure CLI is not logged in on the local machine. Run 'az login --scope %s' and retry. (%s)", scope, detail),
+		}
+	default:
+		return AuthError{
+			Code:    authErrUnavailable,
+			Message: fmt.Sprintf("Failed to get an access token for scope '%s': %s", scope, detail),
+		}
+	}
+}
+
+// summarizeError returns the first non-empty line of err, truncated for display.
+func summarizeError(err error) string {
+	const maxLen = 300
+
+	summary := ""
+	for _, line := range strings.Split(err.Error(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			summary = line
+			break
+		}
+	}
+	if len(summary) > maxLen {
+		summary = summary[:maxLen-3] + "..."
+	}
+	return summary
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestClassifyTokenError(t *testing.T) {
+	scopes := []string{defaultADOScope}
+
+	tests := []struct {
+		name     string
+		err      error
+		wantCode string
+	}{
+		{
+			name:     "az missing",
+			err:      errors.New("AzureCLICredential: Azure CLI not found on path"),
+			wantCode: authErrAzMissing,
+		},
+		{
+			name:     "not logged in",
+			err:      errors.New("AzureCLICredential: ERROR: Please run 'az login' to setup account."),
+			wantCode: authErrNotLoggedIn,
+		},
+		{
+			name:     "refresh token expired",
+			err:      errors.New("AADSTS700082: The refresh token has expired due to inactivity."),
+			wantCode: authErrNotLoggedIn,
+		},
+		{
+			name:     "wrong tenant wins over login hint",
+			err:      errors.New("AADSTS50020: User account does not exist in tenant.\nRun 'az login --tenant contoso' to authenticate."),
+			wantCode: authErrWrongTenant,
+		},
+		{
+			name:     "scope rejected",
+			err:      errors.New("AADSTS500011: The resource principal named https://example was not found in the tenant."),
+			wantCode: authErrScopeRejected,
+		},
+		{
+			name:     "unknown failure",
+			err:      errors.New("something unexpected"),
+			wantCode: authErrUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classifyTokenError(tt.err, scopes)
+			if got.Code != tt.wantCode {
+				t.Errorf("classifyTokenError() code = %q, want %q", got.Code, tt.wantCode)
+			}
+			if got.Message == "" {
+				t.Error("classifyTokenError() returned empty message")
+			}
+		})
+	}
+}
+
+func TestSummarizeError(t *testing.T) {
+	got := summarizeError(errors.New("\n  first line  \nsecond line"))
+	if got != "first line" {
+		t.Errorf("summarizeError() = %q, want %q", got, "first line")
+	}
+
+	long := summarizeError(errors.New(strings.Repeat("x", 500)))
+	if len(long) != 300 || !strings.HasSuffix(long, "...") {
+		t.Errorf("summarizeError() should truncate long lines, got length %d", len(long))
+	}
+}
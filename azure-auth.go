@@ -6,15 +6,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"maps"
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/google/uuid"
 )
 
@@ -44,7 +46,8 @@ func initAuthLogger() error {
 	logPath := getSessionLogPath("azure-auth.log")
 
 	var err error
-	authLogFile, err = os.Create(logPath)
+	// The log names clients and scopes, so only the current user can read it
+	authLogFile, err = os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "CRITICAL: Failed to create auth log file '%s': %v\\n", logPath, err)
 		return fmt.Errorf("failed to create auth log file: %w", err)
@@ -67,23 +70,51 @@ func logAuthMessage(format string, args ...interface{}) {
 	}
 }
 
-// startServer initializes and starts the local TCP server for authentication.
+// authServer holds the state shared by all connections to the local auth server.
+type authServer struct {
+	creds     *credentialRouter
+	policy    *scopePolicy
+	providers *providerRegistry
+	approver  *scopeApprover
+	audit     *tokenAuditLog
+	metrics   *tokenMetrics
+	alerts    *loginAlerter
+
+	// secret must be sent with every request; see newSessionSecret.
+	secret string
+
+	// info is the static part of status responses; chain reports the active source.
+	info  StatusInfo
+	chain *credentialChain
+
+	// sessionID is only known once a codespace is selected, after the server starts.
+	sessionID atomic.Pointer[string]
+}
+
+// setSessionID records the session ID reported in status responses.
+func (s *authServer) setSessionID(id string) {
+	s.sessionID.Store(&id)
+}
+
+// statusTokenTimeout bounds the token check made for status requests.
+const statusTokenTimeout = 30 * time.Second
+
+// startServer initializes and starts the local server for authentication.
 // It now takes a context for cancellation.
-func startServer(ctx context.Context, cred azcore.TokenCredential) (net.Listener, int, error) {
-	listener, err := net.Listen("tcp", localServiceHost+":0")
+func startServer(ctx context.Context, server *authServer) (net.Listener, localEndpoint, error) {
+	listener, local, err := listenLocalService("auth")
 	if err != nil {
 		// logAuthMessage already called by SetupServer if this fails
-		return nil, 0, fmt.Errorf("failed to start local server: %w", err)
+		return nil, localEndpoint{}, fmt.Errorf("failed to start local server: %w", err)
 	}
 
-	port := listener.Addr().(*net.TCPAddr).Port
-	logAuthMessage("Local auth server listening on port %d", port)
+	logAuthMessage("Local auth server listening on %s", local)
 
 	go func() {
 		for {
 			select {
 			case <-ctx.Done():
-				logAuthMessage("Server context for port %d canceled, stopping accept loop.", port)
+				logAuthMessage("Server context for %s canceled, stopping accept loop.", local)
 				listener.Close() // Ensure listener is closed when context is done
 				return
 			default:
@@ -93,44 +124,32 @@ func startServer(ctx context.Context, cred azcore.TokenCredential) (net.Listener
 			if err != nil {
 				select {
 				case <-ctx.Done():
-					logAuthMessage("Accept loop for port %d: context canceled during Accept(): %v", port, err)
+					logAuthMessage("Accept loop for %s: context canceled during Accept(): %v", local, err)
 					return // Exit goroutine
 				default:
 					if strings.Contains(err.Error(), "use of closed network connection") {
-						logAuthMessage("Accept loop for port %d: Listener closed normally.", port)
+						logAuthMessage("Accept loop for %s: Listener closed normally.", local)
 					} else if ne, ok := err.(net.Error); ok && ne.Temporary() {
-						logAuthMessage("Temporary error accepting on port %d: %v. Retrying.", port, err)
+						logAuthMessage("Temporary error accepting on %s: %v. Retrying.", local, err)
 						time.Sleep(100 * time.Millisecond) // Brief pause
 						continue
 					} else {
-						logAuthMessage("Persistent error accepting on port %d: %v. Stopping loop.", port, err)
+						logAuthMessage("Persistent error accepting on %s: %v. Stopping loop.", local, err)
 					}
 					return // Stop loop for persistent or non-temporary errors
 				}
 			}
-			logAuthMessage("Accepted new connection from %s on port %d", conn.RemoteAddr().String(), port)
-			go handleConnection(ctx, conn, cred) // Pass context
+			logAuthMessage("Accepted new connection from %s on %s", conn.RemoteAddr().String(), local)
+			go server.handleConnection(ctx, conn) // Pass context
 		}
 	}()
 
-	return listener, port, nil
-}
-
-type TokenRequest struct {
-	Type string `json:"type"`
-	Data struct {
-		Scopes *string `json:"scopes"`
-	} `json:"data"`
-}
-
-type TokenResponse struct {
-	Type string `json:"type"`
-	Data string `json:"data"`
+	return listener, local, nil
 }
 
 // handleConnection processes a single client connection.
 // It now takes a context for cancellation.
-func handleConnection(ctx context.Context, conn net.Conn, cred azcore.TokenCredential) {
+func (s *authServer) handleConnection(ctx context.Context, conn net.Conn) {
 	defer conn.Close()
 	reader := bufio.NewReader(conn)
 	writer := bufio.NewWriter(conn)
@@ -162,80 +181,298 @@ func handleConnection(ctx context.Context, conn net.Conn, cred azcore.TokenCrede
 			}
 			break // Exit loop on any read error or context cancellation
 		}
-		logAuthMessage("Raw data from %s: %s", clientAddr, line) // Verbose
-
 		jsonData := line[:len(line)-1] // Trim the delimiter
 
+		var response interface{}
 		var tokenReq TokenRequest
 		if err := json.Unmarshal([]byte(jsonData), &tokenReq); err != nil {
-			logAuthMessage("Error unmarshalling request from %s: %v. JSON: %s", clientAddr, err, jsonData)
-			continue
+			// Requests carry the session secret, so the raw data is never logged
+			logAuthMessage("Error unmarshalling %d-byte request from %s: %v", len(jsonData), clientAddr, err)
+			response = newErrorResponse(authErrInvalidRequest, fmt.Sprintf("Malformed request: %v", err))
+			s.metrics.recordFailure(authErrInvalidRequest)
+		} else {
+			logAuthMessage("Request from %s - Type: '%s', Scopes: %v, Organization: '%s'", clientAddr, tokenReq.Type, tokenReq.Data.Scopes, tokenReq.Data.Organization)
+			response = s.handleRequest(ctx, clientAddr, tokenReq)
+		}
+
+		if err := writeAuthResponse(writer, response); err != nil {
+			logAuthMessage("Error sending response to %s: %v", clientAddr, err)
+			break
 		}
+		switch resp := response.(type) {
+		case ErrorResponse:
+			logAuthMessage("Sent error response '%s' to %s", resp.Data.Code, clientAddr)
+		case StatusResponse:
+			logAuthMessage("Sent status response to %s", clientAddr)
+		case CredentialResponse:
+			logAuthMessage("Sent credential response to %s", clientAddr)
+		default:
+			logAuthMessage("Sent accessToken response to %s", clientAddr)
+		}
+	}
+	logAuthMessage("Finished handling connection for %s", clientAddr)
+}
 
-		logAuthMessage("Request from %s - Type: '%s', Scopes: %v", clientAddr, tokenReq.Type, tokenReq.Data.Scopes)
+// handleRequest serves a single decoded request and returns the response to send.
+// Error responses are counted in the session metrics by code.
+func (s *authServer) handleRequest(ctx context.Context, clientAddr string, req TokenRequest) interface{} {
+	response := s.dispatchRequest(ctx, clientAddr, req)
+	if errResp, ok := response.(ErrorResponse); ok {
+		s.metrics.recordFailure(errResp.Data.Code)
+	}
+	return response
+}
 
-		if tokenReq.Type == "getAccessToken" {
-			var scopes []string
-			if tokenReq.Data.Scopes == nil || *tokenReq.Data.Scopes == "" {
-				scopes = []string{"499b84ac-1321-427f-aa17-267ca6975798/.default"}
-				logAuthMessage("No scopes from %s, using default: %v", clientAddr, scopes)
-			} else {
-				scopes = strings.Split(*tokenReq.Data.Scopes, " ")
-				logAuthMessage("Scopes from %s: %v", clientAddr, scopes)
-			}
+// dispatchRequest checks the session secret and routes req by type.
+func (s *authServer) dispatchRequest(ctx context.Context, clientAddr string, req TokenRequest) interface{} {
+	if !validSessionSecret(s.secret, req.Secret) {
+		logAuthMessage("Rejected '%s' request from %s without a valid session secret", req.Type, clientAddr)
+		return newErrorResponse(authErrUnauthorized, "Request is missing a valid session secret. Reconnect with gh ado-codespaces to refresh the auth helper.")
+	}
 
-			token, err := cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: scopes}) // Pass context
-			if err != nil {
-				logAuthMessage("Error getting token for %s (scopes %v): %v", clientAddr, scopes, err)
-				continue
-			}
+	switch req.Type {
+	case "getAccessToken":
+		return s.handleTokenRequest(ctx, clientAddr, req)
+	case "getCredential":
+		return s.handleCredentialRequest(ctx, clientAddr, req)
+	case "ping":
+		return s.handleStatusRequest(ctx, false)
+	case "status":
+		return s.handleStatusRequest(ctx, true)
+	default:
+		logAuthMessage("Received unknown message type '%s' from %s", req.Type, clientAddr)
+		return newErrorResponse(authErrUnknownType, fmt.Sprintf("Unknown message type '%s'", req.Type))
+	}
+}
 
-			logAuthMessage("Successfully obtained token for %s (scopes %v)", clientAddr, scopes) // Token itself not logged
+// handleStatusRequest reports the server's state. With checkToken it also tries to get
+// a token for the default ADO scope, without returning it, to show whether the local
+// credential still works. The check bypasses the token cache, which could otherwise
+// hide a credential that stopped working.
+func (s *authServer) handleStatusRequest(ctx context.Context, checkToken bool) StatusResponse {
+	info := s.info
+	info.CredentialSources = slices.Clone(s.info.CredentialSources)
+	if id := s.sessionID.Load(); id != nil {
+		info.SessionID = *id
+	}
 
-			tokenResp := TokenResponse{
-				Type: "accessToken",
-				Data: token.Token,
-			}
+	if checkToken {
+		canMint := true
+		cred, err := s.creds.credentialFor("")
+		if err == nil {
+			tokenCtx, cancel := context.WithTimeout(ctx, statusTokenTimeout)
+			_, err = uncachedCredential(cred).GetToken(tokenCtx, policy.TokenRequestOptions{Scopes: []string{defaultADOScope}})
+			cancel()
+		}
+		if err != nil {
+			logAuthMessage("Status check could not get a token: %v", err)
+			canMint = false
+			authErr := classifyTokenError(err, []string{defaultADOScope})
+			info.TokenError = &authErr
+		}
+		info.CanMintToken = &canMint
+	}
 
-			respBytes, err := json.Marshal(tokenResp)
-			if err != nil {
-				logAuthMessage("Error marshalling response for %s: %v", clientAddr, err)
-				continue
-			}
+	// Read after the token check so a source that just succeeded is reported.
+	info.ActiveCredential = s.chain.ActiveSource()
+	if checkToken {
+		info.Metrics = s.metrics.snapshot()
+	}
+	return StatusResponse{Type: "status", Data: info}
+}
 
-			_, err = writer.Write(append(respBytes, '\f'))
-			if err != nil {
-				logAuthMessage("Error writing response to %s: %v", clientAddr, err)
-				break
-			}
-			err = writer.Flush()
-			if err != nil {
-				logAuthMessage("Error flushing writer for %s: %v", clientAddr, err)
-				break
-			}
-			logAuthMessage("Sent accessToken response to %s", clientAddr)
-		} else {
-			logAuthMessage("Received unknown message type '%s' from %s", tokenReq.Type, clientAddr)
+// handleTokenRequest serves a getAccessToken request and returns the response to send.
+func (s *authServer) handleTokenRequest(ctx context.Context, clientAddr string, tokenReq TokenRequest) interface{} {
+	var scopes []string
+	if tokenReq.Data.Scopes == nil || *tokenReq.Data.Scopes == "" {
+		scopes = []string{defaultADOScope}
+		logAuthMessage("No scopes from %s, using default: %v", clientAddr, scopes)
+	} else {
+		scopes = strings.Split(*tokenReq.Data.Scopes, " ")
+		logAuthMessage("Scopes from %s: %v", clientAddr, scopes)
+	}
+
+	token, errResp := s.issueToken(ctx, clientAddr, scopes, tokenReq.Data.Organization)
+	if errResp != nil {
+		return *errResp
+	}
+	return newTokenResponse(token)
+}
+
+// handleCredentialRequest serves a getCredential request by finding the provider for
+// the requested host and turning a token for its scope into a username and password.
+func (s *authServer) handleCredentialRequest(ctx context.Context, clientAddr string, req TokenRequest) interface{} {
+	_, host, err := parseCredentialHost(req.Data.Host)
+	if err != nil {
+		logAuthMessage("Rejected credential request from %s: %v", clientAddr, err)
+		// No provider answers for such hosts, so git moves on to its next helper
+		return newErrorResponse(authErrNoProvider, fmt.Sprintf("Host '%s' is not a DNS name with an optional port.", req.Data.Host))
+	}
+	provider, ok := s.providers.lookup(host)
+	if !ok {
+		logAuthMessage("No credential provider for host '%s' requested by %s", host, clientAddr)
+		return newErrorResponse(authErrNoProvider, fmt.Sprintf("No credential provider is configured for host '%s'.", host))
+	}
+
+	organization := req.Data.Organization
+	if organization == "" {
+		organization = provider.Organization
+	}
+	logAuthMessage("Credential request from %s for host '%s' using %s provider '%s'", clientAddr, host, provider.Type, provider.Host)
+
+	token, errResp := s.issueToken(ctx, clientAddr, []string{provider.Scope}, organization)
+	if errResp != nil {
+		return *errResp
+	}
+
+	cred, err := credentialProviderTypes[provider.Type](ctx, provider, host, token)
+	if err != nil {
+		logAuthMessage("Error exchanging token for host '%s' for %s: %v", host, clientAddr, err)
+		return newErrorResponse(authErrUnavailable, fmt.Sprintf("Failed to get a credential for host '%s': %s", host, summarizeError(err)))
+	}
+	return newCredentialResponse(cred, token)
+}
+
+// issueToken applies the scope policy, asking for approval when needed, and gets a
+// token for scopes from the credential for organization. Every decision is audited.
+// On failure it returns the error response to send instead.
+func (s *authServer) issueToken(ctx context.Context, clientAddr string, scopes []string, organization string) (azcore.AccessToken, *ErrorResponse) {
+	s.metrics.recordRequest(scopes)
+	approval := ""
+	scope, decision := s.policy.evaluate(scopes)
+	switch decision {
+	case scopePolicyDeny:
+		logAuthMessage("Denied token request from %s: scope '%s' is not allowed", clientAddr, scope)
+		s.audit.record(tokenAuditEntry{
+			Decision:     "denied",
+			Scopes:       scopes,
+			Organization: organization,
+			Client:       clientAddr,
+			Reason:       fmt.Sprintf("scope %s is not allowed", scope),
+		})
+		errResp := newErrorResponse(authErrScopeDenied, fmt.Sprintf("Scope '%s' is not allowed. Add it to \"scopes\" in the gh-ado-codespaces config on the local machine to allow it.", scope))
+		return azcore.AccessToken{}, &errResp
+	case scopePolicyPrompt:
+		approved, reason := s.approver.approve(ctx, approvalRequest{
+			Scopes:       scopes,
+			Organization: organization,
+			Client:       clientAddr,
+		})
+		if !approved {
+			logAuthMessage("Token request from %s for scope '%s' was not approved: %s", clientAddr, scope, reason)
+			s.audit.record(tokenAuditEntry{
+				Decision:     "denied",
+				Scopes:       scopes,
+				Organization: organization,
+				Client:       clientAddr,
+				Reason:       reason,
+			})
+			errResp := newErrorResponse(authErrScopeDenied, fmt.Sprintf("Access to scope '%s' was not approved on the local machine (%s).", scope, reason))
+			return azcore.AccessToken{}, &errResp
 		}
+		logAuthMessage("Token request from %s for scope '%s' %s", clientAddr, scope, reason)
+		approval = reason
 	}
-	logAuthMessage("Finished handling connection for %s", clientAddr)
+
+	cred, err := s.creds.credentialFor(organization)
+	if err != nil {
+		logAuthMessage("Error resolving credential for %s (organization '%s'): %v", clientAddr, organization, err)
+		errResp := newErrorResponse(authErrUnavailable, fmt.Sprintf("No usable credential for organization '%s': %s", organization, summarizeError(err)))
+		return azcore.AccessToken{}, &errResp
+	}
+
+	start := time.Now()
+	token, err := cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: scopes}) // Pass context
+	s.metrics.recordGetToken(time.Since(start))
+	if err != nil {
+		logAuthMessage("Error getting token for %s (scopes %v): %v", clientAddr, scopes, err)
+		errResp := ErrorResponse{Type: "error", Data: classifyTokenError(err, scopes)}
+		s.alerts.failure(errResp.Data)
+		return azcore.AccessToken{}, &errResp
+	}
+	s.alerts.success()
+
+	logAuthMessage("Successfully obtained token for %s (scopes %v)", clientAddr, scopes) // Token itself not logged
+	s.audit.record(tokenAuditEntry{
+		Decision:     "issued",
+		Scopes:       scopes,
+		Organization: organization,
+		Client:       clientAddr,
+		Reason:       approval,
+	})
+
+	return token, nil
 }
 
 // ServerConfig holds configuration for the local auth server
 type ServerConfig struct {
 	SocketPath string
-	Port       int
+	Local      localEndpoint
 	Listener   net.Listener
+	// LoginError is why the token probe made at startup failed, or nil.
+	LoginError *AuthError
 	loggerFile *os.File // To manage log file lifecycle
+	auditLog   *tokenAuditLog
+	server     *authServer
+}
+
+// Metrics returns the token metrics collected so far, or nil before the server starts.
+func (sc *ServerConfig) Metrics() *MetricsInfo {
+	if sc.server == nil {
+		return nil
+	}
+	return sc.server.metrics.snapshot()
+}
+
+// ProbeLogin checks again that a token can be issued and updates LoginError.
+func (sc *ServerConfig) ProbeLogin(ctx context.Context) *AuthError {
+	if sc.server != nil {
+		sc.LoginError = sc.server.probeLogin(ctx)
+	}
+	return sc.LoginError
+}
+
+// LoginHint returns the az login command that should fix LoginError, or "".
+func (sc *ServerConfig) LoginHint() string {
+	if sc.server == nil {
+		return ""
+	}
+	return loginHint(sc.LoginError, sc.server.info.Tenant, sc.server.info.CredentialSources)
+}
+
+// AzLogin runs az login interactively for the server's tenant.
+func (sc *ServerConfig) AzLogin(ctx context.Context) error {
+	tenant := ""
+	if sc.server != nil {
+		tenant = sc.server.info.Tenant
+	}
+	return runAzLogin(ctx, tenant)
+}
+
+// SetNotifier lets the auth server report token failures as desktop notifications.
+func (sc *ServerConfig) SetNotifier(notify func(title, message string) error) {
+	if sc.server != nil {
+		sc.server.alerts.setNotifier(notify)
+	}
+}
+
+// SetSessionID tells the auth server which session it belongs to, for status responses.
+func (sc *ServerConfig) SetSessionID(id string) {
+	if sc.server != nil {
+		sc.server.setSessionID(id)
+	}
 }
 
 // Close stops the listener and closes the log file.
 func (sc *ServerConfig) Close() {
-	logAuthMessage("Closing server resources for port %d...", sc.Port)
+	logAuthMessage("Closing server resources for %s...", sc.Local)
 	if sc.Listener != nil {
-		logAuthMessage("Closing listener for port %d.", sc.Port)
+		logAuthMessage("Closing listener for %s.", sc.Local)
 		sc.Listener.Close()
 	}
+	if sc.auditLog != nil {
+		sc.auditLog.Close()
+	}
 	if sc.loggerFile != nil {
 		logAuthMessage("Closing auth logger file: %s", sc.loggerFile.Name())
 		sc.loggerFile.Close()
@@ -243,12 +480,12 @@ func (sc *ServerConfig) Close() {
 		authLogFile = nil
 		authLogger = nil
 	}
-	logAuthMessage("Server resources for port %d closed.", sc.Port)
+	logAuthMessage("Server resources for %s closed.", sc.Local)
 }
 
 // SetupServer initializes the local server and returns its configuration.
-// It now takes a context for cancellation.
-func SetupServer(ctx context.Context) (*ServerConfig, error) {
+// It now takes a context for cancellation. Requests must carry secret.
+func SetupServer(ctx context.Context, secret string) (*ServerConfig, error) {
 	if err := initAuthLogger(); err != nil {
 		// initAuthLogger already prints to Stderr for critical failures.
 		return nil, fmt.Errorf("failed to initialize auth logger: %w", err)
@@ -256,7 +493,12 @@ func SetupServer(ctx context.Context) (*ServerConfig, error) {
 
 	logAuthMessage("Attempting to start auth server...")
 
-	var subscription string
+	var subscription, tenant, githubLogin string
+	var additionalTenants []string
+	var organizations map[string]AzureOrganizationConfig
+	credentialSources := defaultCredentialSources
+	scopeRules := DefaultScopeRules
+	providers := MergeCredentialProviders(DefaultCredentialProviders)
 
 	configPath, pathErr := getConfigFilePath()
 	if pathErr != nil {
@@ -282,24 +524,42 @@ func SetupServer(ctx context.Context) (*ServerConfig, error) {
 		login, loginErr := currentGitHubLogin()
 		if loginErr != nil {
 			logAuthMessage("Unable to determine active GitHub login: %v", loginErr)
+			scopeRules = cfg.ScopeRulesForLogin("")
+			providers = cfg.CredentialProvidersForLogin("")
 		} else {
+			scopeRules = cfg.ScopeRulesForLogin(login)
+			providers = cfg.CredentialProvidersForLogin(login)
 			logAuthMessage("Active GitHub login: %s", login)
+			githubLogin = login
 			if sub, ok := cfg.AzureSubscriptionForLogin(login); ok {
 				subscription = sub
 				logAuthMessage("Using Azure subscription override '%s' for login '%s'", subscription, login)
 			} else {
 				logAuthMessage("No Azure subscription override found for login '%s'", login)
 			}
+			if t, ok := cfg.AzureTenantForLogin(login); ok {
+				tenant = t
+				logAuthMessage("Using Azure tenant override '%s' for login '%s'", tenant, login)
+			}
+			additionalTenants = cfg.AzureAdditionalTenantsForLogin(login)
+			if len(additionalTenants) > 0 {
+				logAuthMessage("Additionally allowed tenants for login '%s': %v", login, additionalTenants)
+			}
+			credentialSources = cfg.AzureCredentialSourcesForLogin(login)
+			organizations = cfg.AzureOrganizationsForLogin(login)
 		}
 	}
 
-	var cred azcore.TokenCredential
-	if strings.TrimSpace(subscription) == "" {
-		cred, err = azidentity.NewAzureCLICredential(nil)
-	} else {
-		logAuthMessage("Creating Azure CLI credential with subscription override %s", subscription)
-		cred, err = azidentity.NewAzureCLICredential(&azidentity.AzureCLICredentialOptions{Subscription: subscription})
+	logAuthMessage("Using credential chain: %s", strings.Join(credentialSources, " -> "))
+	metrics := newTokenMetrics()
+	baseOptions := credentialOptions{
+		Subscription:      strings.TrimSpace(subscription),
+		TenantID:          tenant,
+		AdditionalTenants: additionalTenants,
+		Sources:           credentialSources,
+		Metrics:           metrics,
 	}
+	chain, err := newCredentialChain(baseOptions)
 	if err != nil {
 		logAuthMessage("Error creating Azure credential: %v", err)
 		if authLogFile != nil {
@@ -310,9 +570,50 @@ func SetupServer(ctx context.Context) (*ServerConfig, error) {
 		return nil, fmt.Errorf("error creating Azure credential: %w", err)
 	}
 
-	listener, port, err := startServer(ctx, cred) // Pass context
+	// Cache tokens in-process so bursts of credential helper calls don't each shell out to az.
+	cache := newCachingCredential(chain)
+	cache.metrics = metrics
+	var cred azcore.TokenCredential = cache
+
+	if len(organizations) > 0 {
+		logAuthMessage("Organization overrides configured for: %s", strings.Join(slices.Sorted(maps.Keys(organizations)), ", "))
+	}
+	for _, rule := range scopeRules {
+		logAuthMessage("Scope rule: %s -> %s", rule.Scope, rule.Policy)
+	}
+	for _, provider := range providers {
+		logAuthMessage("Credential provider: %s -> %s (%s)", provider.Host, provider.Type, provider.Scope)
+	}
+
+	auditLog, err := openTokenAuditLog()
+	if err != nil {
+		// Auditing is best effort; tokens are still gated by the scope policy.
+		logAuthMessage("Token audit log disabled: %v", err)
+	}
+
+	server := &authServer{
+		creds:     newCredentialRouter(cred, baseOptions, organizations),
+		policy:    newScopePolicy(scopeRules),
+		providers: newProviderRegistry(providers),
+		approver:  newScopeApprover(),
+		audit:     auditLog,
+		metrics:   metrics,
+		alerts:    newLoginAlerter(),
+		secret:    secret,
+		info: StatusInfo{
+			Version:           version,
+			Login:             githubLogin,
+			Subscription:      baseOptions.Subscription,
+			Tenant:            baseOptions.TenantID,
+			CredentialSources: credentialSources,
+		},
+		chain: chain,
+	}
+
+	listener, local, err := startServer(ctx, server) // Pass context
 	if err != nil {
 		logAuthMessage("Error starting server components: %v", err)
+		auditLog.Close()
 		// Ensure logger is closed if setup fails mid-way
 		if authLogFile != nil {
 			authLogFile.Close() // This will also be caught by ServerConfig.Close if it was set
@@ -325,12 +626,19 @@ func SetupServer(ctx context.Context) (*ServerConfig, error) {
 	socketId := uuid.New()
 	socketPath := "/tmp/ado-auth-" + socketId.String() + ".sock"
 
-	logAuthMessage("Server successfully started on port %d, socket path %s", port, socketPath)
+	logAuthMessage("Server successfully started on %s, socket path %s", local, socketPath)
+
+	// Check the login now, so a logged out az is reported before the session starts
+	// rather than by the first git command in the codespace.
+	loginErr := server.probeLogin(ctx)
 
 	return &ServerConfig{
 		SocketPath: socketPath,
-		Port:       port,
+		Local:      local,
 		Listener:   listener,
+		LoginError: loginErr,
 		loggerFile: authLogFile, // Store the log file handle
+		auditLog:   auditLog,
+		server:     server,
 	}, nil
 }
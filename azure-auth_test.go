@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+var discardAuthLogsOnce sync.Once
+
+// discardAuthLogs silences auth logging for tests. It must be called before the
+// test starts any goroutine that logs, and is never restored to avoid racing with them.
+func discardAuthLogs(t *testing.T) {
+	t.Helper()
+	discardAuthLogsOnce.Do(func() {
+		authLogger = log.New(io.Discard, "", 0)
+	})
+}
+
+// newTestAuthServer returns an auth server backed by cred with the default scope policy.
+func newTestAuthServer(cred azcore.TokenCredential) *authServer {
+	return &authServer{
+		creds:  newCredentialRouter(cred, credentialOptions{}, nil),
+		policy: newScopePolicy(DefaultScopeRules),
+		secret: testSessionSecret,
+	}
+}
+
+// exchangeAuthMessage sends a raw request to auth over the auth protocol and decodes the response.
+func exchangeAuthMessage(t *testing.T, auth *authServer, request string) map[string]interface{} {
+	t.Helper()
+	discardAuthLogs(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server, client := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		auth.handleConnection(ctx, server)
+	}()
+	defer func() {
+		client.Close()
+		<-done
+	}()
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := client.Write([]byte(request + "\f")); err != nil {
+		t.Fatalf("failed to write request: %v", err)
+	}
+
+	line, err := bufio.NewReader(client).ReadString('\f')
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+
+	var resp map[string]interface{}
+	if err := json.Unmarshal([]byte(line[:len(line)-1]), &resp); err != nil {
+		t.Fatalf("failed to decode response %q: %v", line, err)
+	}
+	return resp
+}
+
+func TestHandleConnection_ReturnsToken(t *testing.T) {
+	cred := &fakeCredential{expiresAt: time.Now().Add(time.Hour)}
+	resp := exchangeAuthMessage(t, newTestAuthServer(cred), `{"secret":"test-secret","type":"getAccessToken","data":{}}`)
+
+	if resp["type"] != "accessToken" {
+		t.Fatalf("expected accessToken response, got %v", resp)
+	}
+	if resp["data"] != "token-1" {
+		t.Errorf("expected token-1, got %v", resp["data"])
+	}
+	if resp["tokenType"] != "Bearer" {
+		t.Errorf("expected Bearer token type, got %v", resp["tokenType"])
+	}
+	if got, ok := resp["expiresOn"].(float64); !ok || int64(got) != cred.expiresAt.Unix() {
+		t.Errorf("expected expiresOn %d, got %v", cred.expiresAt.Unix(), resp["expiresOn"])
+	}
+}
+
+func TestHandleConnection_ReturnsStructuredError(t *testing.T) {
+	cred := &fakeCredential{err: errors.New("ERROR: Please run 'az login' to setup account.")}
+	resp := exchangeAuthMessage(t, newTestAuthServer(cred), `{"secret":"test-secret","type":"getAccessToken","data":{}}`)
+
+	if resp["type"] != "error" {
+		t.Fatalf("expected error response, got %v", resp)
+	}
+	data, ok := resp["data"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected error data object, got %v", resp["data"])
+	}
+	if data["code"] != authErrNotLoggedIn {
+		t.Errorf("expected code %q, got %v", authErrNotLoggedIn, data["code"])
+	}
+	if data["message"] == "" {
+		t.Error("expected human-readable message")
+	}
+}
+
+func TestHandleConnection_RejectsMalformedAndUnknownRequests(t *testing.T) {
+	cred := &fakeCredential{expiresAt: time.Now().Add(time.Hour)}
+
+	tests := []struct {
+		name     string
+		request  string
+		wantCode string
+	}{
+		{name: "malformed JSON", request: `{not json`, wantCode: authErrInvalidRequest},
+		{name: "unknown type", request: `{"secret":"test-secret","type":"bogus","data":{}}`, wantCode: authErrUnknownType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := exchangeAuthMessage(t, newTestAuthServer(cred), tt.request)
+			data, _ := resp["data"].(map[string]interface{})
+			if resp["type"] != "error" || data["code"] != tt.wantCode {
+				t.Errorf("expected error %q, got %v", tt.wantCode, resp)
+			}
+		})
+	}
+}
+
+func TestHandleConnection_ReturnsCredentialForHost(t *testing.T) {
+	tests := []struct {
+		name         string
+		host         string
+		scopeRules   []ScopeRule
+		wantType     string
+		wantUsername string
+		wantCode     string
+	}{
+		{name: "git remote", host: "dev.azure.com", wantType: "credential", wantUsername: "token"},
+		{name: "artifacts feed", host: "pkgs.dev.azure.com", wantType: "credential", wantUsername: "VssSessionToken"},
+		{name: "unconfigured host", host: "github.com", wantType: "error", wantCode: authErrNoProvider},
+		{name: "host with user info", host: "x.azurecr.io:1@evil.com", wantType: "error", wantCode: authErrNoProvider},
+		{name: "host with path", host: "evil.com/.azurecr.io", wantType: "error", wantCode: authErrNoProvider},
+		{name: "scope policy still applies", host: "dev.azure.com", scopeRules: []ScopeRule{}, wantType: "error", wantCode: authErrScopeDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+			cred := &fakeCredential{expiresAt: expiresAt}
+			auth := newTestAuthServer(cred)
+			if tt.scopeRules != nil {
+				auth.policy = newScopePolicy(tt.scopeRules)
+			}
+
+			resp := exchangeAuthMessage(t, auth, `{"secret":"test-secret","type":"getCredential","data":{"host":"`+tt.host+`"}}`)
+			if resp["type"] != tt.wantType {
+				t.Fatalf("expected %s response, got %v", tt.wantType, resp)
+			}
+			data, _ := resp["data"].(map[string]interface{})
+			if tt.wantCode != "" {
+				if data["code"] != tt.wantCode {
+					t.Errorf("expected code %q, got %v", tt.wantCode, data["code"])
+				}
+				return
+			}
+			if data["username"] != tt.wantUsername || data["password"] != "token-1" {
+				t.Errorf("unexpected credential %v", data)
+			}
+			if resp["expiresOn"] != float64(expiresAt.Unix()) {
+				t.Errorf("expected expiresOn %d, got %v", expiresAt.Unix(), resp["expiresOn"])
+			}
+		})
+	}
+}
+
+func TestHandleConnection_RequiresSessionSecret(t *testing.T) {
+	tests := []struct {
+		name    string
+		request string
+	}{
+		{name: "missing secret", request: `{"type":"getAccessToken","data":{}}`},
+		{name: "wrong secret", request: `{"secret":"wrong","type":"getAccessToken","data":{}}`},
+		{name: "status without secret", request: `{"type":"status"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := &fakeCredential{expiresAt: time.Now().Add(time.Hour)}
+			resp := exchangeAuthMessage(t, newTestAuthServer(cred), tt.request)
+			data, _ := resp["data"].(map[string]interface{})
+			if resp["type"] != "error" || data["code"] != authErrUnauthorized {
+				t.Errorf("expected %q error, got %v", authErrUnauthorized, resp)
+			}
+			if got := cred.calls.Load(); got != 0 {
+				t.Errorf("expected no credential calls, got %d", got)
+			}
+		})
+	}
+}
+
+func TestHandleConnection_DeniesScopeOutsidePolicy(t *testing.T) {
+	discardAuthLogs(t)
+	auditPath := t.TempDir() + "/token-audit.log"
+	auditFile, err := os.OpenFile(auditPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatalf("failed to create audit log: %v", err)
+	}
+	audit := &tokenAuditLog{file: auditFile}
+	defer audit.Close()
+
+	cred := &fakeCredential{expiresAt: time.Now().Add(time.Hour)}
+	auth := newTestAuthServer(cred)
+	auth.audit = audit
+
+	resp := exchangeAuthMessage(t, auth, `{"secret":"test-secret","type":"getAccessToken","data":{"scopes":"https://graph.microsoft.com/.default"}}`)
+	data, _ := resp["data"].(map[string]interface{})
+	if resp["type"] != "error" || data["code"] != authErrScopeDenied {
+		t.Fatalf("expected %q error, got %v", authErrScopeDenied, resp)
+	}
+	if got := cred.calls.Load(); got != 0 {
+		t.Errorf("expected denied scope not to reach the credential, got %d calls", got)
+	}
+
+	resp = exchangeAuthMessage(t, auth, `{"secret":"test-secret","type":"getAccessToken","data":{}}`)
+	if resp["type"] != "accessToken" {
+		t.Fatalf("expected default scope to be allowed, got %v", resp)
+	}
+
+	contents, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("failed to read audit log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 audit entries, got %d: %q", len(lines), contents)
+	}
+	for i, want := range []string{"denied", "issued"} {
+		var entry tokenAuditEntry
+		if err := json.Unmarshal([]byte(lines[i]), &entry); err != nil {
+			t.Fatalf("failed to decode audit entry %q: %v", lines[i], err)
+		}
+		if entry.Decision != want {
+			t.Errorf("audit entry %d: expected decision %q, got %q", i, want, entry.Decision)
+		}
+	}
+}
+
+func TestHandleConnection_PromptsForSensitiveScopes(t *testing.T) {
+	const armScope = "https://management.azure.com/.default"
+	request := `{"secret":"test-secret","type":"getAccessToken","data":{"scopes":"` + armScope + `"}}`
+
+	tests := []struct {
+		name      string
+		decision  approvalDecision
+		wantType  string
+		wantCalls int32
+	}{
+		{name: "approved", decision: approvalAllowOnce, wantType: "accessToken", wantCalls: 1},
+		{name: "denied", decision: approvalDeny, wantType: "error", wantCalls: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := &fakeCredential{expiresAt: time.Now().Add(time.Hour)}
+			auth := newTestAuthServer(cred)
+			auth.policy = newScopePolicy([]ScopeRule{{Scope: armScope, Policy: scopePolicyPrompt}})
+			auth.approver = newScopeApprover()
+			auth.approver.prompt = func(ctx context.Context, req approvalRequest) (approvalDecision, error) {
+				if len(req.Scopes) != 1 || req.Scopes[0] != armScope {
+					t.Errorf("unexpected scopes in prompt: %v", req.Scopes)
+				}
+				return tt.decision, nil
+			}
+
+			resp := exchangeAuthMessage(t, auth, request)
+			if resp["type"] != tt.wantType {
+				t.Fatalf("expected %s response, got %v", tt.wantType, resp)
+			}
+			if got := cred.calls.Load(); got != tt.wantCalls {
+				t.Errorf("expected %d credential calls, got %d", tt.wantCalls, got)
+			}
+		})
+	}
+}
+
+func TestHandleConnection_ReportsStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		request     string
+		credErr     error
+		wantMint    interface{}
+		wantErrCode string
+	}{
+		{name: "ping skips token check", request: `{"secret":"test-secret","type":"ping"}`, wantMint: nil},
+		{name: "status with working credential", request: `{"secret":"test-secret","type":"status"}`, wantMint: true},
+		{name: "status with failing credential", request: `{"secret":"test-secret","type":"status"}`, credErr: errors.New("Please run 'az login' to setup account."), wantMint: false, wantErrCode: authErrNotLoggedIn},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := &fakeCredential{err: tt.credErr, expiresAt: time.Now().Add(time.Hour)}
+			auth := newTestAuthServer(cred)
+			auth.info = StatusInfo{Version: "1.2.3", SessionID: "session", Login: "octocat", Tenant: "tenant"}
+
+			resp := exchangeAuthMessage(t, auth, tt.request)
+			if resp["type"] != "status" {
+				t.Fatalf("expected status response, got %v", resp)
+			}
+			data, _ := resp["data"].(map[string]interface{})
+			for key, want := range map[string]string{"version": "1.2.3", "sessionId": "session", "login": "octocat", "tenant": "tenant"} {
+				if data[key] != want {
+					t.Errorf("%s = %v, want %q", key, data[key], want)
+				}
+			}
+			if data["canMintToken"] != tt.wantMint {
+				t.Errorf("canMintToken = %v, want %v", data["canMintToken"], tt.wantMint)
+			}
+			if _, ok := data["data"]; ok {
+				t.Error("status response must not include a token")
+			}
+
+			tokenErr, _ := data["tokenError"].(map[string]interface{})
+			if tt.wantErrCode == "" && tokenErr != nil {
+				t.Errorf("unexpected token error %v", tokenErr)
+			}
+			if tt.wantErrCode != "" && (tokenErr == nil || tokenErr["code"] != tt.wantErrCode) {
+				t.Errorf("expected token error %q, got %v", tt.wantErrCode, tokenErr)
+			}
+		})
+	}
+}
+
+func TestHandleConnection_StatusBypassesTokenCache(t *testing.T) {
+	cred := &fakeCredential{expiresAt: time.Now().Add(time.Hour)}
+	cache := newCachingCredential(cred)
+	if _, err := cache.GetToken(context.Background(), policy.TokenRequestOptions{Scopes: []string{defaultADOScope}}); err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+
+	// The cache still holds a valid token after the login expires
+	cred.err = errors.New("Please run 'az login' to setup account.")
+	auth := newTestAuthServer(cache)
+
+	resp := exchangeAuthMessage(t, auth, `{"secret":"test-secret","type":"status"}`)
+	data, _ := resp["data"].(map[string]interface{})
+	if data["canMintToken"] != false {
+		t.Errorf("canMintToken = %v, want false while the credential fails", data["canMintToken"])
+	}
+	if tokenErr, _ := data["tokenError"].(map[string]interface{}); tokenErr == nil || tokenErr["code"] != authErrNotLoggedIn {
+		t.Errorf("expected token error %q, got %v", authErrNotLoggedIn, data["tokenError"])
+	}
+}
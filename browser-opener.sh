@@ -8,6 +8,20 @@
 #
 # The script automatically finds the browser service socket (no manual configuration needed)
 
+# Succeed if $1 is a socket owned by the current user, not a link to one, so
+# nothing is sent to a socket another user created in /tmp.
+owned_socket() {
+    [ -S "$1" ] && [ ! -L "$1" ] && [ -O "$1" ]
+}
+
+# Print a curl config line carrying the session secret for the socket in $1.
+# The secret is piped to curl so it never appears in the process list.
+secret_config() {
+    owned_socket "$1" || return 1
+    local secret_file="$HOME/.gh-ado/secrets/$(basename "$1" .sock)"
+    [ -r "$secret_file" ] && printf 'header = "X-GH-ADO-Secret: %s"\n' "$(cat "$secret_file")"
+}
+
 # Get the URL from arguments
 URL="$1"
 
@@ -18,7 +32,7 @@ fi
 
 # Find all browser sockets in /tmp (pattern: gh-ado-browser-*.sock)
 # Sort by modification time (newest first) to prefer active sockets
-BROWSER_SOCKETS=$(find /tmp -maxdepth 1 -name "gh-ado-browser-*.sock" -type s -print0 2>/dev/null | xargs -0 -r ls -t 2>/dev/null)
+BROWSER_SOCKETS=$(find /tmp -maxdepth 1 -name "gh-ado-browser-*.sock" -type s -user "$(id -u)" -print0 2>/dev/null | xargs -0 -r ls -t 2>/dev/null)
 
 if [ -z "$BROWSER_SOCKETS" ]; then
     # No socket found - browser forwarding not available
@@ -27,10 +41,12 @@ fi
 
 # Try each socket until one succeeds (with timeout to quickly skip dead sockets)
 for BROWSER_SOCKET in $BROWSER_SOCKETS; do
+    owned_socket "$BROWSER_SOCKET" || continue
     # Send URL to the socket via HTTP POST using curl with --unix-socket
     # Using curl to send the URL as a query parameter
-    # --max-time 2 ensures we fail fast on dead sockets
-    if curl -s --max-time 2 --unix-socket "$BROWSER_SOCKET" -X POST "http://localhost/open?url=$(printf %s "$URL" | jq -sRr @uri)" >/dev/null 2>&1; then
+    # --max-time 2 ensures we fail fast on dead sockets, and -f skips sockets that
+    # reject our secret (e.g. another user's session)
+    if secret_config "$BROWSER_SOCKET" | curl -K - -sf --max-time 2 --unix-socket "$BROWSER_SOCKET" -X POST "http://localhost/open?url=$(printf %s "$URL" | jq -sRr @uri)" >/dev/null 2>&1; then
         # Success - exit immediately
         exit 0
     fi
@@ -20,7 +20,7 @@ var browserOpenerScript string
 
 // BrowserService manages the browser opener service
 type BrowserService struct {
-	Port       int
+	Local      localEndpoint
 	SocketPath string
 	server     *http.Server
 	listener   net.Listener
@@ -29,27 +29,25 @@ type BrowserService struct {
 	wg         sync.WaitGroup
 }
 
-// NewBrowserService creates and starts a new browser service
-func NewBrowserService(ctx context.Context) (*BrowserService, error) {
-	// Create a local TCP listener for browser requests
-	listener, err := net.Listen("tcp", "127.0.0.1:0")
+// NewBrowserService creates and starts a new browser service.
+// Requests must carry secret in the session secret header.
+func NewBrowserService(ctx context.Context, secret string) (*BrowserService, error) {
+	// Create a private local listener for browser requests
+	listener, local, err := listenLocalService("browser")
 	if err != nil {
 		return nil, fmt.Errorf("failed to create local listener: %w", err)
 	}
 
-	// Get the actual port that was assigned
-	browserPort := listener.Addr().(*net.TCPAddr).Port
-
 	// Generate a unique socket path for remote forwarding
 	socketId := uuid.New()
 	socketPath := "/tmp/gh-ado-browser-" + socketId.String() + ".sock"
 
-	logDebug("Local browser HTTP service created on port: %d, socket path: %s", browserPort, socketPath)
+	logDebug("Local browser HTTP service created on %s, socket path: %s", local, socketPath)
 
 	serviceCtx, cancel := context.WithCancel(ctx)
 
 	service := &BrowserService{
-		Port:       browserPort,
+		Local:      local,
 		SocketPath: socketPath,
 		listener:   listener,
 		ctx:        serviceCtx,
@@ -58,7 +56,7 @@ func NewBrowserService(ctx context.Context) (*BrowserService, error) {
 
 	// Create HTTP handler
 	mux := http.NewServeMux()
-	mux.HandleFunc("/open", service.handleOpenURL)
+	mux.HandleFunc("/open", requireSessionSecret(secret, service.handleOpenURL))
 
 	// Create HTTP server
 	service.server = &http.Server{
@@ -77,7 +75,7 @@ func (bs *BrowserService) serve() {
 	defer bs.wg.Done()
 	defer bs.listener.Close()
 
-	logDebug("Browser HTTP service starting on port %d", bs.Port)
+	logDebug("Browser HTTP service starting on %s", bs.Local)
 
 	err := bs.server.Serve(bs.listener)
 	if err != nil && err != http.ErrServerClosed {
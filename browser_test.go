@@ -2,8 +2,6 @@ package main
 
 import (
 	"context"
-	"fmt"
-	"net"
 	"net/http"
 	"net/url"
 	"strings"
@@ -15,14 +13,14 @@ func TestNewBrowserService(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	service, err := NewBrowserService(ctx)
+	service, err := NewBrowserService(ctx, testSessionSecret)
 	if err != nil {
 		t.Fatalf("Failed to create browser service: %v", err)
 	}
 	defer service.Stop()
 
-	if service.Port == 0 {
-		t.Error("Browser service port should not be 0")
+	if service.Local.Address == "" {
+		t.Error("Browser service local address should not be empty")
 	}
 
 	if service.SocketPath == "" {
@@ -47,7 +45,7 @@ func TestBrowserServiceHandlesHTTPRequest(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	service, err := NewBrowserService(ctx)
+	service, err := NewBrowserService(ctx, testSessionSecret)
 	if err != nil {
 		t.Fatalf("Failed to create browser service: %v", err)
 	}
@@ -58,8 +56,8 @@ func TestBrowserServiceHandlesHTTPRequest(t *testing.T) {
 
 	// Send a test HTTP POST request to the browser service
 	testURL := "https://example.com"
-	resp, err := http.Post(
-		fmt.Sprintf("http://localhost:%d/open?url=%s", service.Port, url.QueryEscape(testURL)),
+	resp, err := localServiceClient(service.Local, testSessionSecret).Post(
+		"http://localhost/open?url="+url.QueryEscape(testURL),
 		"application/x-www-form-urlencoded",
 		nil,
 	)
@@ -80,7 +78,7 @@ func TestBrowserServiceStop(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	service, err := NewBrowserService(ctx)
+	service, err := NewBrowserService(ctx, testSessionSecret)
 	if err != nil {
 		t.Fatalf("Failed to create browser service: %v", err)
 	}
@@ -90,7 +88,7 @@ func TestBrowserServiceStop(t *testing.T) {
 
 	// Try to connect - should fail
 	time.Sleep(100 * time.Millisecond)
-	_, err = net.Dial("tcp", fmt.Sprintf("localhost:%d", service.Port))
+	_, err = service.Local.DialContext(context.Background())
 	if err == nil {
 		t.Error("Expected connection to fail after service stop, but it succeeded")
 	}
@@ -100,17 +98,17 @@ func TestBuildSSHArgsWithBrowserService(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	service, err := NewBrowserService(ctx)
+	service, err := NewBrowserService(ctx, testSessionSecret)
 	if err != nil {
 		t.Fatalf("Failed to create browser service: %v", err)
 	}
 	defer service.Stop()
 
 	args := CommandLineArgs{}
-	sshArgs := args.BuildSSHArgs("/tmp/test.sock", 8080, service, nil)
+	sshArgs := args.BuildSSHArgs("/tmp/test.sock", testAuthEndpoint, service, nil)
 
 	// Verify browser socket forward is included.
-	expectedForward := fmt.Sprintf("%s:%s:%d", service.SocketPath, localServiceHost, service.Port)
+	expectedForward := service.SocketPath + ":" + service.Local.Address
 	foundForward := false
 	for i := 0; i < len(sshArgs)-1; i++ {
 		if sshArgs[i] == "-R" && sshArgs[i+1] == expectedForward {
@@ -126,7 +124,7 @@ func TestBuildSSHArgsWithBrowserService(t *testing.T) {
 	// Verify SetEnv options are NOT included (users configure BROWSER themselves)
 	for i := 0; i < len(sshArgs)-1; i++ {
 		if sshArgs[i] == "-o" && (sshArgs[i+1] == "SetEnv BROWSER=$HOME/browser-opener.sh" ||
-			sshArgs[i+1] == fmt.Sprintf("SetEnv GH_ADO_CODESPACES_BROWSER_PORT=%d", service.Port)) {
+			strings.HasPrefix(sshArgs[i+1], "SetEnv GH_ADO_CODESPACES_BROWSER_PORT=")) {
 			t.Errorf("SetEnv options should not be in SSH args anymore (users configure BROWSER themselves). Found: %s", sshArgs[i+1])
 		}
 	}
@@ -134,13 +132,13 @@ func TestBuildSSHArgsWithBrowserService(t *testing.T) {
 
 func TestBuildSSHArgsWithoutBrowserService(t *testing.T) {
 	args := CommandLineArgs{}
-	sshArgs := args.BuildSSHArgs("/tmp/test.sock", 8080, nil, nil)
+	sshArgs := args.BuildSSHArgs("/tmp/test.sock", testAuthEndpoint, nil, nil)
 
 	// Verify no browser-specific port forwards are included when service is nil
 	for i := 0; i < len(sshArgs)-1; i++ {
 		if sshArgs[i] == "-R" {
 			// Make sure it's not a browser port (should be the auth socket or AI services)
-			if sshArgs[i+1] != "/tmp/test.sock:"+localServiceHost+":8080" {
+			if sshArgs[i+1] != "/tmp/test.sock:"+testAuthEndpoint.Address {
 				// This is fine - could be other forwards like AI services
 				continue
 			}
@@ -152,7 +150,7 @@ func TestHTTPEndpointMethodValidation(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	service, err := NewBrowserService(ctx)
+	service, err := NewBrowserService(ctx, testSessionSecret)
 	if err != nil {
 		t.Fatalf("Failed to create browser service: %v", err)
 	}
@@ -162,7 +160,7 @@ func TestHTTPEndpointMethodValidation(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 
 	// Test that GET requests are rejected
-	resp, err := http.Get(fmt.Sprintf("http://localhost:%d/open?url=https://example.com", service.Port))
+	resp, err := localServiceClient(service.Local, testSessionSecret).Get("http://localhost/open?url=https://example.com")
 	if err != nil {
 		t.Fatalf("Failed to send GET request: %v", err)
 	}
@@ -177,7 +175,7 @@ func TestHTTPEndpointMissingURL(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	service, err := NewBrowserService(ctx)
+	service, err := NewBrowserService(ctx, testSessionSecret)
 	if err != nil {
 		t.Fatalf("Failed to create browser service: %v", err)
 	}
@@ -187,8 +185,8 @@ func TestHTTPEndpointMissingURL(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 
 	// Test that requests without URL parameter are rejected
-	resp, err := http.Post(
-		fmt.Sprintf("http://localhost:%d/open", service.Port),
+	resp, err := localServiceClient(service.Local, testSessionSecret).Post(
+		"http://localhost/open",
 		"application/x-www-form-urlencoded",
 		nil,
 	)
@@ -201,3 +199,26 @@ func TestHTTPEndpointMissingURL(t *testing.T) {
 		t.Errorf("Expected status %d for request without URL, got %d", http.StatusBadRequest, resp.StatusCode)
 	}
 }
+
+func TestHTTPEndpointRequiresSessionSecret(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	service, err := NewBrowserService(ctx, testSessionSecret)
+	if err != nil {
+		t.Fatalf("Failed to create browser service: %v", err)
+	}
+	defer service.Stop()
+
+	for _, secret := range []string{"", "wrong-secret"} {
+		resp, err := localServiceClient(service.Local, secret).Post("http://localhost/open?url=https://example.com", "", nil)
+		if err != nil {
+			t.Fatalf("Failed to send POST request: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected status %d with secret %q, got %d", http.StatusUnauthorized, secret, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// authSocketPattern matches the sockets forwarded into the codespace by gh ado-codespaces.
+const authSocketPattern = "/tmp/ado-auth-*.sock"
+
+// secretDir holds the session secret for each socket, relative to the home directory.
+// gh ado-codespaces writes one file per socket, named after the socket without ".sock".
+const secretDir = ".gh-ado/secrets"
+
+// socketTimeout bounds a whole request, including any approval prompt on the local machine.
+const socketTimeout = 60 * time.Second
+
+// maxResponseSize guards against a misbehaving peer streaming without a delimiter.
+const maxResponseSize = 1 << 20
+
+// tokenRequest mirrors the auth server's TokenRequest message.
+type tokenRequest struct {
+	Type   string           `json:"type"`
+	Secret string           `json:"secret,omitempty"`
+	Data   tokenRequestData `json:"data"`
+}
+
+type tokenRequestData struct {
+	Scopes       string `json:"scopes,omitempty"`
+	Organization string `json:"organization,omitempty"`
+	Host         string `json:"host,omitempty"`
+}
+
+// authResponse holds either an accessToken or an error response from the auth server.
+type authResponse struct {
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+	ExpiresOn int64           `json:"expiresOn,omitempty"`
+	TokenType string          `json:"tokenType,omitempty"`
+}
+
+// tokenResponse is a successful accessToken response.
+type tokenResponse struct {
+	Token     string
+	ExpiresOn int64
+	TokenType string
+}
+
+// errCodeNoProvider is the error code for hosts without a credential provider.
+const errCodeNoProvider = "no_provider"
+
+// serviceError is an error response from a live auth server.
+type serviceError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *serviceError) Error() string {
+	return fmt.Sprintf("%s (%s)", e.Message, e.Code)
+}
+
+// errNoAuthSockets is returned when no auth server is forwarded into the codespace.
+var errNoAuthSockets = errors.New("no auth sockets found; is gh ado-codespaces connected?")
+
+// findAuthSockets returns the auth sockets currently present in the codespace.
+func findAuthSockets() []string {
+	paths, _ := filepath.Glob(authSocketPattern)
+	return paths
+}
+
+// socketSecret reads the session secret for socketPath. Sockets without a secret
+// belong to another user or to a session that predates this helper.
+func socketSecret(socketPath string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	name := strings.TrimSuffix(filepath.Base(socketPath), ".sock")
+	secret, err := os.ReadFile(filepath.Join(home, secretDir, name))
+	if err != nil {
+		return "", fmt.Errorf("read session secret: %w", err)
+	}
+	return strings.TrimSpace(string(secret)), nil
+}
+
+// checkSocketOwner makes sure socketPath is a socket owned by the current user, so the
+// session secret is never sent to a socket another user created in /tmp.
+func checkSocketOwner(socketPath string) error {
+	info, err := os.Lstat(socketPath)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() != os.ModeSocket {
+		return fmt.Errorf("%s is not a socket", socketPath)
+	}
+	if uid, ok := fileOwner(info); !ok || uid != os.Getuid() {
+		return fmt.Errorf("%s is not owned by the current user", socketPath)
+	}
+	return nil
+}
+
+// dialAuthSocket connects to socketPath and returns the secret to send with requests.
+func dialAuthSocket(socketPath string) (net.Conn, string, error) {
+	if err := checkSocketOwner(socketPath); err != nil {
+		return nil, "", err
+	}
+	secret, err := socketSecret(socketPath)
+	if err != nil {
+		return nil, "", err
+	}
+	conn, err := net.DialTimeout("unix", socketPath, socketTimeout)
+	if err != nil {
+		return nil, "", err
+	}
+	conn.SetDeadline(time.Now().Add(socketTimeout))
+	return conn, secret, nil
+}
+
+// getAccessToken asks the auth servers for a token.
+func getAccessToken(scopes, organization string) (*tokenResponse, error) {
+	return askAuthSockets(func(socketPath string) (*tokenResponse, error) {
+		return requestToken(socketPath, scopes, organization)
+	})
+}
+
+// getCredential asks the auth servers for a username and password for host.
+func getCredential(host, organization string) (*credentialResponse, error) {
+	return askAuthSockets(func(socketPath string) (*credentialResponse, error) {
+		return requestCredential(socketPath, host, organization)
+	})
+}
+
+// askAuthSockets sends a request to each auth socket in turn. Sockets that can't be
+// reached are skipped, but an error response from a live server is returned at once.
+func askAuthSockets[T any](request func(socketPath string) (T, error)) (T, error) {
+	var zero T
+	sockets := findAuthSockets()
+	if len(sockets) == 0 {
+		return zero, errNoAuthSockets
+	}
+
+	var errs []error
+	for _, socketPath := range sockets {
+		resp, err := request(socketPath)
+		if err == nil {
+			return resp, nil
+		}
+		var svcErr *serviceError
+		if errors.As(err, &svcErr) {
+			return zero, err
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", socketPath, err))
+	}
+	return zero, errors.Join(errs...)
+}
+
+// requestToken sends a getAccessToken request to one socket.
+func requestToken(socketPath, scopes, organization string) (*tokenResponse, error) {
+	conn, secret, err := dialAuthSocket(socketPath)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	req := tokenRequest{Type: "getAccessToken", Secret: secret, Data: tokenRequestData{Scopes: scopes, Organization: organization}}
+	return exchangeToken(conn, req)
+}
+
+// exchangeToken writes req to conn and reads the accessToken response.
+func exchangeToken(conn io.ReadWriter, req tokenRequest) (*tokenResponse, error) {
+	resp, err := exchange(conn, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Type != "accessToken" {
+		return nil, fmt.Errorf("unexpected response type %q", resp.Type)
+	}
+
+	var token string
+	if err := json.Unmarshal(resp.Data, &token); err != nil || token == "" {
+		return nil, errors.New("response did not contain a token")
+	}
+	return &tokenResponse{Token: token, ExpiresOn: resp.ExpiresOn, TokenType: resp.TokenType}, nil
+}
+
+// credentialResponse is a successful credential response.
+type credentialResponse struct {
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	ExpiresOn int64  `json:"-"`
+}
+
+// requestCredential sends a getCredential request for host to one socket.
+func requestCredential(socketPath, host, organization string) (*credentialResponse, error) {
+	conn, secret, err := dialAuthSocket(socketPath)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	resp, err := exchange(conn, tokenRequest{Type: "getCredential", Secret: secret, Data: tokenRequestData{Host: host, Organization: organization}})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Type != "credential" {
+		return nil, fmt.Errorf("unexpected response type %q", resp.Type)
+	}
+
+	var cred credentialResponse
+	if err := json.Unmarshal(resp.Data, &cred); err != nil || cred.Password == "" {
+		return nil, errors.New("response did not contain a credential")
+	}
+	cred.ExpiresOn = resp.ExpiresOn
+	return &cred, nil
+}
+
+// statusInfo mirrors the auth server's StatusInfo.
+type statusInfo struct {
+	Version           string        `json:"version"`
+	SessionID         string        `json:"sessionId"`
+	Login             string        `json:"login,omitempty"`
+	Subscription      string        `json:"subscription,omitempty"`
+	Tenant            string        `json:"tenant,omitempty"`
+	CredentialSources []string      `json:"credentialSources,omitempty"`
+	ActiveCredential  string        `json:"activeCredential,omitempty"`
+	CanMintToken      *bool         `json:"canMintToken,omitempty"`
+	TokenError        *serviceError `json:"tokenError,omitempty"`
+	Metrics           *metricsInfo  `json:"metrics,omitempty"`
+}
+
+// metricsInfo counts the token requests an auth server has served this session.
+type metricsInfo struct {
+	Requests    map[string]int `json:"requests"`
+	Failures    map[string]int `json:"failures"`
+	CacheHits   int            `json:"cacheHits"`
+	CacheMisses int            `json:"cacheMisses"`
+	GetToken    latencyStats   `json:"getToken"`
+	Mint        latencyStats   `json:"mint"`
+}
+
+// latencyStats summarizes recent latencies in milliseconds.
+type latencyStats struct {
+	Count int     `json:"count"`
+	P50Ms float64 `json:"p50Ms"`
+	P95Ms float64 `json:"p95Ms"`
+	MaxMs float64 `json:"maxMs"`
+}
+
+// requestStatus sends a status request to one socket.
+func requestStatus(socketPath string) (*statusInfo, error) {
+	conn, secret, err := dialAuthSocket(socketPath)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	resp, err := exchange(conn, tokenRequest{Type: "status", Secret: secret})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Type != "status" {
+		return nil, fmt.Errorf("unexpected response type %q", resp.Type)
+	}
+
+	var info statusInfo
+	if err := json.Unmarshal(resp.Data, &info); err != nil {
+		return nil, fmt.Errorf("decode status: %w", err)
+	}
+	return &info, nil
+}
+
+// exchange writes req to conn and reads one \f-delimited response. Error responses
+// are returned as *serviceError.
+func exchange(conn io.ReadWriter, req tokenRequest) (*authResponse, error) {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+	if _, err := conn.Write(append(payload, '\f')); err != nil {
+		return nil, fmt.Errorf("write request: %w", err)
+	}
+
+	frame, err := readFrame(bufio.NewReader(conn))
+	if err != nil {
+		return nil, err
+	}
+
+	var resp authResponse
+	if err := json.Unmarshal(frame, &resp); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+
+	if resp.Type == "error" {
+		svcErr := &serviceError{Code: "unknown", Message: "Unknown error"}
+		json.Unmarshal(resp.Data, svcErr)
+		return nil, svcErr
+	}
+	return &resp, nil
+}
+
+// readFrame reads a single message up to the \f delimiter. A response that ends at
+// EOF without a delimiter is accepted as long as it isn't empty.
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	var frame []byte
+	for {
+		chunk, err := r.ReadSlice('\f')
+		frame = append(frame, chunk...)
+		if len(frame) > maxResponseSize {
+			return nil, fmt.Errorf("response exceeds %d bytes", maxResponseSize)
+		}
+		switch {
+		case err == nil:
+			return frame[:len(frame)-1], nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case errors.Is(err, io.EOF) && len(frame) > 0:
+			return frame, nil
+		default:
+			return nil, fmt.Errorf("read response: %w", err)
+		}
+	}
+}
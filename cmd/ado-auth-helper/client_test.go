@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testSecret is the session secret serveAuthSocket writes for its socket.
+const testSecret = "test-secret"
+
+// serveAuthSocket answers one request on a unix socket with response followed by \f.
+// HOME is pointed at a temporary directory holding the socket's session secret.
+func serveAuthSocket(t *testing.T, response string) (string, <-chan tokenRequest) {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.MkdirAll(filepath.Join(home, secretDir), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, secretDir, "auth"), []byte(testSecret+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	socketPath := filepath.Join(t.TempDir(), "auth.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	requests := make(chan tokenRequest, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		line, err := bufio.NewReader(conn).ReadString('\f')
+		if err != nil {
+			return
+		}
+		var req tokenRequest
+		json.Unmarshal([]byte(strings.TrimSuffix(line, "\f")), &req)
+		requests <- req
+
+		conn.Write([]byte(response + "\f"))
+	}()
+
+	return socketPath, requests
+}
+
+func TestRequestToken_ReadsLargeResponses(t *testing.T) {
+	// The Python helper read a single 16 KB chunk and truncated tokens larger than that.
+	token := strings.Repeat("a", 64*1024)
+	socketPath, requests := serveAuthSocket(t, `{"type":"accessToken","data":"`+token+`","expiresOn":1735689600,"tokenType":"Bearer"}`)
+
+	resp, err := requestToken(socketPath, "scope/.default", "contoso")
+	if err != nil {
+		t.Fatalf("requestToken() error = %v", err)
+	}
+	if resp.Token != token {
+		t.Errorf("expected %d byte token, got %d bytes", len(token), len(resp.Token))
+	}
+	if resp.ExpiresOn != 1735689600 || resp.TokenType != "Bearer" {
+		t.Errorf("unexpected token metadata: %+v", resp)
+	}
+
+	req := <-requests
+	if req.Type != "getAccessToken" || req.Data.Scopes != "scope/.default" || req.Data.Organization != "contoso" {
+		t.Errorf("unexpected request: %+v", req)
+	}
+	if req.Secret != testSecret {
+		t.Errorf("expected session secret %q, got %q", testSecret, req.Secret)
+	}
+}
+
+func TestRequestToken_RequiresSessionSecret(t *testing.T) {
+	socketPath, requests := serveAuthSocket(t, `{"type":"accessToken","data":"token"}`)
+	if err := os.Remove(filepath.Join(os.Getenv("HOME"), secretDir, "auth")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := requestToken(socketPath, "", ""); err == nil {
+		t.Fatal("expected an error without a session secret")
+	}
+	select {
+	case req := <-requests:
+		t.Errorf("expected no request to be sent, got %+v", req)
+	default:
+	}
+}
+
+func TestRequestToken_RequiresOwnedSocket(t *testing.T) {
+	socketPath, requests := serveAuthSocket(t, `{"type":"accessToken","data":"token"}`)
+	dir := filepath.Dir(socketPath)
+
+	// A link to the real socket and a plain file in its place are both refused
+	link := filepath.Join(dir, "link.sock")
+	if err := os.Symlink(socketPath, link); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "file.sock")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{link, file} {
+		if _, err := requestToken(path, "", ""); err == nil || !strings.Contains(err.Error(), "not a socket") {
+			t.Errorf("requestToken(%s) error = %v, want not a socket", filepath.Base(path), err)
+		}
+	}
+	select {
+	case req := <-requests:
+		t.Errorf("expected no request to be sent, got %+v", req)
+	default:
+	}
+
+	if err := checkSocketOwner(socketPath); err != nil {
+		t.Errorf("checkSocketOwner() error = %v for the user's own socket", err)
+	}
+}
+
+func TestRequestToken_ReturnsServiceErrors(t *testing.T) {
+	socketPath, _ := serveAuthSocket(t, `{"type":"error","data":{"code":"not_logged_in","message":"Run az login"}}`)
+
+	_, err := requestToken(socketPath, "", "")
+	var svcErr *serviceError
+	if !errors.As(err, &svcErr) {
+		t.Fatalf("expected serviceError, got %v", err)
+	}
+	if svcErr.Code != "not_logged_in" || svcErr.Message != "Run az login" {
+		t.Errorf("unexpected service error: %+v", svcErr)
+	}
+}
+
+func TestRequestCredential(t *testing.T) {
+	socketPath, requests := serveAuthSocket(t, `{"type":"credential","data":{"username":"VssSessionToken","password":"secret-token"},"expiresOn":1735689600}`)
+
+	cred, err := requestCredential(socketPath, "pkgs.dev.azure.com", "contoso")
+	if err != nil {
+		t.Fatalf("requestCredential() error = %v", err)
+	}
+	if cred.Username != "VssSessionToken" || cred.Password != "secret-token" || cred.ExpiresOn != 1735689600 {
+		t.Errorf("unexpected credential %+v", cred)
+	}
+
+	req := <-requests
+	if req.Type != "getCredential" || req.Data.Host != "pkgs.dev.azure.com" || req.Data.Organization != "contoso" || req.Secret != testSecret {
+		t.Errorf("unexpected request: %+v", req)
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{name: "delimited", input: "{\"a\":1}\fextra", want: `{"a":1}`},
+		{name: "EOF without delimiter", input: `{"a":1}`, want: `{"a":1}`},
+		{name: "larger than reader buffer", input: strings.Repeat("x", 10000) + "\f", want: strings.Repeat("x", 10000)},
+		{name: "empty", input: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readFrame(bufio.NewReaderSize(strings.NewReader(tt.input), 16))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readFrame() error = %v", err)
+			}
+			if !bytes.Equal(got, []byte(tt.want)) {
+				t.Errorf("readFrame() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestStatus(t *testing.T) {
+	socketPath, requests := serveAuthSocket(t, `{"type":"status","data":{"version":"1.2.3","sessionId":"session","login":"octocat","credentialSources":["azureCLI"],"activeCredential":"azureCLI","canMintToken":false,"tokenError":{"code":"not_logged_in","message":"Run az login"},"metrics":{"requests":{"499b84ac-1321-427f-aa17-267ca6975798/.default":3},"failures":{"not_logged_in":2},"cacheHits":2,"cacheMisses":1,"getToken":{"count":3,"p50Ms":0.5,"p95Ms":812},"mint":{"count":1,"p50Ms":812,"p95Ms":812}}}}`)
+
+	info, err := requestStatus(socketPath)
+	if err != nil {
+		t.Fatalf("requestStatus() error = %v", err)
+	}
+	if req := <-requests; req.Type != "status" {
+		t.Errorf("expected status request, got %q", req.Type)
+	}
+
+	status := socketStatus{Socket: socketPath, Status: info}
+	if status.healthy() {
+		t.Error("expected a server that can't mint tokens to be unhealthy")
+	}
+
+	var out bytes.Buffer
+	printSocketStatus(&out, status)
+	for _, want := range []string{
+		"1.2.3", "octocat", "azureCLI (active: azureCLI)", "unavailable: Run az login (not_logged_in)",
+		"3 (cache: 2 hits, 1 misses)", "3 calls, p50 0.5ms, p95 812ms", "not_logged_in: 2",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected status output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// dockerCredentialName is the name docker runs the helper under for "credsStore": "ado"
+// or "credHelpers" entries set to "ado".
+const dockerCredentialName = "docker-credential-ado"
+
+// errCredentialsNotFound is the message docker expects when a helper has no credentials.
+const errCredentialsNotFound = "credentials not found in native keychain"
+
+// dockerCredential is the docker credential helper "get" output.
+type dockerCredential struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// runDockerCredential implements the docker credential helper protocol. Only "get"
+// does any work; credentials come from the auth server and are never stored.
+func runDockerCredential(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintf(stderr, "usage: %s <get|store|erase|list>\n", dockerCredentialName)
+		return 1
+	}
+
+	switch args[0] {
+	case "get":
+		return runDockerGet(stdin, stdout, stderr)
+	case "store", "erase":
+		io.Copy(io.Discard, stdin)
+		return 0
+	case "list":
+		fmt.Fprintln(stdout, "{}")
+		return 0
+	default:
+		fmt.Fprintf(stderr, "%s: unknown action %q\n", dockerCredentialName, args[0])
+		return 1
+	}
+}
+
+// runDockerGet reads a registry URL from stdin and prints its credentials as JSON.
+func runDockerGet(stdin io.Reader, stdout, stderr io.Writer) int {
+	input, err := io.ReadAll(stdin)
+	if err != nil {
+		return reportError(stderr, err)
+	}
+	serverURL := strings.TrimSpace(string(input))
+	host := registryHost(serverURL)
+	if host == "" {
+		fmt.Fprintln(stdout, errCredentialsNotFound)
+		return 1
+	}
+
+	cred, err := getCredential(host, "")
+	var svcErr *serviceError
+	if errors.As(err, &svcErr) && svcErr.Code == errCodeNoProvider {
+		fmt.Fprintln(stdout, errCredentialsNotFound)
+		return 1
+	}
+	if err != nil {
+		// docker shows stdout to the user.
+		fmt.Fprintf(stdout, "ado-auth-helper: %v\n", err)
+		return 1
+	}
+
+	out, err := json.Marshal(dockerCredential{ServerURL: serverURL, Username: cred.Username, Secret: cred.Password})
+	if err != nil {
+		return reportError(stderr, err)
+	}
+	fmt.Fprintln(stdout, string(out))
+	return 0
+}
+
+// registryHost returns the host of a docker server URL, which may be a bare host such
+// as "contoso.azurecr.io" or a URL such as "https://contoso.azurecr.io/v2/".
+func registryHost(serverURL string) string {
+	if !strings.Contains(serverURL, "://") {
+		serverURL = "https://" + serverURL
+	}
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRegistryHost(t *testing.T) {
+	tests := map[string]string{
+		"contoso.azurecr.io":             "contoso.azurecr.io",
+		"https://contoso.azurecr.io":     "contoso.azurecr.io",
+		"https://contoso.azurecr.io/v2/": "contoso.azurecr.io",
+		"contoso.azurecr.io:443":         "contoso.azurecr.io:443",
+		"https://index.docker.io/v1/":    "index.docker.io",
+		"":                               "",
+	}
+
+	for input, want := range tests {
+		if got := registryHost(input); got != want {
+			t.Errorf("registryHost(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestRunDockerCredential_ListStoreErase(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"docker-credential", "list"}, strings.NewReader(""), &stdout, &stderr); code != 0 || strings.TrimSpace(stdout.String()) != "{}" {
+		t.Errorf("list: expected empty object, got code %d, stdout %q", code, stdout.String())
+	}
+
+	for _, action := range []string{"store", "erase"} {
+		stdout.Reset()
+		code := run([]string{"docker-credential", action}, strings.NewReader(`{"ServerURL":"contoso.azurecr.io","Username":"u","Secret":"s"}`), &stdout, &stderr)
+		if code != 0 || stdout.Len() != 0 {
+			t.Errorf("%s: expected silent success, got code %d, stdout %q", action, code, stdout.String())
+		}
+	}
+}
+
+func TestRunDockerGet_NoServerURL(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"docker-credential", "get"}, strings.NewReader("\n"), &stdout, &stderr)
+	if code != 1 || strings.TrimSpace(stdout.String()) != errCredentialsNotFound {
+		t.Errorf("expected not-found response, got code %d, stdout %q", code, stdout.String())
+	}
+}
@@ -0,0 +1,266 @@
+// Command ado-auth-helper runs inside the codespace. It fetches tokens from the auth
+// server that gh ado-codespaces forwards to /tmp/ado-auth-*.sock and acts as a git
+// credential helper, as a docker credential helper, as a minimal stand-in for
+// 'az account get-access-token' and as a shim that authenticates package tools
+// against Azure Artifacts feeds. It also reports listening ports for the extension's
+// port forwarding.
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const usage = `usage: ado-auth-helper <command> [args]
+
+commands:
+  get                    git credential helper: print credentials for configured hosts
+  store, erase           git credential helper: no-ops
+  get-access-token       print an access token
+      [--json] [--organization <org>] [--scope <scope> | --resource <url> | <scope>]
+  status [--json]        show each auth server and whether it can get a token
+  docker-credential <get|store|erase|list>
+                         docker credential helper (also run as docker-credential-ado)
+  shim <tool> [args]     run npm, npx, dotnet, nuget, pip, pip3 or twine with
+                         Azure Artifacts feed credentials (also run as <tool>)
+  watch-ports [--interval <duration>]
+                         report listening TCP ports as JSON lines as they change
+`
+
+func main() {
+	args := os.Args[1:]
+	name := filepath.Base(os.Args[0])
+	if name == dockerCredentialName {
+		args = append([]string{"docker-credential"}, args...)
+	} else if _, ok := shimTools[name]; ok {
+		args = append([]string{"shim", name}, args...)
+	}
+	os.Exit(run(args, os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run dispatches a helper command and returns the process exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 1
+	}
+
+	switch args[0] {
+	case "get":
+		return runGet(stdin, stdout, stderr)
+	case "store", "erase":
+		// Tokens are short-lived and always fetched fresh, so there's nothing to store.
+		io.Copy(io.Discard, stdin)
+		return 0
+	case "get-access-token":
+		return runGetAccessToken(args[1:], stdout, stderr)
+	case "status":
+		return runStatus(args[1:], stdout, stderr)
+	case "docker-credential":
+		return runDockerCredential(args[1:], stdin, stdout, stderr)
+	case "shim":
+		return runShim(args[1:], stdin, stdout, stderr)
+	case "watch-ports":
+		return runWatchPorts(args[1:], stdout, stderr)
+	default:
+		fmt.Fprintf(stderr, "ado-auth-helper: unknown command %q\n\n%s", args[0], usage)
+		return 1
+	}
+}
+
+// runGet implements the git credential helper "get" operation. The auth server picks
+// the credential provider for the host. Hosts without a provider get no output so git
+// moves on to the next helper.
+func runGet(stdin io.Reader, stdout, stderr io.Writer) int {
+	fields := parseGitCredentialInput(stdin)
+	host := fields["host"]
+	if host == "" {
+		return 0
+	}
+	organization, isADO := adoOrganization(fields)
+
+	cred, err := getCredential(host, organization)
+	var svcErr *serviceError
+	switch {
+	case errors.As(err, &svcErr) && svcErr.Code == errCodeNoProvider:
+		return 0
+	case err != nil && !isADO && svcErr == nil:
+		// No server answered, so we can't tell whether the host has a provider.
+		return 0
+	case err != nil:
+		return reportError(stderr, err)
+	}
+
+	fmt.Fprintln(stdout, "username="+cred.Username)
+	fmt.Fprintln(stdout, "password="+cred.Password)
+	if cred.ExpiresOn != 0 {
+		fmt.Fprintf(stdout, "password_expiry_utc=%d\n", cred.ExpiresOn)
+	}
+	return 0
+}
+
+// runGetAccessToken prints a token, or az-compatible JSON with --json.
+func runGetAccessToken(args []string, stdout, stderr io.Writer) int {
+	opts := parseGetAccessTokenArgs(args)
+
+	resp, err := getAccessToken(opts.scope, opts.organization)
+	if err != nil {
+		return reportError(stderr, err)
+	}
+
+	if !opts.asJSON {
+		fmt.Fprintln(stdout, resp.Token)
+		return 0
+	}
+
+	out, err := formatAzToken(resp, time.Local)
+	if err != nil {
+		return reportError(stderr, err)
+	}
+	fmt.Fprintln(stdout, out)
+	return 0
+}
+
+// reportError prints err for the user and returns the failure exit code.
+func reportError(stderr io.Writer, err error) int {
+	fmt.Fprintf(stderr, "ado-auth-helper: %v\n", err)
+	return 1
+}
+
+// parseGitCredentialInput parses git credential helper input (key=value lines).
+func parseGitCredentialInput(r io.Reader) map[string]string {
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			fields[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return fields
+}
+
+// adoOrganization extracts the Azure DevOps organization from git credential fields.
+// It handles https://dev.azure.com/{org}/... (the path needs credential.useHttpPath,
+// otherwise the {org}@ username git sends is used), https://{org}.visualstudio.com/...
+// and the matching Azure Artifacts hosts, pkgs.dev.azure.com/{org}/... and
+// {org}.pkgs.visualstudio.com. ok is false when the host isn't an Azure DevOps host.
+func adoOrganization(fields map[string]string) (string, bool) {
+	host := strings.ToLower(fields["host"])
+	host, _, _ = strings.Cut(host, ":")
+
+	if host == "dev.azure.com" || host == "pkgs.dev.azure.com" {
+		path := strings.Trim(fields["path"], "/")
+		if path != "" {
+			org, _, _ := strings.Cut(path, "/")
+			return org, true
+		}
+		if host == "pkgs.dev.azure.com" {
+			return "", true
+		}
+		return fields["username"], true
+	}
+
+	if org, ok := strings.CutSuffix(host, ".visualstudio.com"); ok {
+		org = strings.TrimSuffix(org, ".pkgs")
+		// Strip the vs-ssh prefix used by some remotes.
+		return strings.TrimPrefix(org, "vs-ssh."), true
+	}
+
+	return "", false
+}
+
+// getAccessTokenOptions holds parsed get-access-token arguments.
+type getAccessTokenOptions struct {
+	scope        string
+	organization string
+	asJSON       bool
+}
+
+// parseGetAccessTokenArgs parses --json, --organization, az-style --scope/--resource
+// and a positional scope (used by azure-auth-helper).
+func parseGetAccessTokenArgs(args []string) getAccessTokenOptions {
+	var opts getAccessTokenOptions
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		hasValue := i+1 < len(args)
+		switch {
+		case arg == "--json":
+			opts.asJSON = true
+		case arg == "--organization" && hasValue:
+			i++
+			opts.organization = args[i]
+		case arg == "--scope" && hasValue:
+			i++
+			opts.scope = args[i]
+		case arg == "--resource" && hasValue:
+			i++
+			opts.scope = strings.TrimRight(args[i], "/") + "/.default"
+		case !strings.HasPrefix(arg, "-"):
+			opts.scope = arg
+		}
+	}
+	return opts
+}
+
+// azToken matches the output of 'az account get-access-token'.
+type azToken struct {
+	AccessToken string `json:"accessToken"`
+	TokenType   string `json:"tokenType"`
+	ExpiresOn   string `json:"expiresOn,omitempty"`
+	ExpiresOnTS int64  `json:"expires_on,omitempty"`
+	Tenant      string `json:"tenant,omitempty"`
+}
+
+// formatAzToken formats resp like 'az account get-access-token'. az reports expiresOn
+// in local time and expires_on as a Unix timestamp.
+func formatAzToken(resp *tokenResponse, loc *time.Location) (string, error) {
+	out := azToken{
+		AccessToken: resp.Token,
+		TokenType:   resp.TokenType,
+		Tenant:      tokenTenant(resp.Token),
+	}
+	if out.TokenType == "" {
+		out.TokenType = "Bearer"
+	}
+	if resp.ExpiresOn != 0 {
+		out.ExpiresOn = time.Unix(resp.ExpiresOn, 0).In(loc).Format("2006-01-02 15:04:05.000000")
+		out.ExpiresOnTS = resp.ExpiresOn
+	}
+
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// tokenTenant returns the tenant ID (tid claim) of a JWT access token, or "".
+func tokenTenant(token string) string {
+	parts := strings.Split(token, ".")
+	if len(parts) < 2 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		TenantID string `json:"tid"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+	return claims.TenantID
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdoOrganization(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		want   string
+		wantOK bool
+	}{
+		{name: "dev.azure.com path", input: "protocol=https\nhost=dev.azure.com\npath=contoso/project/_git/repo\n", want: "contoso", wantOK: true},
+		{name: "dev.azure.com username", input: "protocol=https\nhost=dev.azure.com\nusername=fabrikam\n", want: "fabrikam", wantOK: true},
+		{name: "visualstudio.com", input: "protocol=https\nhost=contoso.visualstudio.com\n", want: "contoso", wantOK: true},
+		{name: "vs-ssh prefix", input: "protocol=https\nhost=vs-ssh.contoso.visualstudio.com:443\n", want: "contoso", wantOK: true},
+		{name: "artifacts feed path", input: "protocol=https\nhost=pkgs.dev.azure.com\npath=contoso/_packaging/feed/npm/registry/\n", want: "contoso", wantOK: true},
+		{name: "artifacts feed without path", input: "protocol=https\nhost=pkgs.dev.azure.com\nusername=VssSessionToken\n", want: "", wantOK: true},
+		{name: "legacy artifacts feed", input: "protocol=https\nhost=contoso.pkgs.visualstudio.com\n", want: "contoso", wantOK: true},
+		{name: "other host", input: "protocol=https\nhost=github.com\n", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := adoOrganization(parseGitCredentialInput(strings.NewReader(tt.input)))
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("adoOrganization() = (%q, %v), want (%q, %v)", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestParseGetAccessTokenArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want getAccessTokenOptions
+	}{
+		{name: "none", args: nil, want: getAccessTokenOptions{}},
+		{name: "positional scope", args: []string{"scope/.default"}, want: getAccessTokenOptions{scope: "scope/.default"}},
+		{name: "scope flag", args: []string{"--scope", "scope/.default", "--json"}, want: getAccessTokenOptions{scope: "scope/.default", asJSON: true}},
+		{name: "resource flag", args: []string{"--resource", "https://management.azure.com/"}, want: getAccessTokenOptions{scope: "https://management.azure.com/.default"}},
+		{name: "organization", args: []string{"--organization", "contoso"}, want: getAccessTokenOptions{organization: "contoso"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseGetAccessTokenArgs(tt.args); got != tt.want {
+				t.Errorf("parseGetAccessTokenArgs(%v) = %+v, want %+v", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatAzToken(t *testing.T) {
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"tid":"tenant-id"}`))
+	token := "header." + claims + ".signature"
+
+	out, err := formatAzToken(&tokenResponse{Token: token, ExpiresOn: 1735689600}, time.UTC)
+	if err != nil {
+		t.Fatalf("formatAzToken() error = %v", err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatalf("failed to decode output %q: %v", out, err)
+	}
+	want := map[string]interface{}{
+		"accessToken": token,
+		"tokenType":   "Bearer",
+		"expiresOn":   "2025-01-01 00:00:00.000000",
+		"expires_on":  float64(1735689600),
+		"tenant":      "tenant-id",
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %v, want %v", key, got[key], value)
+		}
+	}
+}
+
+func TestRun_StoreAndEraseAreNoOps(t *testing.T) {
+	for _, command := range []string{"store", "erase"} {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{command}, strings.NewReader("protocol=https\nhost=dev.azure.com\n"), &stdout, &stderr)
+		if code != 0 || stdout.Len() != 0 || stderr.Len() != 0 {
+			t.Errorf("%s: expected silent success, got code %d, stdout %q, stderr %q", command, code, stdout.String(), stderr.String())
+		}
+	}
+}
+
+func TestRun_GetIgnoresOtherHosts(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"get"}, strings.NewReader("protocol=https\nhost=github.com\n"), &stdout, &stderr)
+	if code != 0 || stdout.Len() != 0 {
+		t.Errorf("expected no credentials for other hosts, got code %d, stdout %q", code, stdout.String())
+	}
+}
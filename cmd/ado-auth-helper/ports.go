@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// defaultWatchInterval is how often watch-ports checks the listening sockets.
+	defaultWatchInterval = 250 * time.Millisecond
+	// minWatchedPort skips well-known ports, which dev servers don't use.
+	minWatchedPort = 1024
+	// tcpListenState is TCP_LISTEN as shown in the st column of /proc/net/tcp.
+	tcpListenState = 0x0A
+	// maxCommandLength truncates long command lines in port events.
+	maxCommandLength = 200
+)
+
+// listener is a listening socket found in the codespace.
+type listener struct {
+	Port     int
+	Protocol string
+	Address  string
+	Inode    uint64
+}
+
+// processInfo describes the process that owns a listening socket.
+type processInfo struct {
+	PID     int    `json:"pid,omitempty"`
+	Process string `json:"process,omitempty"`
+	Command string `json:"command,omitempty"`
+	Cwd     string `json:"cwd,omitempty"`
+}
+
+// portMessage is a port event written by watch-ports, one JSON object per line. The
+// process fields are left out when the owner can't be found, for example when another
+// user's process holds the socket.
+type portMessage struct {
+	Type     string `json:"type"`
+	Action   string `json:"action"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	// Address is the bind address, a wildcard when any socket on the port has one.
+	Address string `json:"address,omitempty"`
+	processInfo
+	Timestamp string `json:"timestamp"`
+}
+
+// logMessage is a diagnostic written by watch-ports for the extension's debug log.
+type logMessage struct {
+	Type      string `json:"type"`
+	Message   string `json:"message"`
+	Timestamp string `json:"timestamp"`
+}
+
+// runWatchPorts reports listening TCP ports as they are bound and unbound, until it is
+// interrupted or its output is closed.
+func runWatchPorts(args []string, stdout, stderr io.Writer) int {
+	interval := defaultWatchInterval
+	procRoot := "/proc"
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--interval":
+			if i+1 >= len(args) {
+				return reportError(stderr, errors.New("--interval needs a duration"))
+			}
+			i++
+			d, err := time.ParseDuration(args[i])
+			if err != nil || d <= 0 {
+				return reportError(stderr, fmt.Errorf("invalid --interval %q", args[i]))
+			}
+			interval = d
+		case "--proc":
+			if i+1 >= len(args) {
+				return reportError(stderr, errors.New("--proc needs a directory"))
+			}
+			i++
+			procRoot = args[i]
+		default:
+			return reportError(stderr, fmt.Errorf("unknown watch-ports argument %q", args[i]))
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	w := newPortWatcher(stdout)
+	w.scan = func() ([]listener, error) { return scanProcListeners(procRoot) }
+	w.owners = func(inodes map[uint64]bool) map[uint64]processInfo { return findSocketOwners(procRoot, inodes) }
+	if procRoot == "/proc" {
+		if _, err := scanSockDiagListeners(); err == nil {
+			w.scan = scanSockDiagListeners
+			w.log("Port monitor starting (netlink sock_diag)")
+		} else {
+			w.log(fmt.Sprintf("Port monitor starting (/proc/net/tcp; sock_diag unavailable: %v)", err))
+		}
+	} else {
+		w.log("Port monitor starting (" + procRoot + "/net/tcp)")
+	}
+
+	if err := w.run(ctx, interval); err != nil && !errors.Is(err, context.Canceled) {
+		return reportError(stderr, err)
+	}
+	return 0
+}
+
+// portWatcher turns successive scans of the listening sockets into bound and unbound
+// events.
+type portWatcher struct {
+	out    *json.Encoder
+	scan   func() ([]listener, error)
+	owners func(inodes map[uint64]bool) map[uint64]processInfo
+	now    func() time.Time
+	bound  map[string]boundPort // keyed by protocol:port
+}
+
+// boundPort is a reported port and the process that bound it.
+type boundPort struct {
+	listener
+	owner processInfo
+}
+
+// newPortWatcher returns a watcher that writes events to w.
+func newPortWatcher(w io.Writer) *portWatcher {
+	return &portWatcher{
+		out:   json.NewEncoder(w),
+		now:   time.Now,
+		bound: make(map[string]boundPort),
+	}
+}
+
+// run polls every interval until ctx is canceled or an event can't be written, which
+// means the SSH session carrying the output has ended.
+func (w *portWatcher) run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := w.poll(); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			w.log("Signal received, shutting down port monitor...")
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll scans once and emits an event for each port bound or unbound since the last
+// scan. Scan failures are logged and retried on the next poll.
+func (w *portWatcher) poll() error {
+	listeners, err := w.scan()
+	if err != nil {
+		return w.log(fmt.Sprintf("Failed to list listening sockets: %v", err))
+	}
+
+	current := make(map[string]listener)
+	for _, l := range listeners {
+		if l.Port < minWatchedPort {
+			continue
+		}
+		key := l.Protocol + ":" + strconv.Itoa(l.Port)
+		if seen, ok := current[key]; !ok || (isWildcardAddress(l.Address) && !isWildcardAddress(seen.Address)) {
+			current[key] = l
+		}
+	}
+
+	var added []string
+	inodes := make(map[uint64]bool)
+	for _, key := range slices.Sorted(maps.Keys(current)) {
+		if _, ok := w.bound[key]; !ok {
+			added = append(added, key)
+			inodes[current[key].Inode] = true
+		}
+	}
+	var owners map[uint64]processInfo
+	if len(added) > 0 && w.owners != nil {
+		owners = w.owners(inodes)
+	}
+
+	for _, key := range added {
+		port := boundPort{listener: current[key], owner: owners[current[key].Inode]}
+		w.bound[key] = port
+		if err := w.emit("bound", port); err != nil {
+			return err
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(w.bound)) {
+		if _, ok := current[key]; ok {
+			continue
+		}
+		port := w.bound[key]
+		delete(w.bound, key)
+		if err := w.emit("unbound", port); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emit writes a port event.
+func (w *portWatcher) emit(action string, port boundPort) error {
+	return w.out.Encode(portMessage{
+		Type:        "port",
+		Action:      action,
+		Port:        port.Port,
+		Protocol:    port.Protocol,
+		Address:     port.Address,
+		processInfo: port.owner,
+		Timestamp:   w.timestamp(),
+	})
+}
+
+// isWildcardAddress reports whether address accepts connections on every interface.
+func isWildcardAddress(address string) bool {
+	ip := net.ParseIP(address)
+	return ip != nil && ip.IsUnspecified()
+}
+
+// log writes a log message.
+func (w *portWatcher) log(message string) error {
+	return w.out.Encode(logMessage{Type: "log", Message: message, Timestamp: w.timestamp()})
+}
+
+// timestamp formats the current time like the events always have.
+func (w *portWatcher) timestamp() string {
+	return w.now().UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// scanProcListeners lists the listening TCP sockets in <procRoot>/net/tcp and tcp6.
+// The tcp6 file is missing when IPv6 is disabled.
+func scanProcListeners(procRoot string) ([]listener, error) {
+	var all []listener
+	for _, name := range []string{"tcp", "tcp6"} {
+		listeners, err := readProcNetTCP(filepath.Join(procRoot, "net", name))
+		if err != nil {
+			if name == "tcp6" && errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		all = append(all, listeners...)
+	}
+	return all, nil
+}
+
+// readProcNetTCP parses the listening sockets from a /proc/net/tcp or tcp6 file.
+func readProcNetTCP(path string) ([]listener, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var listeners []listener
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		// sl local_address rem_address st tx:rx tr:when retrnsmt uid timeout inode ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		if state, err := strconv.ParseUint(fields[3], 16, 8); err != nil || state != tcpListenState {
+			continue
+		}
+		address, port, err := parseProcAddress(fields[1])
+		if err != nil {
+			continue
+		}
+		inode, _ := strconv.ParseUint(fields[9], 10, 64)
+		listeners = append(listeners, listener{Port: port, Protocol: "tcp", Address: address, Inode: inode})
+	}
+	return listeners, scanner.Err()
+}
+
+// parseProcAddress parses a local_address column such as 0100007F:0BB8. The address
+// is stored as native-endian 32-bit words, and the port in hex.
+func parseProcAddress(field string) (string, int, error) {
+	hexAddr, hexPort, ok := strings.Cut(field, ":")
+	if !ok {
+		return "", 0, fmt.Errorf("invalid address %q", field)
+	}
+	port, err := strconv.ParseUint(hexPort, 16, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in %q", field)
+	}
+	raw, err := hex.DecodeString(hexAddr)
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return "", 0, fmt.Errorf("invalid address %q", field)
+	}
+
+	ip := make(net.IP, len(raw))
+	for i := 0; i < len(raw); i += 4 {
+		binary.BigEndian.PutUint32(ip[i:], binary.LittleEndian.Uint32(raw[i:]))
+	}
+	return ip.String(), int(port), nil
+}
+
+// findSocketOwners maps socket inodes to the processes holding them, by looking for
+// socket:[inode] links in /proc/<pid>/fd. Processes whose fds can't be read are
+// skipped, so sockets of other users' processes have no owner.
+func findSocketOwners(procRoot string, inodes map[uint64]bool) map[uint64]processInfo {
+	owners := make(map[uint64]processInfo)
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return owners
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join(procRoot, entry.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil {
+				continue
+			}
+			inode, ok := socketInode(target)
+			if !ok || !inodes[inode] {
+				continue
+			}
+			if _, found := owners[inode]; !found {
+				owners[inode] = readProcessInfo(procRoot, pid)
+			}
+		}
+		if len(owners) == len(inodes) {
+			break
+		}
+	}
+	return owners
+}
+
+// socketInode parses an fd link target such as socket:[12345].
+func socketInode(target string) (uint64, bool) {
+	rest, ok := strings.CutPrefix(target, "socket:[")
+	if !ok {
+		return 0, false
+	}
+	inode, err := strconv.ParseUint(strings.TrimSuffix(rest, "]"), 10, 64)
+	return inode, err == nil
+}
+
+// readProcessInfo reads the name, command line and working directory of pid. Fields
+// that can't be read are left empty.
+func readProcessInfo(procRoot string, pid int) processInfo {
+	dir := filepath.Join(procRoot, strconv.Itoa(pid))
+	info := processInfo{PID: pid}
+	if comm, err := os.ReadFile(filepath.Join(dir, "comm")); err == nil {
+		info.Process = strings.TrimSpace(string(comm))
+	}
+	if cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline")); err == nil {
+		command := strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " "))
+		if len(command) > maxCommandLength {
+			command = command[:maxCommandLength-3] + "..."
+		}
+		info.Command = command
+	}
+	if cwd, err := os.Readlink(filepath.Join(dir, "cwd")); err == nil {
+		info.Cwd = cwd
+	}
+	return info
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+const (
+	// sockDiagByFamily is SOCK_DIAG_BY_FAMILY from linux/sock_diag.h.
+	sockDiagByFamily = 20
+	// inetDiagReqLen is the size of struct inet_diag_req_v2.
+	inetDiagReqLen = 56
+	// inetDiagMsgLen is the size of struct inet_diag_msg.
+	inetDiagMsgLen = 72
+)
+
+// scanSockDiagListeners lists the listening TCP sockets with a netlink sock_diag dump,
+// which is cheaper than formatting and parsing /proc/net/tcp on busy machines.
+func scanSockDiagListeners() ([]listener, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, syscall.NETLINK_INET_DIAG)
+	if err != nil {
+		return nil, fmt.Errorf("netlink socket: %w", err)
+	}
+	defer syscall.Close(fd)
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, fmt.Errorf("netlink bind: %w", err)
+	}
+
+	var all []listener
+	for _, family := range []uint8{syscall.AF_INET, syscall.AF_INET6} {
+		listeners, err := sockDiagDump(fd, family)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, listeners...)
+	}
+	return all, nil
+}
+
+// sockDiagDump requests the listening TCP sockets of one address family.
+func sockDiagDump(fd int, family uint8) ([]listener, error) {
+	req := make([]byte, syscall.NLMSG_HDRLEN+inetDiagReqLen)
+	binary.NativeEndian.PutUint32(req[0:], uint32(len(req)))
+	binary.NativeEndian.PutUint16(req[4:], sockDiagByFamily)
+	binary.NativeEndian.PutUint16(req[6:], syscall.NLM_F_REQUEST|syscall.NLM_F_DUMP)
+	binary.NativeEndian.PutUint32(req[8:], 1)
+	body := req[syscall.NLMSG_HDRLEN:]
+	body[0] = family
+	body[1] = syscall.IPPROTO_TCP
+	binary.NativeEndian.PutUint32(body[4:], 1<<tcpListenState)
+
+	if err := syscall.Sendto(fd, req, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, fmt.Errorf("sock_diag request: %w", err)
+	}
+
+	var listeners []listener
+	buf := make([]byte, 32*1024)
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return nil, fmt.Errorf("sock_diag response: %w", err)
+		}
+		messages, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, fmt.Errorf("sock_diag response: %w", err)
+		}
+		for _, m := range messages {
+			switch m.Header.Type {
+			case syscall.NLMSG_DONE:
+				return listeners, nil
+			case syscall.NLMSG_ERROR:
+				if len(m.Data) >= 4 {
+					if errno := int32(binary.NativeEndian.Uint32(m.Data)); errno != 0 {
+						return nil, fmt.Errorf("sock_diag: %w", syscall.Errno(-errno))
+					}
+				}
+				return listeners, nil
+			case sockDiagByFamily:
+				if l, ok := parseInetDiagMsg(m.Data); ok {
+					listeners = append(listeners, l)
+				}
+			}
+		}
+	}
+}
+
+// parseInetDiagMsg reads the socket from a struct inet_diag_msg.
+func parseInetDiagMsg(data []byte) (listener, bool) {
+	if len(data) < inetDiagMsgLen {
+		return listener{}, false
+	}
+	family := data[0]
+	// inet_diag_sockid starts at offset 4; ports are big-endian, addresses in network order
+	port := int(binary.BigEndian.Uint16(data[4:]))
+	var ip net.IP
+	if family == syscall.AF_INET {
+		ip = net.IP(append([]byte(nil), data[8:12]...))
+	} else {
+		ip = net.IP(append([]byte(nil), data[8:24]...))
+	}
+	inode := binary.NativeEndian.Uint32(data[68:])
+	return listener{Port: port, Protocol: "tcp", Address: ip.String(), Inode: uint64(inode)}, true
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// scanSockDiagListeners is only available on Linux, where codespaces run.
+func scanSockDiagListeners() ([]listener, error) {
+	return nil, errors.New("sock_diag is only available on Linux")
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// procNetTCP is a /proc/net/tcp fixture with listeners on 127.0.0.1:3000, 0.0.0.0:8080
+// and 0.0.0.0:22, plus an established connection from port 40000.
+const procNetTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:0BB8 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 11111 1 0000000000000000 100 0 0 10 0
+   1: 00000000:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 22222 1 0000000000000000 100 0 0 10 0
+   2: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 33333 1 0000000000000000 100 0 0 10 0
+   3: 0100007F:9C40 0100007F:0BB8 01 00000000:00000000 00:00000000 00000000  1000        0 44444 1 0000000000000000 20 4 30 10 -1
+`
+
+// procNetTCP6 is a /proc/net/tcp6 fixture with listeners on [::]:5173 and [::1]:9229.
+const procNetTCP6 = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000000000000:1435 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 55555 1 0000000000000000 100 0 0 10 0
+   1: 00000000000000000000000001000000:240D 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 66666 1 0000000000000000 100 0 0 10 0
+`
+
+// writeTestProc writes a fake /proc with the given net/tcp and net/tcp6 contents. An
+// empty tcp6 leaves the file out, as on hosts without IPv6.
+func writeTestProc(t *testing.T, tcp, tcp6 string) string {
+	t.Helper()
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "net", "tcp"), tcp)
+	if tcp6 != "" {
+		writeTestFile(t, filepath.Join(root, "net", "tcp6"), tcp6)
+	}
+	return root
+}
+
+func TestScanProcListeners(t *testing.T) {
+	root := writeTestProc(t, procNetTCP, procNetTCP6)
+
+	listeners, err := scanProcListeners(root)
+	if err != nil {
+		t.Fatalf("scanProcListeners() error = %v", err)
+	}
+	want := []listener{
+		{Port: 3000, Protocol: "tcp", Address: "127.0.0.1", Inode: 11111},
+		{Port: 8080, Protocol: "tcp", Address: "0.0.0.0", Inode: 22222},
+		{Port: 22, Protocol: "tcp", Address: "0.0.0.0", Inode: 33333},
+		{Port: 5173, Protocol: "tcp", Address: "::", Inode: 55555},
+		{Port: 9229, Protocol: "tcp", Address: "::1", Inode: 66666},
+	}
+	if len(listeners) != len(want) {
+		t.Fatalf("scanProcListeners() = %+v, want %+v", listeners, want)
+	}
+	for i := range want {
+		if listeners[i] != want[i] {
+			t.Errorf("listener %d = %+v, want %+v", i, listeners[i], want[i])
+		}
+	}
+}
+
+func TestScanProcListeners_WithoutIPv6(t *testing.T) {
+	root := writeTestProc(t, procNetTCP, "")
+
+	listeners, err := scanProcListeners(root)
+	if err != nil {
+		t.Fatalf("scanProcListeners() error = %v", err)
+	}
+	if len(listeners) != 3 {
+		t.Errorf("scanProcListeners() = %+v, want the 3 IPv4 listeners", listeners)
+	}
+}
+
+func TestParseProcAddress_Invalid(t *testing.T) {
+	for _, field := range []string{"0100007F", "0100007F:XYZ", "0100:0BB8", "ZZ00007F:0BB8"} {
+		if _, _, err := parseProcAddress(field); err == nil {
+			t.Errorf("parseProcAddress(%q) should fail", field)
+		}
+	}
+}
+
+// writeTestProcess adds a process to a fake /proc, holding fds linked to targets.
+func writeTestProcess(t *testing.T, root string, pid int, comm, cmdline, cwd string, targets ...string) {
+	t.Helper()
+	dir := filepath.Join(root, strconv.Itoa(pid))
+	writeTestFile(t, filepath.Join(dir, "comm"), comm+"\n")
+	writeTestFile(t, filepath.Join(dir, "cmdline"), cmdline)
+	if err := os.Symlink(cwd, filepath.Join(dir, "cwd")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "fd"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for i, target := range targets {
+		if err := os.Symlink(target, filepath.Join(dir, "fd", strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFindSocketOwners(t *testing.T) {
+	root := writeTestProc(t, procNetTCP, procNetTCP6)
+	writeTestProcess(t, root, 4242, "node", "node\x00/workspaces/app/node_modules/.bin/vite\x00--port\x005173\x00", "/workspaces/app",
+		"/dev/null", "pipe:[777]", "socket:[55555]")
+	writeTestProcess(t, root, 5151, "python3", "python3\x00-m\x00http.server\x008080\x00", "/tmp", "socket:[22222]")
+	// A process whose fds can't be read, like another user's, owns nothing
+	writeTestFile(t, filepath.Join(root, "6000", "comm"), "sshd\n")
+
+	owners := findSocketOwners(root, map[uint64]bool{55555: true, 22222: true, 11111: true})
+
+	want := map[uint64]processInfo{
+		55555: {PID: 4242, Process: "node", Command: "node /workspaces/app/node_modules/.bin/vite --port 5173", Cwd: "/workspaces/app"},
+		22222: {PID: 5151, Process: "python3", Command: "python3 -m http.server 8080", Cwd: "/tmp"},
+	}
+	if len(owners) != len(want) {
+		t.Fatalf("findSocketOwners() = %+v, want %+v", owners, want)
+	}
+	for inode, info := range want {
+		if owners[inode] != info {
+			t.Errorf("owner of %d = %+v, want %+v", inode, owners[inode], info)
+		}
+	}
+}
+
+func TestReadProcessInfo_TruncatesLongCommands(t *testing.T) {
+	root := t.TempDir()
+	writeTestProcess(t, root, 1, "java", "java\x00"+strings.Repeat("-Dprop=value\x00", 50), "/")
+
+	info := readProcessInfo(root, 1)
+	if len(info.Command) != maxCommandLength || !strings.HasSuffix(info.Command, "...") {
+		t.Errorf("Command = %q, want it truncated to %d characters", info.Command, maxCommandLength)
+	}
+}
+
+func TestPortWatcher_ReportsOwners(t *testing.T) {
+	var out bytes.Buffer
+	w := newPortWatcher(&out)
+	listeners := []listener{{Port: 5173, Protocol: "tcp", Inode: 55555}}
+	w.scan = func() ([]listener, error) { return listeners, nil }
+	lookups := 0
+	w.owners = func(inodes map[uint64]bool) map[uint64]processInfo {
+		lookups++
+		if !inodes[55555] {
+			t.Errorf("owners() inodes = %v, want 55555", inodes)
+		}
+		return map[uint64]processInfo{55555: {PID: 4242, Process: "node", Cwd: "/workspaces/app"}}
+	}
+
+	w.poll()
+	w.poll()
+	listeners = nil
+	w.poll()
+
+	events := decodeEvents(t, &out)
+	if len(events) != 2 {
+		t.Fatalf("events = %+v, want bound and unbound", events)
+	}
+	for _, event := range events {
+		if event.PID != 4242 || event.Process != "node" || event.Cwd != "/workspaces/app" {
+			t.Errorf("%s event = %+v, want the owning process", event.Action, event)
+		}
+	}
+	if lookups != 1 {
+		t.Errorf("owners() called %d times, want once for the new port", lookups)
+	}
+}
+
+// decodeEvents parses the JSON lines written by a portWatcher.
+func decodeEvents(t *testing.T, out *bytes.Buffer) []portMessage {
+	t.Helper()
+	var events []portMessage
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var msg portMessage
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			t.Fatalf("invalid event %q: %v", line, err)
+		}
+		events = append(events, msg)
+	}
+	out.Reset()
+	return events
+}
+
+func TestPortWatcher_Poll(t *testing.T) {
+	var out bytes.Buffer
+	w := newPortWatcher(&out)
+	w.now = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
+
+	scans := [][]listener{
+		{{Port: 8080, Protocol: "tcp"}, {Port: 22, Protocol: "tcp"}, {Port: 3000, Protocol: "tcp", Address: "127.0.0.1"}, {Port: 3000, Protocol: "tcp", Address: "::"}},
+		// The same port on another address isn't a new event
+		{{Port: 8080, Protocol: "tcp"}, {Port: 3000, Protocol: "tcp", Address: "127.0.0.1"}, {Port: 3000, Protocol: "tcp", Address: "::"}},
+		{{Port: 3000, Protocol: "tcp"}},
+	}
+	var scan int
+	w.scan = func() ([]listener, error) {
+		scan++
+		return scans[scan-1], nil
+	}
+
+	if err := w.poll(); err != nil {
+		t.Fatalf("poll() error = %v", err)
+	}
+	events := decodeEvents(t, &out)
+	want := []portMessage{
+		// The wildcard address wins over loopback for the same port
+		{Type: "port", Action: "bound", Port: 3000, Protocol: "tcp", Address: "::", Timestamp: "2024-05-01T12:00:00.000Z"},
+		{Type: "port", Action: "bound", Port: 8080, Protocol: "tcp", Timestamp: "2024-05-01T12:00:00.000Z"},
+	}
+	if len(events) != len(want) || events[0] != want[0] || events[1] != want[1] {
+		t.Fatalf("first poll events = %+v, want %+v", events, want)
+	}
+
+	w.poll()
+	if events := decodeEvents(t, &out); len(events) != 0 {
+		t.Errorf("unchanged poll events = %+v, want none", events)
+	}
+
+	w.poll()
+	events = decodeEvents(t, &out)
+	if len(events) != 1 || events[0].Action != "unbound" || events[0].Port != 8080 {
+		t.Errorf("third poll events = %+v, want 8080 unbound", events)
+	}
+}
+
+func TestPortWatcher_ScanFailureIsLogged(t *testing.T) {
+	var out bytes.Buffer
+	w := newPortWatcher(&out)
+	w.scan = func() ([]listener, error) { return nil, errors.New("boom") }
+
+	if err := w.poll(); err != nil {
+		t.Fatalf("poll() error = %v", err)
+	}
+	var msg logMessage
+	if err := json.Unmarshal(out.Bytes(), &msg); err != nil || msg.Type != "log" || !strings.Contains(msg.Message, "boom") {
+		t.Errorf("poll() output = %q, want a log message about the failure", out.String())
+	}
+}
+
+// failingWriter fails every write, like stdout after the SSH session has closed.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("broken pipe") }
+
+func TestPortWatcher_StopsWhenOutputCloses(t *testing.T) {
+	w := newPortWatcher(failingWriter{})
+	w.scan = func() ([]listener, error) { return []listener{{Port: 8080, Protocol: "tcp"}}, nil }
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := w.run(ctx, 10*time.Millisecond); err == nil || errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("run() error = %v, want the write error", err)
+	}
+}
+
+func TestRunWatchPorts_InvalidArguments(t *testing.T) {
+	for _, args := range [][]string{{"--interval"}, {"--interval", "soon"}, {"--interval", "-1s"}, {"--bogus"}} {
+		var stdout, stderr bytes.Buffer
+		if code := runWatchPorts(args, &stdout, &stderr); code != 1 {
+			t.Errorf("runWatchPorts(%v) = %d, want 1", args, code)
+		}
+	}
+}
+
+func TestScanSockDiagListeners(t *testing.T) {
+	if _, err := scanSockDiagListeners(); err != nil {
+		t.Skipf("sock_diag unavailable: %v", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+
+	listeners, err := scanSockDiagListeners()
+	if err != nil {
+		t.Fatalf("scanSockDiagListeners() error = %v", err)
+	}
+	for _, found := range listeners {
+		if found.Port == port && found.Address == "127.0.0.1" && found.Inode != 0 {
+			return
+		}
+	}
+	t.Errorf("scanSockDiagListeners() = %+v, want 127.0.0.1:%d", listeners, port)
+}
@@ -0,0 +1,532 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+)
+
+// shimTools maps the package tools the helper can stand in for to the function that
+// prepares their environment. gh ado-codespaces links ~/.gh-ado/shims/<tool> to the
+// helper, and its list of tools must match this map.
+var shimTools = map[string]shimPrepare{
+	"npm":    prepareNpm,
+	"npx":    prepareNpm,
+	"dotnet": prepareNuGet,
+	"nuget":  prepareNuGet,
+	"pip":    preparePip,
+	"pip3":   preparePip,
+	"twine":  prepareTwine,
+}
+
+// shimContext is what a shim needs to find the feeds a tool will use.
+type shimContext struct {
+	args   []string
+	dir    string
+	home   string
+	getenv func(string) string
+	// credential returns a username and password for an Azure Artifacts feed URL.
+	credential func(feedURL string) (*credentialResponse, error)
+}
+
+// shimPrepare returns extra environment variables that authenticate the Azure Artifacts
+// feeds the tool will use, and a cleanup function to run after the tool exits.
+type shimPrepare func(sc *shimContext) (env []string, cleanup func(), err error)
+
+// runShim runs the real tool after adding credentials for any Azure Artifacts feeds
+// it is configured to use. Credential failures are reported but don't stop the tool.
+func runShim(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "usage: ado-auth-helper shim <tool> [args]")
+		return 1
+	}
+	tool, toolArgs := args[0], args[1:]
+	prepare, ok := shimTools[tool]
+	if !ok {
+		fmt.Fprintf(stderr, "ado-auth-helper: no shim for %q\n", tool)
+		return 1
+	}
+
+	realPath, err := findRealTool(tool)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: command not found\n", tool)
+		return 127
+	}
+
+	home, _ := os.UserHomeDir()
+	dir, _ := os.Getwd()
+	sc := &shimContext{
+		args:       toolArgs,
+		dir:        dir,
+		home:       home,
+		getenv:     os.Getenv,
+		credential: feedCredentialCache(),
+	}
+
+	env, cleanup, err := prepare(sc)
+	if err != nil {
+		fmt.Fprintf(stderr, "ado-auth-helper: %s feed authentication unavailable: %v\n", tool, err)
+	}
+	if cleanup != nil {
+		defer cleanup()
+	}
+
+	cmd := exec.Command(realPath, toolArgs...)
+	cmd.Args[0] = tool
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, stdout, stderr
+	cmd.Env = append(os.Environ(), env...)
+	return runForwardingSignals(cmd, stderr)
+}
+
+// runForwardingSignals runs cmd, passing interrupts on to it so the shim only exits
+// (and cleans up) after the tool does, and returns the tool's exit code.
+func runForwardingSignals(cmd *exec.Cmd, stderr io.Writer) int {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	if err := cmd.Start(); err != nil {
+		return reportError(stderr, err)
+	}
+	go func() {
+		for sig := range signals {
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	err := cmd.Wait()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode()
+	default:
+		return reportError(stderr, err)
+	}
+}
+
+// findRealTool returns the first tool on PATH that isn't this helper.
+func findRealTool(tool string) (string, error) {
+	self, err := os.Executable()
+	if err == nil {
+		self, _ = filepath.EvalSymlinks(self)
+	}
+
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		candidate := filepath.Join(dir, tool)
+		info, err := os.Stat(candidate)
+		if err != nil || info.IsDir() || info.Mode()&0o111 == 0 {
+			continue
+		}
+		if resolved, err := filepath.EvalSymlinks(candidate); err == nil && resolved == self {
+			continue
+		}
+		return candidate, nil
+	}
+	return "", exec.ErrNotFound
+}
+
+// feedCredentialCache returns a credential lookup that asks the auth server once per
+// feed host and organization.
+func feedCredentialCache() func(string) (*credentialResponse, error) {
+	type result struct {
+		cred *credentialResponse
+		err  error
+	}
+	cache := make(map[string]result)
+	return func(feedURL string) (*credentialResponse, error) {
+		u, err := url.Parse(feedURL)
+		if err != nil {
+			return nil, err
+		}
+		organization := feedOrganization(u)
+		key := u.Host + "/" + organization
+		if r, ok := cache[key]; ok {
+			return r.cred, r.err
+		}
+		cred, err := getCredential(u.Host, organization)
+		cache[key] = result{cred, err}
+		return cred, err
+	}
+}
+
+// isFeedURL reports whether rawURL points at an Azure Artifacts feed.
+func isFeedURL(rawURL string) bool {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || u.Scheme != "https" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	return host == "pkgs.dev.azure.com" || strings.HasSuffix(host, ".pkgs.visualstudio.com")
+}
+
+// feedOrganization returns the Azure DevOps organization of a feed URL.
+func feedOrganization(u *url.URL) string {
+	host := strings.ToLower(u.Hostname())
+	if org, ok := strings.CutSuffix(host, ".pkgs.visualstudio.com"); ok {
+		return org
+	}
+	org, _, _ := strings.Cut(strings.Trim(u.Path, "/"), "/")
+	return org
+}
+
+// parentConfigFiles returns the paths of any of names in dir and each of its parents,
+// nearest first.
+func parentConfigFiles(dir string, names ...string) []string {
+	var paths []string
+	for {
+		for _, name := range names {
+			path := filepath.Join(dir, name)
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				paths = append(paths, path)
+				break
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return paths
+		}
+		dir = parent
+	}
+}
+
+// prepareNpm writes a temporary user config with an _authToken for each feed registry
+// found in .npmrc files or the environment, and points npm at it. The user's own
+// config is copied into it so its other settings still apply.
+func prepareNpm(sc *shimContext) ([]string, func(), error) {
+	userConfig := sc.getenv("NPM_CONFIG_USERCONFIG")
+	if userConfig == "" {
+		userConfig = filepath.Join(sc.home, ".npmrc")
+	}
+
+	var registries []string
+	if registry := sc.getenv("NPM_CONFIG_REGISTRY"); isFeedURL(registry) {
+		registries = append(registries, registry)
+	}
+	for _, path := range append(parentConfigFiles(sc.dir, ".npmrc"), userConfig) {
+		registries = append(registries, npmrcFeedRegistries(path)...)
+	}
+	if len(registries) == 0 {
+		return nil, nil, nil
+	}
+
+	existing, _ := os.ReadFile(userConfig)
+	var b strings.Builder
+	b.Write(existing)
+	b.WriteString("\n")
+	for _, registry := range registries {
+		cred, err := sc.credential(registry)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, prefix := range npmAuthPrefixes(registry) {
+			fmt.Fprintf(&b, "%s:_authToken=%s\n", prefix, cred.Password)
+		}
+	}
+
+	file, err := os.CreateTemp("", "gh-ado-npmrc-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() { os.Remove(file.Name()) }
+	_, err = file.WriteString(b.String())
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return []string{"NPM_CONFIG_USERCONFIG=" + file.Name()}, cleanup, nil
+}
+
+// npmrcFeedRegistries returns the feed URLs set as "registry" or "@scope:registry" in an .npmrc.
+func npmrcFeedRegistries(path string) []string {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var registries []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		if (key == "registry" || strings.HasSuffix(key, ":registry")) && isFeedURL(value) {
+			registries = append(registries, strings.TrimSpace(value))
+		}
+	}
+	return registries
+}
+
+// npmAuthPrefixes returns the npmrc key prefixes ("nerf darts") npm matches against a
+// registry: the registry itself and, as Azure Artifacts documents, the feed's npm path.
+func npmAuthPrefixes(registry string) []string {
+	u, err := url.Parse(registry)
+	if err != nil {
+		return nil
+	}
+	path := strings.TrimSuffix(u.Path, "/") + "/"
+	prefixes := []string{"//" + u.Host + path}
+	if parent, ok := strings.CutSuffix(path, "registry/"); ok {
+		prefixes = append(prefixes, "//"+u.Host+parent)
+	}
+	return prefixes
+}
+
+// nugetConfig is the part of a nuget.config that lists package sources.
+type nugetConfig struct {
+	Sources []struct {
+		Key   string `xml:"key,attr"`
+		Value string `xml:"value,attr"`
+	} `xml:"packageSources>add"`
+}
+
+// nugetSourceNamePattern matches source names NuGet can read credentials for from
+// NuGetPackageSourceCredentials_<name> environment variables.
+var nugetSourceNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// prepareNuGet passes credentials for feed package sources in nuget.config files
+// through NuGetPackageSourceCredentials_<name>, which NuGet reads without any plugin,
+// and through VSS_NUGET_EXTERNAL_FEED_ENDPOINTS for the Azure Artifacts Credential
+// Provider if it is installed.
+func prepareNuGet(sc *shimContext) ([]string, func(), error) {
+	configs := parentConfigFiles(sc.dir, "nuget.config", "NuGet.config", "NuGet.Config")
+	configs = append(configs, filepath.Join(sc.home, ".nuget", "NuGet", "NuGet.Config"))
+
+	type endpointCredential struct {
+		Endpoint string `json:"endpoint"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	var env []string
+	var endpoints []endpointCredential
+	seen := make(map[string]bool)
+	for _, path := range configs {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var config nugetConfig
+		if err := xml.Unmarshal(data, &config); err != nil {
+			continue
+		}
+		for _, source := range config.Sources {
+			if !isFeedURL(source.Value) || seen[source.Value] {
+				continue
+			}
+			seen[source.Value] = true
+
+			cred, err := sc.credential(source.Value)
+			if err != nil {
+				return nil, nil, err
+			}
+			endpoints = append(endpoints, endpointCredential{Endpoint: source.Value, Username: cred.Username, Password: cred.Password})
+			if nugetSourceNamePattern.MatchString(source.Key) {
+				env = append(env, fmt.Sprintf("NuGetPackageSourceCredentials_%s=Username=%s;Password=%s", source.Key, cred.Username, cred.Password))
+			}
+		}
+	}
+	if len(endpoints) == 0 {
+		return nil, nil, nil
+	}
+
+	external, err := json.Marshal(map[string]interface{}{"endpointCredentials": endpoints})
+	if err != nil {
+		return nil, nil, err
+	}
+	env = append(env, "VSS_NUGET_EXTERNAL_FEED_ENDPOINTS="+string(external))
+	return env, nil, nil
+}
+
+// preparePip adds credentials to feed index URLs from pip config files and the
+// environment, and passes the result back through PIP_INDEX_URL and
+// PIP_EXTRA_INDEX_URL, which take precedence over config files. Index URLs given on
+// the command line are left alone.
+func preparePip(sc *shimContext) ([]string, func(), error) {
+	var configs []string
+	if path := sc.getenv("PIP_CONFIG_FILE"); path != "" {
+		configs = append(configs, path)
+	}
+	configs = append(configs,
+		"/etc/pip.conf",
+		filepath.Join(sc.home, ".pip", "pip.conf"),
+		filepath.Join(sc.home, ".config", "pip", "pip.conf"),
+	)
+	if venv := sc.getenv("VIRTUAL_ENV"); venv != "" {
+		configs = append(configs, filepath.Join(venv, "pip.conf"))
+	}
+
+	var indexURL string
+	var extraURLs []string
+	for _, path := range configs {
+		settings := readPipConfig(path)
+		if value, ok := settings["index-url"]; ok {
+			indexURL = value
+		}
+		extraURLs = append(extraURLs, strings.Fields(settings["extra-index-url"])...)
+	}
+	if value := sc.getenv("PIP_INDEX_URL"); value != "" {
+		indexURL = value
+	}
+	if value := sc.getenv("PIP_EXTRA_INDEX_URL"); value != "" {
+		extraURLs = strings.Fields(value)
+	}
+
+	changed := false
+	withCredential := func(rawURL string) (string, error) {
+		if !isFeedURL(rawURL) {
+			return rawURL, nil
+		}
+		cred, err := sc.credential(rawURL)
+		if err != nil {
+			return "", err
+		}
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return "", err
+		}
+		u.User = url.UserPassword(cred.Username, cred.Password)
+		changed = true
+		return u.String(), nil
+	}
+
+	var env []string
+	if indexURL != "" {
+		authed, err := withCredential(indexURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		env = append(env, "PIP_INDEX_URL="+authed)
+	}
+	if len(extraURLs) > 0 {
+		authed := make([]string, 0, len(extraURLs))
+		for _, extra := range extraURLs {
+			a, err := withCredential(extra)
+			if err != nil {
+				return nil, nil, err
+			}
+			authed = append(authed, a)
+		}
+		env = append(env, "PIP_EXTRA_INDEX_URL="+strings.Join(authed, " "))
+	}
+	if !changed {
+		return nil, nil, nil
+	}
+	return env, nil, nil
+}
+
+// readPipConfig returns the index settings in a pip config file. Values continued on
+// indented lines are joined with spaces.
+func readPipConfig(path string) map[string]string {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	settings := make(map[string]string)
+	lastKey := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";"):
+			continue
+		case strings.HasPrefix(trimmed, "["):
+			lastKey = ""
+		case line[0] == ' ' || line[0] == '\t':
+			if lastKey != "" {
+				settings[lastKey] = strings.TrimSpace(settings[lastKey] + " " + trimmed)
+			}
+		default:
+			key, value, ok := strings.Cut(trimmed, "=")
+			if !ok {
+				lastKey = ""
+				continue
+			}
+			lastKey = strings.TrimSpace(key)
+			settings[lastKey] = strings.TrimSpace(value)
+		}
+	}
+	return settings
+}
+
+// prepareTwine sets TWINE_USERNAME and TWINE_PASSWORD when the upload goes to a feed
+// and no password was provided.
+func prepareTwine(sc *shimContext) ([]string, func(), error) {
+	if sc.getenv("TWINE_PASSWORD") != "" {
+		return nil, nil, nil
+	}
+
+	repositoryURL := sc.getenv("TWINE_REPOSITORY_URL")
+	repository := sc.getenv("TWINE_REPOSITORY")
+	for i := 0; i < len(sc.args); i++ {
+		arg := sc.args[i]
+		hasValue := i+1 < len(sc.args)
+		switch {
+		case (arg == "--repository-url") && hasValue:
+			i++
+			repositoryURL = sc.args[i]
+		case strings.HasPrefix(arg, "--repository-url="):
+			repositoryURL = strings.TrimPrefix(arg, "--repository-url=")
+		case (arg == "-r" || arg == "--repository") && hasValue:
+			i++
+			repository = sc.args[i]
+		case strings.HasPrefix(arg, "--repository="):
+			repository = strings.TrimPrefix(arg, "--repository=")
+		}
+	}
+	if repositoryURL == "" && repository != "" {
+		repositoryURL = pypircRepositoryURL(filepath.Join(sc.home, ".pypirc"), repository)
+	}
+	if !isFeedURL(repositoryURL) {
+		return nil, nil, nil
+	}
+
+	cred, err := sc.credential(repositoryURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	return []string{"TWINE_USERNAME=" + cred.Username, "TWINE_PASSWORD=" + cred.Password}, nil, nil
+}
+
+// pypircRepositoryURL returns the repository URL of a named section in a .pypirc file.
+func pypircRepositoryURL(path, name string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	inSection := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			inSection = line == "["+name+"]"
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok && inSection && strings.TrimSpace(key) == "repository" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// socketStatus is the status of one auth socket, as printed by 'status --json'.
+type socketStatus struct {
+	Socket string      `json:"socket"`
+	Status *statusInfo `json:"status,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// healthy reports whether the socket answered and its credential can get a token.
+func (s socketStatus) healthy() bool {
+	return s.Status != nil && s.Status.CanMintToken != nil && *s.Status.CanMintToken
+}
+
+// runStatus queries every auth socket. It exits 0 when at least one is healthy.
+func runStatus(args []string, stdout, stderr io.Writer) int {
+	asJSON := false
+	for _, arg := range args {
+		if arg == "--json" {
+			asJSON = true
+		}
+	}
+
+	sockets := findAuthSockets()
+	statuses := make([]socketStatus, 0, len(sockets))
+	for _, socketPath := range sockets {
+		status := socketStatus{Socket: socketPath}
+		if info, err := requestStatus(socketPath); err != nil {
+			status.Error = err.Error()
+		} else {
+			status.Status = info
+		}
+		statuses = append(statuses, status)
+	}
+
+	if asJSON {
+		b, err := json.MarshalIndent(statuses, "", "  ")
+		if err != nil {
+			return reportError(stderr, err)
+		}
+		fmt.Fprintln(stdout, string(b))
+	} else if len(statuses) == 0 {
+		fmt.Fprintf(stdout, "No auth sockets found matching %s; is gh ado-codespaces connected?\n", authSocketPattern)
+	} else {
+		for i, status := range statuses {
+			if i > 0 {
+				fmt.Fprintln(stdout)
+			}
+			printSocketStatus(stdout, status)
+		}
+	}
+
+	for _, status := range statuses {
+		if status.healthy() {
+			return 0
+		}
+	}
+	return 1
+}
+
+// printSocketStatus writes a human-readable summary of status.
+func printSocketStatus(w io.Writer, status socketStatus) {
+	fmt.Fprintln(w, status.Socket)
+	if status.Status == nil {
+		fmt.Fprintf(w, "  Status:        unreachable (%s)\n", status.Error)
+		return
+	}
+
+	info := status.Status
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(w, "  %-14s %s\n", name+":", value)
+		}
+	}
+	field("Version", info.Version)
+	field("Session", info.SessionID)
+	field("GitHub login", info.Login)
+	field("Subscription", info.Subscription)
+	field("Tenant", info.Tenant)
+
+	credentials := strings.Join(info.CredentialSources, " -> ")
+	if info.ActiveCredential != "" {
+		credentials += fmt.Sprintf(" (active: %s)", info.ActiveCredential)
+	}
+	field("Credentials", credentials)
+
+	switch {
+	case info.TokenError != nil:
+		field("Token", "unavailable: "+info.TokenError.Error())
+	case info.CanMintToken != nil && *info.CanMintToken:
+		field("Token", "ok")
+	default:
+		field("Token", "unknown")
+	}
+
+	if m := info.Metrics; m != nil {
+		total := 0
+		for _, n := range m.Requests {
+			total += n
+		}
+		field("Requests", fmt.Sprintf("%d (cache: %d hits, %d misses)", total, m.CacheHits, m.CacheMisses))
+		field("GetToken", formatLatency(m.GetToken))
+		field("Minting", formatLatency(m.Mint))
+		if len(m.Failures) > 0 {
+			codes := slices.Sorted(maps.Keys(m.Failures))
+			parts := make([]string, 0, len(codes))
+			for _, code := range codes {
+				parts = append(parts, fmt.Sprintf("%s: %d", code, m.Failures[code]))
+			}
+			field("Failures", strings.Join(parts, ", "))
+		}
+	}
+}
+
+// formatLatency renders latency stats, e.g. "12 calls, p50 3ms, p95 850ms".
+func formatLatency(stats latencyStats) string {
+	if stats.Count == 0 {
+		return "no calls"
+	}
+	return fmt.Sprintf("%d calls, p50 %gms, p95 %gms", stats.Count, stats.P50Ms, stats.P95Ms)
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/cli/go-gh/v2"
@@ -50,6 +51,15 @@ func fetchCodespaces(repoFilter, ownerFilter string) ([]Codespace, error) {
 	return codespaces, nil
 }
 
+// codespaceRepository returns the "owner/name" repository of a codespace.
+func codespaceRepository(codespaceName string) (string, error) {
+	stdout, stderr, err := gh.Exec("codespace", "view", "--codespace", codespaceName, "--json", "repository", "--jq", ".repository")
+	if err != nil {
+		return "", fmt.Errorf("error viewing codespace: %w\nStderr: %s", err, stderr.String())
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
 // ANSI color codes for base16 compatibility
 const (
 	colorReset     = "\033[0m"
@@ -14,18 +14,60 @@ const configEnvVar = "GH_ADO_CODESPACES_CONFIG"
 // AzureConfig captures Azure-specific overrides for an account.
 type AzureConfig struct {
 	Subscription string `json:"subscription"`
+	// Tenant is the Microsoft Entra tenant tokens are requested from.
+	Tenant string `json:"tenant,omitempty"`
+	// AdditionalTenants lists other tenants the credential may request tokens from ("*" for any).
+	AdditionalTenants []string `json:"additionalTenants,omitempty"`
+	// Credentials lists credential sources to try in order (e.g. "azureCLI", "deviceCode").
+	Credentials []string `json:"credentials,omitempty"`
+	// Organizations maps ADO organization names to overrides for repos in that organization.
+	Organizations map[string]AzureOrganizationConfig `json:"organizations,omitempty"`
+}
+
+// AzureOrganizationConfig overrides Azure settings for a single ADO organization.
+// Empty fields inherit the account-level values.
+type AzureOrganizationConfig struct {
+	Subscription string   `json:"subscription,omitempty"`
+	Tenant       string   `json:"tenant,omitempty"`
+	Credentials  []string `json:"credentials,omitempty"`
+}
+
+// isEmpty reports whether the Azure config carries no settings.
+func (a *AzureConfig) isEmpty() bool {
+	return a == nil || (strings.TrimSpace(a.Subscription) == "" &&
+		strings.TrimSpace(a.Tenant) == "" &&
+		len(a.AdditionalTenants) == 0 &&
+		len(a.Credentials) == 0 &&
+		len(a.Organizations) == 0)
 }
 
 // AccountConfig captures per-login configuration.
 type AccountConfig struct {
-	Azure              *AzureConfig         `json:"azure,omitempty"`
-	ReversePortForward []ReversePortForward `json:"reversePortForward,omitempty"`
+	Azure               *AzureConfig         `json:"azure,omitempty"`
+	ReversePortForward  []ReversePortForward `json:"reversePortForward,omitempty"`
+	Scopes              []ScopeRule          `json:"scopes,omitempty"`
+	CredentialProviders []CredentialProvider `json:"credentialProviders,omitempty"`
+	PortForwardRules    []PortForwardRule    `json:"portForwardRules,omitempty"`
+}
+
+// isEmpty reports whether the account carries no settings.
+func (a AccountConfig) isEmpty() bool {
+	return a.Azure.isEmpty() && len(a.ReversePortForward) == 0 && len(a.Scopes) == 0 &&
+		len(a.CredentialProviders) == 0 && len(a.PortForwardRules) == 0
 }
 
 // AppConfig captures global and per-login configuration.
 type AppConfig struct {
-	ReversePortForward []ReversePortForward     `json:"reversePortForward,omitempty"`
-	Accounts           map[string]AccountConfig `json:"accounts,omitempty"`
+	ReversePortForward  []ReversePortForward     `json:"reversePortForward,omitempty"`
+	Scopes              []ScopeRule              `json:"scopes,omitempty"`
+	CredentialProviders []CredentialProvider     `json:"credentialProviders,omitempty"`
+	PortForwardRules    []PortForwardRule        `json:"portForwardRules,omitempty"`
+	Accounts            map[string]AccountConfig `json:"accounts,omitempty"`
+	// Repositories maps "owner/name" (globs allowed, e.g. "owner/*") to per-repository settings.
+	Repositories map[string]RepositoryConfig `json:"repositories,omitempty"`
+	// LocalPortStrategy picks another local port when a forward's is in use: "offset"
+	// (the default), "next" or "random".
+	LocalPortStrategy string `json:"localPortStrategy,omitempty"`
 }
 
 // UnmarshalJSON supports both the current structured format and the legacy
@@ -37,20 +79,25 @@ func (c *AppConfig) UnmarshalJSON(data []byte) error {
 	}
 
 	// Use type-based detection to distinguish structured from legacy format.
-	// In structured format, "reversePortForward" must be a JSON array and
-	// "accounts" must be a JSON object. Any other top-level key, or wrong value
+	// In structured format, "reversePortForward", "scopes", "credentialProviders" and "portForwardRules"
+	// must be JSON arrays, "accounts" and "repositories" must be JSON objects and "localPortStrategy"
+	// must be a JSON string. Any other top-level key, or wrong value
 	// type for a known key, indicates a legacy login-keyed config.
 	isStructured := len(raw) > 0
 	for key, val := range raw {
 		switch key {
-		case "reversePortForward":
+		case "reversePortForward", "scopes", "credentialProviders", "portForwardRules":
 			if !jsonIsArray(val) {
 				isStructured = false
 			}
-		case "accounts":
+		case "accounts", "repositories":
 			if !jsonIsObject(val) {
 				isStructured = false
 			}
+		case "localPortStrategy":
+			if !jsonIsString(val) {
+				isStructured = false
+			}
 		default:
 			isStructured = false
 		}
@@ -144,6 +191,71 @@ func (c AppConfig) AzureSubscriptionForLogin(login string) (string, bool) {
 
 // SetAzureSubscriptionForLogin sets (or clears if empty) the Azure subscription for a given login.
 func (c *AppConfig) SetAzureSubscriptionForLogin(login, subscription string) {
+	c.updateAzureConfigForLogin(login, func(azure *AzureConfig) {
+		azure.Subscription = strings.TrimSpace(subscription)
+	})
+}
+
+// AzureTenantForLogin returns the Azure tenant override for a GitHub login, if present.
+func (c AppConfig) AzureTenantForLogin(login string) (string, bool) {
+	acct, ok := c.Accounts[login]
+	if !ok || acct.Azure == nil {
+		return "", false
+	}
+
+	tenant := strings.TrimSpace(acct.Azure.Tenant)
+	if tenant == "" {
+		return "", false
+	}
+
+	return tenant, true
+}
+
+// SetAzureTenantForLogin sets (or clears if empty) the Azure tenant for a given login.
+func (c *AppConfig) SetAzureTenantForLogin(login, tenant string) {
+	c.updateAzureConfigForLogin(login, func(azure *AzureConfig) {
+		azure.Tenant = strings.TrimSpace(tenant)
+	})
+}
+
+// AzureAdditionalTenantsForLogin returns the extra tenants a login's credential may use.
+func (c AppConfig) AzureAdditionalTenantsForLogin(login string) []string {
+	acct, ok := c.Accounts[login]
+	if !ok || acct.Azure == nil {
+		return nil
+	}
+
+	var tenants []string
+	for _, tenant := range acct.Azure.AdditionalTenants {
+		if tenant = strings.TrimSpace(tenant); tenant != "" {
+			tenants = append(tenants, tenant)
+		}
+	}
+	return tenants
+}
+
+// AzureOrganizationsForLogin returns the per-organization overrides for a login,
+// keyed by lowercase organization name since ADO organization names are case-insensitive.
+func (c AppConfig) AzureOrganizationsForLogin(login string) map[string]AzureOrganizationConfig {
+	acct, ok := c.Accounts[login]
+	if !ok || acct.Azure == nil || len(acct.Azure.Organizations) == 0 {
+		return nil
+	}
+
+	orgs := make(map[string]AzureOrganizationConfig, len(acct.Azure.Organizations))
+	for name, org := range acct.Azure.Organizations {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		orgs[name] = org
+	}
+	return orgs
+}
+
+// updateAzureConfigForLogin applies update to a login's Azure config, creating it
+// as needed and pruning the Azure config and account entry when they end up empty.
+func (c *AppConfig) updateAzureConfigForLogin(login string, update func(*AzureConfig)) {
 	if c == nil {
 		return
 	}
@@ -154,28 +266,52 @@ func (c *AppConfig) SetAzureSubscriptionForLogin(login, subscription string) {
 	if login == "" {
 		return
 	}
-	sub := strings.TrimSpace(subscription)
-	if sub == "" {
-		// Clear existing if present
-		if acct, ok := c.Accounts[login]; ok {
-			acct.Azure = nil
-			// If AccountConfig is now empty, remove the login entry entirely
-			if acct.Azure == nil && len(acct.ReversePortForward) == 0 {
-				delete(c.Accounts, login)
-			} else {
-				c.Accounts[login] = acct
-			}
+
+	acct, exists := c.Accounts[login] // zero value if not exists
+	azure := AzureConfig{}
+	if acct.Azure != nil {
+		azure = *acct.Azure
+	}
+	update(&azure)
+
+	if azure.isEmpty() {
+		if !exists {
+			return
+		}
+		acct.Azure = nil
+		// If AccountConfig is now empty, remove the login entry entirely
+		if acct.isEmpty() {
+			delete(c.Accounts, login)
+		} else {
+			c.Accounts[login] = acct
 		}
 		return
 	}
-	acct := c.Accounts[login] // zero value if not exists
-	if acct.Azure == nil {
-		acct.Azure = &AzureConfig{}
-	}
-	acct.Azure.Subscription = sub
+
+	acct.Azure = &azure
 	c.Accounts[login] = acct
 }
 
+// AzureCredentialSourcesForLogin returns the ordered credential sources configured for a login,
+// falling back to the default chain when none are set.
+func (c AppConfig) AzureCredentialSourcesForLogin(login string) []string {
+	acct, ok := c.Accounts[login]
+	if !ok || acct.Azure == nil || len(acct.Azure.Credentials) == 0 {
+		return defaultCredentialSources
+	}
+
+	var sources []string
+	for _, source := range acct.Azure.Credentials {
+		if source = strings.TrimSpace(source); source != "" {
+			sources = append(sources, source)
+		}
+	}
+	if len(sources) == 0 {
+		return defaultCredentialSources
+	}
+	return sources
+}
+
 // ReversePortForwardsForLogin returns defaults merged with top-level and per-login overrides.
 func (c AppConfig) ReversePortForwardsForLogin(login string) []ReversePortForward {
 	accountForwards := []ReversePortForward(nil)
@@ -186,6 +322,41 @@ func (c AppConfig) ReversePortForwardsForLogin(login string) []ReversePortForwar
 	return MergeReversePortForwards(WellKnownPorts, c.ReversePortForward, accountForwards)
 }
 
+// ScopeRulesForLogin returns the default scope rules merged with top-level and per-login overrides.
+func (c AppConfig) ScopeRulesForLogin(login string) []ScopeRule {
+	accountRules := []ScopeRule(nil)
+	if acct, ok := c.Accounts[login]; ok {
+		accountRules = acct.Scopes
+	}
+
+	return MergeScopeRules(DefaultScopeRules, c.Scopes, accountRules)
+}
+
+// CredentialProvidersForLogin returns the default credential providers merged with
+// top-level and per-login overrides.
+func (c AppConfig) CredentialProvidersForLogin(login string) []CredentialProvider {
+	accountProviders := []CredentialProvider(nil)
+	if acct, ok := c.Accounts[login]; ok {
+		accountProviders = acct.CredentialProviders
+	}
+
+	return MergeCredentialProviders(DefaultCredentialProviders, c.CredentialProviders, accountProviders)
+}
+
+// PortForwardRulesForLogin returns the top-level port forward rules followed by the
+// per-login rules and those of the codespace's repository, so the most specific rule
+// that matches a port wins.
+func (c AppConfig) PortForwardRulesForLogin(login, repository string) []PortForwardRule {
+	accountRules := []PortForwardRule(nil)
+	if acct, ok := c.Accounts[login]; ok {
+		accountRules = acct.PortForwardRules
+	}
+
+	lists := [][]PortForwardRule{c.PortForwardRules, accountRules}
+	lists = append(lists, repositoryPortForwardRules(c.Repositories, repository)...)
+	return MergePortForwardRules(lists...)
+}
+
 // SaveAppConfig persists the configuration to disk, creating directories as needed.
 func SaveAppConfig(cfg AppConfig) error {
 	path, err := getConfigFilePath()
@@ -235,3 +406,14 @@ func jsonIsObject(data json.RawMessage) bool {
 	}
 	return false
 }
+
+// jsonIsString reports whether raw JSON data represents a string ("...").
+func jsonIsString(data json.RawMessage) bool {
+	for _, b := range data {
+		if b == ' ' || b == '\t' || b == '\n' || b == '\r' {
+			continue
+		}
+		return b == '"'
+	}
+	return false
+}
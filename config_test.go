@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -84,6 +85,18 @@ func TestAppConfig_SetAzureSubscriptionForLogin(t *testing.T) {
 		}
 	})
 
+	t.Run("clear subscription preserves credential sources", func(t *testing.T) {
+		cfg := AppConfig{Accounts: map[string]AccountConfig{
+			"user1": {Azure: &AzureConfig{Subscription: "sub123", Credentials: []string{"deviceCode"}}},
+		}}
+
+		cfg.SetAzureSubscriptionForLogin("user1", "")
+		acct := cfg.Accounts["user1"]
+		if acct.Azure == nil || acct.Azure.Subscription != "" || len(acct.Azure.Credentials) != 1 {
+			t.Fatalf("expected only the subscription to be cleared, got %+v", acct.Azure)
+		}
+	})
+
 	t.Run("clear subscription preserves reverse port config", func(t *testing.T) {
 		cfg := AppConfig{Accounts: map[string]AccountConfig{
 			"user1": {
@@ -108,6 +121,98 @@ func TestAppConfig_SetAzureSubscriptionForLogin(t *testing.T) {
 	})
 }
 
+func TestAppConfig_AzureTenantForLogin(t *testing.T) {
+	t.Run("set and clear tenant", func(t *testing.T) {
+		cfg := AppConfig{}
+
+		cfg.SetAzureTenantForLogin("user1", " tenant123 ")
+		tenant, ok := cfg.AzureTenantForLogin("user1")
+		if !ok || tenant != "tenant123" {
+			t.Fatalf("expected stored tenant, got tenant=%q ok=%v", tenant, ok)
+		}
+
+		cfg.SetAzureTenantForLogin("user1", "")
+		if _, ok := cfg.AzureTenantForLogin("user1"); ok {
+			t.Fatal("expected tenant to be cleared")
+		}
+		if _, ok := cfg.Accounts["user1"]; ok {
+			t.Fatal("expected empty account to be removed")
+		}
+	})
+
+	t.Run("tenant and subscription are independent", func(t *testing.T) {
+		cfg := AppConfig{}
+		cfg.SetAzureSubscriptionForLogin("user1", "sub123")
+		cfg.SetAzureTenantForLogin("user1", "tenant123")
+		cfg.SetAzureSubscriptionForLogin("user1", "")
+
+		if tenant, ok := cfg.AzureTenantForLogin("user1"); !ok || tenant != "tenant123" {
+			t.Fatalf("expected tenant to survive clearing subscription, got tenant=%q ok=%v", tenant, ok)
+		}
+	})
+
+	t.Run("clearing a missing login is a no-op", func(t *testing.T) {
+		cfg := AppConfig{}
+		cfg.SetAzureTenantForLogin("user1", "")
+		if len(cfg.Accounts) != 0 {
+			t.Fatalf("expected no accounts, got %+v", cfg.Accounts)
+		}
+	})
+}
+
+func TestAppConfig_AzureAdditionalTenantsForLogin(t *testing.T) {
+	cfg := AppConfig{Accounts: map[string]AccountConfig{
+		"user1": {Azure: &AzureConfig{AdditionalTenants: []string{"tenant-a", " ", "*"}}},
+	}}
+
+	got := cfg.AzureAdditionalTenantsForLogin("user1")
+	if len(got) != 2 || got[0] != "tenant-a" || got[1] != "*" {
+		t.Errorf("expected trimmed additional tenants, got %v", got)
+	}
+	if got := cfg.AzureAdditionalTenantsForLogin("missing"); got != nil {
+		t.Errorf("expected nil for missing login, got %v", got)
+	}
+}
+
+func TestAppConfig_AzureOrganizationsForLogin(t *testing.T) {
+	cfg := AppConfig{Accounts: map[string]AccountConfig{
+		"user1": {Azure: &AzureConfig{Organizations: map[string]AzureOrganizationConfig{
+			"Contoso": {Tenant: "contoso-tenant"},
+			" ":       {Tenant: "ignored"},
+		}}},
+	}}
+
+	orgs := cfg.AzureOrganizationsForLogin("user1")
+	if len(orgs) != 1 {
+		t.Fatalf("expected one organization, got %+v", orgs)
+	}
+	if got := orgs["contoso"].Tenant; got != "contoso-tenant" {
+		t.Errorf("expected lowercase organization key with tenant, got %q", got)
+	}
+	if got := cfg.AzureOrganizationsForLogin("missing"); got != nil {
+		t.Errorf("expected nil for missing login, got %+v", got)
+	}
+}
+
+func TestAppConfig_AzureCredentialSourcesForLogin(t *testing.T) {
+	cfg := AppConfig{Accounts: map[string]AccountConfig{
+		"user1": {Azure: &AzureConfig{Credentials: []string{"azureDeveloperCLI", " ", "deviceCode"}}},
+		"user2": {Azure: &AzureConfig{Subscription: "sub123"}},
+	}}
+
+	got := cfg.AzureCredentialSourcesForLogin("user1")
+	if len(got) != 2 || got[0] != "azureDeveloperCLI" || got[1] != "deviceCode" {
+		t.Errorf("expected configured sources in order, got %v", got)
+	}
+
+	for _, login := range []string{"user2", "missing"} {
+		got := cfg.AzureCredentialSourcesForLogin(login)
+		if len(got) != 1 || got[0] != credentialSourceAzureCLI {
+			t.Errorf("expected default sources for %q, got %v", login, got)
+		}
+	}
+}
+
 func TestAppConfig_ReversePortForwardsForLogin(t *testing.T) {
 	original := WellKnownPorts
 	defer func() { WellKnownPorts = original }()
@@ -150,6 +255,112 @@ func TestAppConfig_ReversePortForwardsForLogin(t *testing.T) {
 	}
 }
 
+func TestAppConfig_ScopeRulesForLogin(t *testing.T) {
+	cfg := AppConfig{
+		Scopes: []ScopeRule{{Scope: "https://vault.azure.net/*", Policy: "allow"}},
+		Accounts: map[string]AccountConfig{
+			"user1": {Scopes: []ScopeRule{{Scope: "https://vault.azure.net/*", Policy: "deny"}}},
+		},
+	}
+
+	policies := func(rules []ScopeRule) map[string]string {
+		byScope := make(map[string]string)
+		for _, rule := range rules {
+			byScope[rule.Scope] = rule.Policy
+		}
+		return byScope
+	}
+
+	user1 := policies(cfg.ScopeRulesForLogin("user1"))
+	if user1[DefaultScopeRules[0].Scope] != scopePolicyAllow {
+		t.Errorf("expected default ADO rule to be kept, got %v", user1)
+	}
+	if user1["https://vault.azure.net/*"] != scopePolicyDeny {
+		t.Errorf("expected account rule to override top-level rule, got %v", user1)
+	}
+
+	other := policies(cfg.ScopeRulesForLogin("other"))
+	if other["https://vault.azure.net/*"] != scopePolicyAllow {
+		t.Errorf("expected top-level rule for other login, got %v", other)
+	}
+}
+
+func TestAppConfig_CredentialProvidersForLogin(t *testing.T) {
+	var cfg AppConfig
+	data := `{
+		"credentialProviders": [{"host": "contoso.azurecr.io", "scope": "https://containerregistry.azure.net/.default"}],
+		"accounts": {
+			"user1": {"credentialProviders": [{"host": "pkgs.dev.azure.com", "username": "build"}]}
+		}
+	}`
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("failed to unmarshal config: %v", err)
+	}
+	if len(cfg.CredentialProviders) != 1 {
+		t.Fatalf("expected structured config with a top-level provider, got %+v", cfg)
+	}
+
+	byHost := func(providers []CredentialProvider) map[string]CredentialProvider {
+		hosts := make(map[string]CredentialProvider)
+		for _, provider := range providers {
+			hosts[provider.Host] = provider
+		}
+		return hosts
+	}
+
+	user1 := byHost(cfg.CredentialProvidersForLogin("user1"))
+	if user1["pkgs.dev.azure.com"].Username != "build" {
+		t.Errorf("expected account provider to override the default, got %+v", user1["pkgs.dev.azure.com"])
+	}
+	if _, ok := user1["dev.azure.com"]; !ok {
+		t.Error("expected default dev.azure.com provider to be kept")
+	}
+	if user1["contoso.azurecr.io"].Scope != "https://containerregistry.azure.net/.default" {
+		t.Errorf("expected top-level provider, got %+v", user1["contoso.azurecr.io"])
+	}
+
+	other := byHost(cfg.CredentialProvidersForLogin("other"))
+	if other["pkgs.dev.azure.com"].Username != "VssSessionToken" {
+		t.Errorf("expected default provider for other login, got %+v", other["pkgs.dev.azure.com"])
+	}
+}
+
+func TestAppConfig_PortForwardRulesForLogin(t *testing.T) {
+	var cfg AppConfig
+	data := `{
+		"portForwardRules": [{"ports": "9229", "action": "skip"}],
+		"accounts": {
+			"user1": {"portForwardRules": [{"process": "*-language-server", "action": "skip"}]}
+		},
+		"repositories": {
+			"Contoso/*": {"portForwardRules": [{"ports": "3000", "localPort": 13000}]},
+			"contoso/web": {"portForwardRules": [{"ports": "9229"}]}
+		}
+	}`
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("failed to unmarshal config: %v", err)
+	}
+	if len(cfg.PortForwardRules) != 1 || len(cfg.Repositories) != 2 {
+		t.Fatalf("expected structured config with rules and repositories, got %+v", cfg)
+	}
+
+	rules := cfg.PortForwardRulesForLogin("user1", "contoso/WEB")
+	want := []PortForwardRule{
+		{Ports: "9229", Action: portActionSkip},
+		{Process: "*-language-server", Action: portActionSkip},
+		{Ports: "3000", Action: portActionForward, LocalPort: 13000},
+		{Ports: "9229", Action: portActionForward},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("PortForwardRulesForLogin() = %+v, want %+v", rules, want)
+	}
+
+	other := cfg.PortForwardRulesForLogin("other", "fabrikam/api")
+	if len(other) != 1 || other[0].Ports != "9229" {
+		t.Errorf("expected only the top-level rule for another login and repository, got %+v", other)
+	}
+}
+
 func TestLoadAppConfig(t *testing.T) {
 	tempDir := t.TempDir()
 
@@ -201,6 +412,28 @@ func TestLoadAppConfig(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:       "structured config with only scopes",
+			configPath: filepath.Join(tempDir, "scopes.json"),
+			configData: `{
+"scopes": [{"scope": "https://vault.azure.net/*", "policy": "allow"}]
+}`,
+			expected: AppConfig{
+				Scopes: []ScopeRule{{Scope: "https://vault.azure.net/*", Policy: "allow"}},
+			},
+		},
+		{
+			name:       "structured config with a local port strategy",
+			configPath: filepath.Join(tempDir, "strategy.json"),
+			configData: `{
+"localPortStrategy": "next",
+"portForwardRules": [{"ports": "9229", "action": "skip"}]
+}`,
+			expected: AppConfig{
+				PortForwardRules:  []PortForwardRule{{Ports: "9229", Action: "skip"}},
+				LocalPortStrategy: "next",
+			},
+		},
 		{
 			name:       "valid legacy account keyed config",
 			configPath: filepath.Join(tempDir, "legacy.json"),
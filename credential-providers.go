@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// Credential provider types accepted in CredentialProvider.Type.
+const (
+	// providerTypeBearer uses the access token itself as the password.
+	providerTypeBearer = "bearer"
+	// providerTypeACR exchanges the access token for an Azure Container Registry refresh token.
+	providerTypeACR = "acr"
+)
+
+// CredentialProvider tells the auth server how to answer git credential requests for
+// hosts matching Host, which may contain '*' wildcards.
+type CredentialProvider struct {
+	Host string `json:"host"`
+	// Type selects how the access token becomes a credential; defaults to "bearer".
+	Type string `json:"type,omitempty"`
+	// Scope is the token scope to request; defaults to the ACR scope for "acr" providers
+	// and to the Azure DevOps scope otherwise.
+	Scope string `json:"scope,omitempty"`
+	// Username is returned alongside the password; defaults to "token". ACR providers
+	// always use the username ACR requires for refresh tokens.
+	Username string `json:"username,omitempty"`
+	// Organization picks the tenant and credential for hosts that aren't tied to an
+	// Azure DevOps organization, as in azure.organizations.
+	Organization string `json:"organization,omitempty"`
+}
+
+// DefaultCredentialProviders answers for Azure DevOps git remotes, Azure Artifacts
+// feeds and Azure Container Registries.
+var DefaultCredentialProviders = []CredentialProvider{
+	{Host: "dev.azure.com"},
+	{Host: "*.visualstudio.com"},
+	{Host: "pkgs.dev.azure.com", Username: "VssSessionToken"},
+	{Host: "*.pkgs.visualstudio.com", Username: "VssSessionToken"},
+	{Host: "*.azurecr.io", Type: providerTypeACR},
+}
+
+// gitCredential is the username and password returned for a host.
+type gitCredential struct {
+	Username string
+	Password string
+}
+
+// credentialExchange turns an access token into a credential for host.
+type credentialExchange func(ctx context.Context, provider CredentialProvider, host string, token azcore.AccessToken) (gitCredential, error)
+
+// credentialProviderTypes maps provider types to their exchange.
+var credentialProviderTypes = map[string]credentialExchange{
+	providerTypeBearer: bearerCredential,
+	providerTypeACR:    acrCredential,
+}
+
+// bearerCredential returns the access token as the password.
+func bearerCredential(_ context.Context, provider CredentialProvider, _ string, token azcore.AccessToken) (gitCredential, error) {
+	return gitCredential{Username: provider.Username, Password: token.Token}, nil
+}
+
+// MergeCredentialProviders merges provider lists by host pattern. Later lists override
+// earlier entries for the same pattern. Defaults are filled in for the type, scope and
+// username, and entries with an empty host or unknown type are skipped with a warning.
+func MergeCredentialProviders(lists ...[]CredentialProvider) []CredentialProvider {
+	mergedByHost := make(map[string]CredentialProvider)
+	var order []string
+
+	for _, providers := range lists {
+		for _, provider := range providers {
+			provider.Host = strings.ToLower(strings.TrimSpace(provider.Host))
+			provider.Type = strings.ToLower(strings.TrimSpace(provider.Type))
+			if provider.Type == "" {
+				provider.Type = providerTypeBearer
+			}
+			if _, ok := credentialProviderTypes[provider.Type]; provider.Host == "" || !ok {
+				fmt.Fprintf(os.Stderr, "Warning: skipping credential provider with invalid host %q or type %q\n", provider.Host, provider.Type)
+				continue
+			}
+			if provider.Scope == "" && provider.Type == providerTypeACR {
+				provider.Scope = acrScope
+			} else if provider.Scope == "" {
+				provider.Scope = defaultADOScope
+			}
+			if provider.Type == providerTypeACR {
+				provider.Username = acrRefreshTokenUsername
+			} else if provider.Username == "" {
+				provider.Username = "token"
+			}
+			if _, exists := mergedByHost[provider.Host]; !exists {
+				order = append(order, provider.Host)
+			}
+			mergedByHost[provider.Host] = provider
+		}
+	}
+
+	merged := make([]CredentialProvider, 0, len(order))
+	for _, host := range order {
+		merged = append(merged, mergedByHost[host])
+	}
+
+	return merged
+}
+
+// providerRegistry finds the credential provider for a host.
+type providerRegistry struct {
+	providers []CredentialProvider
+	patterns  []*regexp.Regexp
+}
+
+// newProviderRegistry compiles providers into a registry.
+func newProviderRegistry(providers []CredentialProvider) *providerRegistry {
+	registry := &providerRegistry{providers: providers}
+	for _, provider := range providers {
+		registry.patterns = append(registry.patterns, compileScopePattern(provider.Host))
+	}
+	return registry
+}
+
+// lookup returns the provider for host, ignoring any port. Exact matches win over
+// wildcard patterns; among patterns the last match wins. Hosts that parseCredentialHost
+// rejects have no provider. A nil registry applies DefaultCredentialProviders.
+func (r *providerRegistry) lookup(host string) (CredentialProvider, bool) {
+	if r == nil {
+		r = newProviderRegistry(MergeCredentialProviders(DefaultCredentialProviders))
+	}
+
+	host, _, err := parseCredentialHost(host)
+	if err != nil {
+		return CredentialProvider{}, false
+	}
+	for _, provider := range r.providers {
+		if provider.Host == host {
+			return provider, true
+		}
+	}
+
+	var match CredentialProvider
+	found := false
+	for i, pattern := range r.patterns {
+		if pattern.MatchString(host) {
+			match, found = r.providers[i], true
+		}
+	}
+	return match, found
+}
+
+// parseCredentialHost checks that host is a DNS name with an optional port, so that
+// wildcard patterns, which match any character, can't match a host that carries a
+// path, user info or a second host. It returns the lowercased name and the host with
+// its port.
+func parseCredentialHost(host string) (string, string, error) {
+	host = strings.ToLower(strings.TrimSpace(host))
+	name, port := host, ""
+	if strings.Contains(host, ":") {
+		var err error
+		if name, port, err = net.SplitHostPort(host); err != nil {
+			return "", "", fmt.Errorf("invalid host %q", host)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 || strconv.Itoa(n) != port {
+			return "", "", fmt.Errorf("invalid port in host %q", host)
+		}
+	}
+	if !isDNSName(name) {
+		return "", "", fmt.Errorf("invalid host %q", host)
+	}
+	if port != "" {
+		return name, net.JoinHostPort(name, port), nil
+	}
+	return name, name, nil
+}
+
+// isDNSName reports whether name is made of dot-separated labels of letters, digits
+// and inner hyphens.
+func isDNSName(name string) bool {
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
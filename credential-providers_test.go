@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+func TestMergeCredentialProviders(t *testing.T) {
+	merged := MergeCredentialProviders(
+		DefaultCredentialProviders,
+		[]CredentialProvider{
+			{Host: " Contoso.AzureCR.io ", Scope: "https://containerregistry.azure.net/.default"},
+			{Host: "", Username: "ignored"},
+			{Host: "bad.example.com", Type: "unknown"},
+		},
+		[]CredentialProvider{{Host: "dev.azure.com", Username: "build"}},
+	)
+
+	byHost := make(map[string]CredentialProvider)
+	for _, provider := range merged {
+		byHost[provider.Host] = provider
+	}
+
+	if len(merged) != len(DefaultCredentialProviders)+1 {
+		t.Fatalf("expected defaults plus one custom provider, got %+v", merged)
+	}
+	if got := byHost["dev.azure.com"]; got.Username != "build" || got.Scope != defaultADOScope || got.Type != providerTypeBearer {
+		t.Errorf("expected later list to override dev.azure.com with defaults filled in, got %+v", got)
+	}
+	if got := byHost["contoso.azurecr.io"]; got.Username != "token" || got.Scope != "https://containerregistry.azure.net/.default" {
+		t.Errorf("expected normalized custom provider, got %+v", got)
+	}
+	if _, ok := byHost["bad.example.com"]; ok {
+		t.Error("expected provider with unknown type to be skipped")
+	}
+}
+
+func TestProviderRegistry_Lookup(t *testing.T) {
+	registry := newProviderRegistry(MergeCredentialProviders(DefaultCredentialProviders, []CredentialProvider{
+		{Host: "*.example.com", Username: "wildcard"},
+		{Host: "git.example.com", Username: "exact"},
+	}))
+
+	tests := []struct {
+		host         string
+		wantUsername string
+		wantOK       bool
+	}{
+		{host: "dev.azure.com", wantUsername: "token", wantOK: true},
+		{host: "DEV.AZURE.COM:443", wantUsername: "token", wantOK: true},
+		{host: "contoso.visualstudio.com", wantUsername: "token", wantOK: true},
+		{host: "contoso.pkgs.visualstudio.com", wantUsername: "VssSessionToken", wantOK: true},
+		{host: "pkgs.dev.azure.com", wantUsername: "VssSessionToken", wantOK: true},
+		{host: "git.example.com", wantUsername: "exact", wantOK: true},
+		{host: "other.example.com", wantUsername: "wildcard", wantOK: true},
+		{host: "github.com", wantOK: false},
+		{host: "contoso.azurecr.io:1@evil.com", wantOK: false},
+		{host: "evil.com/.azurecr.io", wantOK: false},
+		{host: "evil.com#.azurecr.io", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			provider, ok := registry.lookup(tt.host)
+			if ok != tt.wantOK || provider.Username != tt.wantUsername {
+				t.Errorf("lookup(%q) = (%+v, %v), want username %q, ok %v", tt.host, provider, ok, tt.wantUsername, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestParseCredentialHost(t *testing.T) {
+	tests := []struct {
+		host     string
+		wantName string
+		wantHost string
+		wantErr  bool
+	}{
+		{host: "Contoso.AzureCR.io", wantName: "contoso.azurecr.io", wantHost: "contoso.azurecr.io"},
+		{host: "dev.azure.com:443", wantName: "dev.azure.com", wantHost: "dev.azure.com:443"},
+		{host: "127.0.0.1:5000", wantName: "127.0.0.1", wantHost: "127.0.0.1:5000"},
+		{host: "", wantErr: true},
+		{host: "x.azurecr.io:1@evil.com", wantErr: true},
+		{host: "user@x.azurecr.io", wantErr: true},
+		{host: "evil.com/.azurecr.io", wantErr: true},
+		{host: "x.azurecr.io:", wantErr: true},
+		{host: "x.azurecr.io:99999", wantErr: true},
+		{host: "x.azurecr.io:0443", wantErr: true},
+		{host: "-x.azurecr.io", wantErr: true},
+		{host: "x..azurecr.io", wantErr: true},
+		{host: "[::1]:443", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			name, host, err := parseCredentialHost(tt.host)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCredentialHost(%q) error = %v, wantErr %v", tt.host, err, tt.wantErr)
+			}
+			if name != tt.wantName || host != tt.wantHost {
+				t.Errorf("parseCredentialHost(%q) = %q, %q, want %q, %q", tt.host, name, host, tt.wantName, tt.wantHost)
+			}
+		})
+	}
+}
+
+func TestProviderRegistry_NilUsesDefaults(t *testing.T) {
+	var registry *providerRegistry
+	if _, ok := registry.lookup("dev.azure.com"); !ok {
+		t.Error("expected nil registry to answer for dev.azure.com")
+	}
+}
+
+func TestBearerCredential(t *testing.T) {
+	token := azcore.AccessToken{Token: "access-token", ExpiresOn: time.Now().Add(time.Hour)}
+	cred, err := bearerCredential(context.Background(), CredentialProvider{Username: "VssSessionToken"}, "pkgs.dev.azure.com", token)
+	if err != nil {
+		t.Fatalf("bearerCredential() error = %v", err)
+	}
+	if cred.Username != "VssSessionToken" || cred.Password != "access-token" {
+		t.Errorf("unexpected credential %+v", cred)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// Credential source names accepted in the "credentials" list of an account's Azure config.
+const (
+	credentialSourceAzureCLI          = "azureCLI"
+	credentialSourceAzureDeveloperCLI = "azureDeveloperCLI"
+	credentialSourceEnvironment       = "environment"
+	credentialSourceManagedIdentity   = "managedIdentity"
+	credentialSourceDeviceCode        = "deviceCode"
+)
+
+// defaultCredentialSources is used when an account doesn't configure its own chain.
+var defaultCredentialSources = []string{credentialSourceAzureCLI}
+
+// credentialOptions describes how to build the credential chain for the auth server.
+type credentialOptions struct {
+	Subscription      string
+	TenantID          string
+	AdditionalTenants []string
+	Sources           []string
+	// Metrics, if set, counts cache hits and minting for the chain's token cache.
+	Metrics *tokenMetrics
+}
+
+// namedCredential pairs a credential with the source name used in config and logs.
+type namedCredential struct {
+	name string
+	cred azcore.TokenCredential
+}
+
+// credentialChain tries each credential source in order and remembers which one last succeeded.
+type credentialChain struct {
+	sources []namedCredential
+	mu      sync.Mutex
+	active  string
+}
+
+// newCredentialChain builds the ordered credential chain described by opts.
+// Sources that are unknown or can't be constructed are skipped; an error is
+// returned only when no usable source remains.
+func newCredentialChain(opts credentialOptions) (*credentialChain, error) {
+	sources := opts.Sources
+	if len(sources) == 0 {
+		sources = defaultCredentialSources
+	}
+
+	chain := &credentialChain{}
+	var errs []error
+	for _, name := range sources {
+		name = strings.TrimSpace(name)
+		cred, err := newCredentialForSource(name, opts)
+		if err != nil {
+			logAuthMessage("Skipping credential source '%s': %v", name, err)
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		logAuthMessage("Added credential source '%s' to chain", name)
+		chain.sources = append(chain.sources, namedCredential{name: name, cred: cred})
+	}
+
+	if len(chain.sources) == 0 {
+		return nil, fmt.Errorf("no usable credential sources: %w", errors.Join(errs...))
+	}
+
+	return chain, nil
+}
+
+// newCredentialForSource constructs the credential for a single named source.
+func newCredentialForSource(name string, opts credentialOptions) (azcore.TokenCredential, error) {
+	switch name {
+	case credentialSourceAzureCLI:
+		if opts.Subscription != "" {
+			logAuthMessage("Creating Azure CLI credential with subscription override %s", opts.Subscription)
+		}
+		return azidentity.NewAzureCLICredential(&azidentity.AzureCLICredentialOptions{
+			Subscription:               opts.Subscription,
+			TenantID:                   opts.TenantID,
+			AdditionallyAllowedTenants: opts.AdditionalTenants,
+		})
+	case credentialSourceAzureDeveloperCLI:
+		return azidentity.NewAzureDeveloperCLICredential(&azidentity.AzureDeveloperCLICredentialOptions{
+			TenantID:                   opts.TenantID,
+			AdditionallyAllowedTenants: opts.AdditionalTenants,
+		})
+	case credentialSourceEnvironment:
+		// The environment credential reads its tenants from AZURE_TENANT_ID and
+		// AZURE_ADDITIONALLY_ALLOWED_TENANTS.
+		return azidentity.NewEnvironmentCredential(nil)
+	case credentialSourceManagedIdentity:
+		return azidentity.NewManagedIdentityCredential(nil)
+	case credentialSourceDeviceCode:
+		return azidentity.NewDeviceCodeCredential(&azidentity.DeviceCodeCredentialOptions{
+			TenantID:                   opts.TenantID,
+			AdditionallyAllowedTenants: opts.AdditionalTenants,
+			UserPrompt:                 promptDeviceCode,
+		})
+	default:
+		return nil, fmt.Errorf("unknown credential source %q", name)
+	}
+}
+
+// promptDeviceCode shows device code sign-in instructions on the local terminal.
+func promptDeviceCode(ctx context.Context, msg azidentity.DeviceCodeMessage) error {
+	logAuthMessage("Device code sign-in requested at %s", msg.VerificationURL)
+	fmt.Fprintf(os.Stderr, "\r\ngh ado-codespaces: %s\r\n", msg.Message)
+	return nil
+}
+
+// ActiveSource returns the name of the source that issued the last token, or "" if none has.
+func (c *credentialChain) ActiveSource() string {
+	if c == nil {
+		return ""
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.active
+}
+
+// GetToken returns a token from the first source in the chain that can issue one.
+func (c *credentialChain) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	var errs []error
+	for _, source := range c.sources {
+		token, err := source.cred.GetToken(ctx, opts)
+		if err == nil {
+			c.mu.Lock()
+			if c.active != source.name {
+				logAuthMessage("Token issued by credential source '%s'", source.name)
+				c.active = source.name
+			}
+			c.mu.Unlock()
+			return token, nil
+		}
+
+		logAuthMessage("Credential source '%s' failed: %v", source.name, err)
+		if ctx.Err() != nil {
+			return azcore.AccessToken{}, ctx.Err()
+		}
+		errs = append(errs, err)
+	}
+
+	// A single source keeps its original error so callers can classify it as-is.
+	if len(errs) == 1 {
+		return azcore.AccessToken{}, errs[0]
+	}
+	return azcore.AccessToken{}, errors.Join(errs...)
+}
+
+// credentialRouter selects the credential for a request based on the ADO organization
+// it targets, so one session can serve organizations that live in different tenants.
+type credentialRouter struct {
+	defaultCred azcore.TokenCredential
+	base        credentialOptions
+	orgs        map[string]AzureOrganizationConfig
+	newCred     func(credentialOptions) (azcore.TokenCredential, error)
+
+	mu       sync.Mutex
+	orgCreds map[string]azcore.TokenCredential
+}
+
+// newCredentialRouter returns a router that uses defaultCred unless an organization has
+// its own overrides in orgs. Per-organization credentials are created on first use.
+func newCredentialRouter(defaultCred azcore.TokenCredential, base credentialOptions, orgs map[string]AzureOrganizationConfig) *credentialRouter {
+	return &credentialRouter{
+		defaultCred: defaultCred,
+		base:        base,
+		orgs:        orgs,
+		newCred:     newCachedCredentialChain,
+		orgCreds:    make(map[string]azcore.TokenCredential),
+	}
+}
+
+// newCachedCredentialChain builds a credential chain wrapped in a token cache.
+func newCachedCredentialChain(opts credentialOptions) (azcore.TokenCredential, error) {
+	chain, err := newCredentialChain(opts)
+	if err != nil {
+		return nil, err
+	}
+	cache := newCachingCredential(chain)
+	cache.metrics = opts.Metrics
+	return cache, nil
+}
+
+// credentialFor returns the credential to use for the given organization.
+func (r *credentialRouter) credentialFor(org string) (azcore.TokenCredential, error) {
+	org = strings.ToLower(strings.TrimSpace(org))
+	orgCfg, ok := r.orgs[org]
+	if org == "" || !ok {
+		return r.defaultCred, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if cred, ok := r.orgCreds[org]; ok {
+		return cred, nil
+	}
+
+	opts := r.base
+	if sub := strings.TrimSpace(orgCfg.Subscription); sub != "" {
+		opts.Subscription = sub
+	}
+	if tenant := strings.TrimSpace(orgCfg.Tenant); tenant != "" {
+		opts.TenantID = tenant
+	}
+	if len(orgCfg.Credentials) > 0 {
+		opts.Sources = orgCfg.Credentials
+	}
+
+	logAuthMessage("Creating credential for organization '%s' (tenant '%s', sources %v)", org, opts.TenantID, opts.Sources)
+	cred, err := r.newCred(opts)
+	if err != nil {
+		return nil, fmt.Errorf("create credential for organization %s: %w", org, err)
+	}
+	r.orgCreds[org] = cred
+	return cred, nil
+}
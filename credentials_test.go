@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+func TestCredentialChain_FallsBackInOrder(t *testing.T) {
+	discardAuthLogs(t)
+
+	failing := &fakeCredential{err: errors.New("azd not logged in")}
+	working := &fakeCredential{expiresAt: time.Now().Add(time.Hour)}
+	unused := &fakeCredential{expiresAt: time.Now().Add(time.Hour)}
+
+	chain := &credentialChain{sources: []namedCredential{
+		{name: credentialSourceAzureDeveloperCLI, cred: failing},
+		{name: credentialSourceAzureCLI, cred: working},
+		{name: credentialSourceDeviceCode, cred: unused},
+	}}
+
+	token, err := chain.GetToken(context.Background(), policy.TokenRequestOptions{Scopes: []string{defaultADOScope}})
+	if err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+	if token.Token != "token-1" {
+		t.Errorf("expected token from second source, got %q", token.Token)
+	}
+	if chain.active != credentialSourceAzureCLI {
+		t.Errorf("expected active source %q, got %q", credentialSourceAzureCLI, chain.active)
+	}
+	if unused.calls.Load() != 0 {
+		t.Error("expected sources after the first success not to be tried")
+	}
+}
+
+func TestCredentialChain_SingleSourceKeepsError(t *testing.T) {
+	discardAuthLogs(t)
+
+	original := errors.New("ERROR: Please run 'az login' to setup account.")
+	chain := &credentialChain{sources: []namedCredential{
+		{name: credentialSourceAzureCLI, cred: &fakeCredential{err: original}},
+	}}
+
+	_, err := chain.GetToken(context.Background(), policy.TokenRequestOptions{Scopes: []string{defaultADOScope}})
+	if err != original {
+		t.Errorf("expected original error to be returned, got %v", err)
+	}
+}
+
+func TestNewCredentialChain(t *testing.T) {
+	discardAuthLogs(t)
+
+	t.Run("defaults to Azure CLI", func(t *testing.T) {
+		chain, err := newCredentialChain(credentialOptions{})
+		if err != nil {
+			t.Fatalf("newCredentialChain() error = %v", err)
+		}
+		if len(chain.sources) != 1 || chain.sources[0].name != credentialSourceAzureCLI {
+			t.Errorf("expected default Azure CLI source, got %+v", chain.sources)
+		}
+	})
+
+	t.Run("skips unknown sources", func(t *testing.T) {
+		chain, err := newCredentialChain(credentialOptions{Sources: []string{"bogus", credentialSourceAzureDeveloperCLI}})
+		if err != nil {
+			t.Fatalf("newCredentialChain() error = %v", err)
+		}
+		if len(chain.sources) != 1 || chain.sources[0].name != credentialSourceAzureDeveloperCLI {
+			t.Errorf("expected only azureDeveloperCLI source, got %+v", chain.sources)
+		}
+	})
+
+	t.Run("fails without usable sources", func(t *testing.T) {
+		if _, err := newCredentialChain(credentialOptions{Sources: []string{"bogus"}}); err == nil {
+			t.Error("expected error when no sources are usable")
+		}
+	})
+}
+
+func TestCredentialRouter_RoutesByOrganization(t *testing.T) {
+	discardAuthLogs(t)
+
+	defaultCred := &fakeCredential{expiresAt: time.Now().Add(time.Hour)}
+	orgCred := &fakeCredential{expiresAt: time.Now().Add(time.Hour)}
+
+	router := newCredentialRouter(defaultCred, credentialOptions{TenantID: "home-tenant", Sources: []string{credentialSourceAzureCLI}},
+		map[string]AzureOrganizationConfig{"contoso": {Tenant: "contoso-tenant"}})
+
+	var created []credentialOptions
+	router.newCred = func(opts credentialOptions) (azcore.TokenCredential, error) {
+		created = append(created, opts)
+		return orgCred, nil
+	}
+
+	tests := []struct {
+		name string
+		org  string
+		want azcore.TokenCredential
+	}{
+		{name: "no organization", org: "", want: defaultCred},
+		{name: "unconfigured organization", org: "fabrikam", want: defaultCred},
+		{name: "configured organization", org: "contoso", want: orgCred},
+		{name: "case-insensitive organization", org: "Contoso", want: orgCred},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := router.credentialFor(tt.org)
+			if err != nil {
+				t.Fatalf("credentialFor(%q) error = %v", tt.org, err)
+			}
+			if got != tt.want {
+				t.Errorf("credentialFor(%q) returned the wrong credential", tt.org)
+			}
+		})
+	}
+
+	if len(created) != 1 {
+		t.Fatalf("expected organization credential to be created once, got %d", len(created))
+	}
+	if created[0].TenantID != "contoso-tenant" {
+		t.Errorf("expected organization tenant override, got %q", created[0].TenantID)
+	}
+	if len(created[0].Sources) != 1 || created[0].Sources[0] != credentialSourceAzureCLI {
+		t.Errorf("expected organization to inherit credential sources, got %v", created[0].Sources)
+	}
+}
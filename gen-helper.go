@@ -0,0 +1,73 @@
+//go:build ignore
+
+// gen-helper cross-compiles the codespace-side ado-auth-helper for every supported
+// codespace architecture and writes gzip-compressed builds to helperbin/, where they
+// are embedded into the extension. Run it with 'go generate'.
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// helperArchitectures lists the GOARCH values codespaces run on.
+var helperArchitectures = []string{"amd64", "arm64"}
+
+func main() {
+	tempDir, err := os.MkdirTemp("", "ado-auth-helper-build")
+	if err != nil {
+		fail(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	for _, arch := range helperArchitectures {
+		binary := filepath.Join(tempDir, "ado-auth-helper-linux-"+arch)
+		cmd := exec.Command("go", "build", "-trimpath", "-ldflags=-s -w", "-o", binary, "./cmd/ado-auth-helper")
+		cmd.Env = append(os.Environ(), "CGO_ENABLED=0", "GOOS=linux", "GOARCH="+arch)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			fail(fmt.Errorf("build linux/%s: %w", arch, err))
+		}
+
+		output := filepath.Join("helperbin", filepath.Base(binary)+".gz")
+		if err := gzipFile(binary, output); err != nil {
+			fail(err)
+		}
+		fmt.Println("built", output)
+	}
+}
+
+// gzipFile writes a gzip-compressed copy of src to dst.
+func gzipFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	zw, err := gzip.NewWriterLevel(out, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "gen-helper: %v\n", err)
+	os.Exit(1)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// useTCPLocalServices makes the local auth, browser and notification services listen on
+// loopback TCP instead of Unix sockets. Any local process can connect to a loopback
+// port, so this is only the default where OpenSSH can't forward to Unix sockets.
+var useTCPLocalServices = runtime.GOOS == "windows"
+
+// maxUnixSocketPath is the shortest sun_path limit among supported platforms (macOS).
+const maxUnixSocketPath = 104
+
+// localEndpoint is the local address a service listens on.
+type localEndpoint struct {
+	Network string // "unix" or "tcp"
+	Address string // socket path or host:port
+}
+
+// ForwardTarget returns the local side of an ssh -R forward to this endpoint.
+// OpenSSH accepts a Unix socket path or host:port.
+func (e localEndpoint) ForwardTarget() string {
+	return e.Address
+}
+
+// String describes the endpoint for logs.
+func (e localEndpoint) String() string {
+	return e.Network + ":" + e.Address
+}
+
+// DialContext connects to the endpoint.
+func (e localEndpoint) DialContext(ctx context.Context) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, e.Network, e.Address)
+}
+
+var (
+	sessionRuntimeDirOnce sync.Once
+	sessionRuntimeDirPath string
+	sessionRuntimeDirErr  error
+)
+
+// listenLocalService starts a listener for the named service. By default it is a Unix
+// socket readable only by the current user inside a private per-session directory.
+func listenLocalService(name string) (net.Listener, localEndpoint, error) {
+	if useTCPLocalServices {
+		listener, err := net.Listen("tcp", localServiceHost+":0")
+		if err != nil {
+			return nil, localEndpoint{}, err
+		}
+		return listener, localEndpoint{Network: "tcp", Address: listener.Addr().String()}, nil
+	}
+
+	dir, err := sessionRuntimeDir()
+	if err != nil {
+		return nil, localEndpoint{}, err
+	}
+
+	socketPath := filepath.Join(dir, name+".sock")
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return nil, localEndpoint{}, fmt.Errorf("failed to remove stale socket %s: %w", socketPath, err)
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, localEndpoint{}, err
+	}
+	// The directory is already private; this also protects the socket if it is moved.
+	if err := os.Chmod(socketPath, 0o600); err != nil {
+		listener.Close()
+		return nil, localEndpoint{}, fmt.Errorf("failed to restrict socket permissions: %w", err)
+	}
+
+	return listener, localEndpoint{Network: "unix", Address: socketPath}, nil
+}
+
+// sessionRuntimeDir returns the private directory holding this process's service
+// sockets, creating it on first use.
+func sessionRuntimeDir() (string, error) {
+	sessionRuntimeDirOnce.Do(func() {
+		sessionRuntimeDirPath, sessionRuntimeDirErr = createSessionRuntimeDir()
+	})
+	return sessionRuntimeDirPath, sessionRuntimeDirErr
+}
+
+// createSessionRuntimeDir creates <base>/gh-ado-<uid>/<random> with 0700 permissions.
+func createSessionRuntimeDir() (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+
+	parent, err := runtimeUserDir()
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(parent, hex.EncodeToString(suffix))
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create runtime directory: %w", err)
+	}
+	return dir, nil
+}
+
+// runtimeUserDir returns the private <base>/gh-ado-<uid> directory shared by this
+// user's sessions and agents, creating it if needed. The base is $XDG_RUNTIME_DIR when
+// set, otherwise the temp directory, falling back to /tmp when socket paths would
+// exceed the platform limit.
+func runtimeUserDir() (string, error) {
+	userDir := fmt.Sprintf("gh-ado-%d", os.Getuid())
+
+	base := os.Getenv("XDG_RUNTIME_DIR")
+	if base == "" {
+		base = os.TempDir()
+	}
+	// Leave room for the longest socket path below it ("<random>/notification.sock").
+	if len(filepath.Join(base, userDir, "00000000", "notification.sock")) > maxUnixSocketPath {
+		base = "/tmp"
+	}
+
+	parent := filepath.Join(base, userDir)
+	if err := ensurePrivateDir(parent); err != nil {
+		return "", err
+	}
+	return parent, nil
+}
+
+// ensurePrivateDir creates dir with 0700 permissions, or checks that an existing dir
+// is a real directory that only the current user can access.
+func ensurePrivateDir(dir string) error {
+	if err := os.Mkdir(dir, 0o700); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("failed to create runtime directory: %w", err)
+	}
+
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("runtime directory %s is not a directory", dir)
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		if err := os.Chmod(dir, 0o700); err != nil {
+			return fmt.Errorf("runtime directory %s is accessible by other users: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// cleanupSessionRuntimeDir removes the per-session socket directory, if one was created.
+func cleanupSessionRuntimeDir() {
+	if sessionRuntimeDirPath == "" {
+		return
+	}
+	if err := os.RemoveAll(sessionRuntimeDirPath); err != nil {
+		logDebug("Failed to remove runtime directory %s: %v", sessionRuntimeDirPath, err)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testAuthEndpoint is a stand-in auth server endpoint for BuildSSHArgs tests.
+var testAuthEndpoint = localEndpoint{Network: "unix", Address: "/tmp/gh-ado-test/auth.sock"}
+
+// localServiceClient returns an HTTP client that dials a local service endpoint,
+// whether it is a Unix socket or a TCP port, and sends secret when it isn't empty.
+func localServiceClient(local localEndpoint, secret string) *http.Client {
+	return &http.Client{Transport: secretTransport{
+		secret: secret,
+		base: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return local.DialContext(ctx)
+			},
+		},
+	}}
+}
+
+// secretTransport adds the session secret header to each request.
+type secretTransport struct {
+	secret string
+	base   http.RoundTripper
+}
+
+func (t secretTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.secret != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set(sessionSecretHeader, t.secret)
+	}
+	return t.base.RoundTrip(req)
+}
+
+func TestListenLocalService_UnixSocket(t *testing.T) {
+	if useTCPLocalServices {
+		t.Skip("Unix socket services are disabled on this platform")
+	}
+
+	listener, local, err := listenLocalService("test")
+	if err != nil {
+		t.Fatalf("listenLocalService() error = %v", err)
+	}
+	defer listener.Close()
+
+	if local.Network != "unix" {
+		t.Fatalf("expected unix endpoint, got %s", local)
+	}
+	if len(local.Address) > maxUnixSocketPath {
+		t.Errorf("socket path %q exceeds %d bytes", local.Address, maxUnixSocketPath)
+	}
+
+	socketInfo, err := os.Stat(local.Address)
+	if err != nil {
+		t.Fatalf("failed to stat socket: %v", err)
+	}
+	if perm := socketInfo.Mode().Perm(); perm != 0o600 {
+		t.Errorf("expected socket permissions 0600, got %o", perm)
+	}
+
+	dirInfo, err := os.Stat(filepath.Dir(local.Address))
+	if err != nil {
+		t.Fatalf("failed to stat runtime directory: %v", err)
+	}
+	if perm := dirInfo.Mode().Perm(); perm != 0o700 {
+		t.Errorf("expected runtime directory permissions 0700, got %o", perm)
+	}
+
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := local.DialContext(context.Background())
+	if err != nil {
+		t.Fatalf("failed to dial %s: %v", local, err)
+	}
+	conn.Close()
+}
+
+func TestListenLocalService_TCPFallback(t *testing.T) {
+	original := useTCPLocalServices
+	useTCPLocalServices = true
+	defer func() { useTCPLocalServices = original }()
+
+	listener, local, err := listenLocalService("test")
+	if err != nil {
+		t.Fatalf("listenLocalService() error = %v", err)
+	}
+	defer listener.Close()
+
+	host, _, err := net.SplitHostPort(local.Address)
+	if local.Network != "tcp" || err != nil || host != localServiceHost {
+		t.Errorf("expected loopback TCP endpoint, got %s", local)
+	}
+}
+
+func TestEnsurePrivateDir_TightensPermissions(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "runtime")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ensurePrivateDir(dir); err != nil {
+		t.Fatalf("ensurePrivateDir() error = %v", err)
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o700 {
+		t.Errorf("expected 0700, got %o", perm)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// loginProbeTimeout bounds the token check made before the SSH session starts.
+const loginProbeTimeout = 30 * time.Second
+
+// loginAlertInterval is the minimum time between desktop notifications for the same
+// kind of token failure.
+const loginAlertInterval = 10 * time.Minute
+
+// loginAlertCodes are the failures that mean the local login needs attention, as
+// opposed to a client asking for something it isn't allowed to have.
+var loginAlertCodes = []string{authErrNotLoggedIn, authErrWrongTenant, authErrAzMissing, authErrUnavailable}
+
+// probeLogin gets a token for the default ADO scope, like a status request, and returns
+// why it failed or nil. A successful probe also warms the token cache for git.
+func (s *authServer) probeLogin(ctx context.Context) *AuthError {
+	cred, err := s.creds.credentialFor("")
+	if err == nil {
+		probeCtx, cancel := context.WithTimeout(ctx, loginProbeTimeout)
+		_, err = cred.GetToken(probeCtx, policy.TokenRequestOptions{Scopes: []string{defaultADOScope}})
+		cancel()
+	}
+	if err != nil {
+		logAuthMessage("Login probe could not get a token: %v", err)
+		authErr := classifyTokenError(err, []string{defaultADOScope})
+		return &authErr
+	}
+	logAuthMessage("Login probe got a token for the default ADO scope")
+	return nil
+}
+
+// azLoginArgs returns the az arguments that log in with consent for the default ADO
+// scope, in tenant when one is configured.
+func azLoginArgs(tenant string) []string {
+	args := []string{"login", "--scope", defaultADOScope}
+	if tenant != "" {
+		args = append(args, "--tenant", tenant)
+	}
+	return args
+}
+
+// loginHint returns the az login command that should fix authErr, or "" when logging
+// in won't help or the credential chain doesn't use the Azure CLI.
+func loginHint(authErr *AuthError, tenant string, sources []string) string {
+	if authErr == nil || !slices.Contains(sources, credentialSourceAzureCLI) {
+		return ""
+	}
+	switch authErr.Code {
+	case authErrNotLoggedIn, authErrWrongTenant, authErrUnavailable:
+		return "az " + strings.Join(azLoginArgs(tenant), " ")
+	default:
+		return ""
+	}
+}
+
+// runAzLogin runs az login in the terminal so the user can complete it interactively.
+func runAzLogin(ctx context.Context, tenant string) error {
+	cmd := exec.CommandContext(ctx, "az", azLoginArgs(tenant)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stderr, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("az login failed: %w", err)
+	}
+	return nil
+}
+
+// loginAlerter raises a desktop notification when token requests start failing in the
+// middle of a session, since the failing git command is only visible in the codespace.
+// Each kind of failure is reported at most once per loginAlertInterval, and again
+// straight away after a token has been issued in between.
+type loginAlerter struct {
+	notify atomic.Pointer[func(title, message string) error]
+	now    func() time.Time
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+// newLoginAlerter returns an alerter that stays quiet until setNotifier is called.
+func newLoginAlerter() *loginAlerter {
+	return &loginAlerter{now: time.Now, last: make(map[string]time.Time)}
+}
+
+// setNotifier sets the function that shows desktop notifications.
+func (a *loginAlerter) setNotifier(notify func(title, message string) error) {
+	a.notify.Store(&notify)
+}
+
+// failure reports a token failure, notifying the desktop when it calls for a new login.
+func (a *loginAlerter) failure(authErr AuthError) {
+	if a == nil || !slices.Contains(loginAlertCodes, authErr.Code) {
+		return
+	}
+	notify := a.notify.Load()
+	if notify == nil {
+		return
+	}
+
+	a.mu.Lock()
+	now := a.now()
+	if last, ok := a.last[authErr.Code]; ok && now.Sub(last) < loginAlertInterval {
+		a.mu.Unlock()
+		return
+	}
+	a.last[authErr.Code] = now
+	a.mu.Unlock()
+
+	logAuthMessage("Notifying desktop about token failure '%s'", authErr.Code)
+	if err := (*notify)("Codespace token request failed", authErr.Message); err != nil {
+		logAuthMessage("Failed to send token failure notification: %v", err)
+	}
+}
+
+// success records that a token was issued, so the next failure is reported at once.
+func (a *loginAlerter) success() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	clear(a.last)
+}
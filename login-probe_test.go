@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProbeLogin(t *testing.T) {
+	discardAuthLogs(t)
+
+	ok := newTestAuthServer(&fakeCredential{expiresAt: time.Now().Add(time.Hour)})
+	if authErr := ok.probeLogin(context.Background()); authErr != nil {
+		t.Errorf("expected probe to succeed, got %v", authErr)
+	}
+
+	loggedOut := newTestAuthServer(&fakeCredential{err: errors.New("Please run 'az login' to setup account.")})
+	authErr := loggedOut.probeLogin(context.Background())
+	if authErr == nil || authErr.Code != authErrNotLoggedIn {
+		t.Errorf("expected %s, got %v", authErrNotLoggedIn, authErr)
+	}
+}
+
+func TestLoginHint(t *testing.T) {
+	notLoggedIn := &AuthError{Code: authErrNotLoggedIn}
+	cli := []string{credentialSourceAzureCLI}
+
+	tests := []struct {
+		name    string
+		authErr *AuthError
+		tenant  string
+		sources []string
+		want    string
+	}{
+		{name: "no error", authErr: nil, sources: cli, want: ""},
+		{name: "not logged in", authErr: notLoggedIn, sources: cli, want: "az login --scope " + defaultADOScope},
+		{name: "with tenant", authErr: notLoggedIn, tenant: "contoso.onmicrosoft.com", sources: cli, want: "az login --scope " + defaultADOScope + " --tenant contoso.onmicrosoft.com"},
+		{name: "az missing", authErr: &AuthError{Code: authErrAzMissing}, sources: cli, want: ""},
+		{name: "chain without az", authErr: notLoggedIn, sources: []string{"azureDeveloperCLI"}, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := loginHint(tt.authErr, tt.tenant, tt.sources); got != tt.want {
+				t.Errorf("loginHint() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginAlerter(t *testing.T) {
+	discardAuthLogs(t)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	alerts := newLoginAlerter()
+	alerts.now = func() time.Time { return now }
+
+	var sent []string
+	notLoggedIn := AuthError{Code: authErrNotLoggedIn, Message: "Run az login"}
+
+	alerts.failure(notLoggedIn)
+	if len(sent) != 0 {
+		t.Fatal("expected no notification before a notifier is set")
+	}
+
+	alerts.setNotifier(func(title, message string) error {
+		sent = append(sent, message)
+		return nil
+	})
+	alerts.failure(notLoggedIn)
+	alerts.failure(notLoggedIn)
+	alerts.failure(AuthError{Code: authErrScopeDenied, Message: "denied"})
+	if len(sent) != 1 || sent[0] != "Run az login" {
+		t.Fatalf("expected one notification for repeated login failures, got %v", sent)
+	}
+
+	now = now.Add(loginAlertInterval)
+	alerts.failure(notLoggedIn)
+	if len(sent) != 2 {
+		t.Errorf("expected another notification after %v, got %v", loginAlertInterval, sent)
+	}
+
+	alerts.success()
+	alerts.failure(notLoggedIn)
+	if len(sent) != 3 {
+		t.Errorf("expected a notification straight after a success, got %v", sent)
+	}
+}
+
+func TestHandleConnection_NotifiesTokenFailures(t *testing.T) {
+	auth := newTestAuthServer(&fakeCredential{err: errors.New("AADSTS700082: The refresh token has expired")})
+	auth.alerts = newLoginAlerter()
+
+	var titles []string
+	auth.alerts.setNotifier(func(title, message string) error {
+		titles = append(titles, title)
+		if !strings.Contains(message, "az login") {
+			t.Errorf("expected the notification to say how to fix it, got %q", message)
+		}
+		return nil
+	})
+
+	resp := exchangeAuthMessage(t, auth, `{"secret":"test-secret","type":"getAccessToken","data":{}}`)
+	if resp["type"] != "error" {
+		t.Fatalf("expected an error response, got %v", resp)
+	}
+	if len(titles) != 1 {
+		t.Errorf("expected one desktop notification, got %d", len(titles))
+	}
+}
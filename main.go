@@ -15,11 +15,15 @@ import (
 	"time"
 
 	"github.com/cli/go-gh/v2"
+	"github.com/google/uuid"
 )
 
 // Global session ID for this application instance
 var sessionID string
 
+// version is the extension version, set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
 func main() {
 	// Create a cancellable context from context.Background().
 	// cancel will be called when main exits or when an OS signal is received.
@@ -36,6 +40,13 @@ func main() {
 		cancel()  // Propagate cancellation through the context.
 	}()
 
+	// The agent subcommands manage background agents instead of opening a session
+	if len(os.Args) > 1 && os.Args[1] == "agent" {
+		code := runAgentCommand(ctx, os.Args[2:])
+		cancel()
+		os.Exit(code)
+	}
+
 	// Parse command line arguments
 	args := ParseArgs()
 
@@ -45,19 +56,25 @@ func main() {
 		return
 	}
 
+	if args.TCPServices {
+		useTCPLocalServices = true
+	}
+	// Service sockets live in a private per-session directory; remove it on exit.
+	defer cleanupSessionRuntimeDir()
+
 	cfg, cfgErr := LoadAppConfig()
 	if cfgErr != nil {
 		fmt.Fprintf(os.Stderr, "Warning: failed to load config: %v\n", cfgErr)
 		cfg = AppConfig{}
 	}
 
-	// Only resolve the current GitHub login when per-account reversePortForward
-	// settings or a per-login Azure subscription override are actually needed,
-	// to avoid an unnecessary `gh api user` network call on every run.
-	needLogin := args.AzureSubscriptionId != ""
+	// Only resolve the current GitHub login when per-account reversePortForward or
+	// portForwardRules settings or a per-login Azure subscription override are actually
+	// needed, to avoid an unnecessary `gh api user` network call on every run.
+	needLogin := args.AzureSubscriptionId != "" || args.AzureTenantId != ""
 	if !needLogin {
 		for _, acct := range cfg.Accounts {
-			if len(acct.ReversePortForward) > 0 {
+			if len(acct.ReversePortForward) > 0 || len(acct.PortForwardRules) > 0 {
 				needLogin = true
 				break
 			}
@@ -92,6 +109,49 @@ func main() {
 		}
 	}
 
+	// Persist Azure tenant ID override the same way.
+	if args.AzureTenantId != "" {
+		if loginErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: unable to determine GitHub login to store Azure tenant: %v\n", loginErr)
+		} else {
+			cfg.SetAzureTenantForLogin(login, args.AzureTenantId)
+			if err := SaveAppConfig(cfg); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to save Azure tenant to config: %v\n", err)
+			} else {
+				fmt.Fprintf(os.Stderr, "Stored Azure tenant ID for login '%s' in config.\n", login)
+			}
+		}
+	}
+
+	if args.Agent && args.CodespaceName == "" {
+		fmt.Fprintln(os.Stderr, "Error: an agent needs a codespace name (-c <codespace>)")
+		return
+	}
+
+	// A running agent or session may already serve this codespace; share its services
+	// and forwards. The lock is held until this process has published its own.
+	var lock *sessionLock
+	defer func() { lock.Unlock() }()
+	if args.CodespaceName != "" {
+		var running *AgentStatus
+		lock, running = claimCodespace(ctx, args.CodespaceName)
+		if running != nil {
+			if args.Agent {
+				fmt.Fprintf(os.Stderr, "Error: %s is already served by pid %d\n", args.CodespaceName, running.PID)
+				return
+			}
+			runAttachedSession(ctx, args, running)
+			return
+		}
+	}
+
+	// Every local service requires this secret, which only the codespace user can read.
+	secret, err := newSessionSecret()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+
 	// Setup server and (optionally) select codespace.
 	// When we need to prompt for a codespace, run both in parallel since
 	// SetupServer and SelectCodespace are independent.
@@ -110,7 +170,7 @@ func main() {
 		codespaceCh := make(chan codespaceResult, 1)
 
 		go func() {
-			cfg, err := SetupServer(ctx)
+			cfg, err := SetupServer(ctx, secret)
 			serverCh <- serverResult{cfg, err}
 		}()
 
@@ -130,23 +190,39 @@ func main() {
 			return
 		}
 
+		var running *AgentStatus
+		lock, running = claimCodespace(ctx, cr.name)
+		if running != nil {
+			sr.config.Close()
+			args.CodespaceName = cr.name
+			runAttachedSession(ctx, args, running)
+			return
+		}
+
 		serverConfig = sr.config
 		args.CodespaceName = cr.name
 	} else {
-		var err error
-		serverConfig, err = SetupServer(ctx)
+		serverConfig, err = SetupServer(ctx, secret)
 		if err != nil {
 			return
 		}
 	}
 	defer serverConfig.Listener.Close()
+	defer reportSessionMetrics(serverConfig, args.Stats)
+
+	// Report a logged out az now instead of through failing git commands later
+	if serverConfig.LoginError != nil {
+		// An agent has no terminal to run az login in
+		checkAzureLogin(ctx, serverConfig, args.AzLogin && !args.Agent)
+	}
 
 	// Initialize session ID now that we have the codespace name
 	initializeSessionID(args.CodespaceName)
+	serverConfig.SetSessionID(sessionID)
 
 	// Start the browser service early so we can include its port in SSH args
 	var browserService *BrowserService
-	browserService, err := NewBrowserService(ctx)
+	browserService, err = NewBrowserService(ctx, secret)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Warning: failed to start browser service: %v\n", err)
 		// Continue anyway, SSH will still work without browser forwarding
@@ -156,28 +232,29 @@ func main() {
 
 	// Start the notification service early so we can include its port in SSH args
 	var notificationService *NotificationService
-	notificationService, err = NewNotificationService(ctx)
+	notificationService, err = NewNotificationService(ctx, secret)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Warning: failed to start notification service: %v\n", err)
 		// Continue anyway, SSH will still work without notification forwarding
 	} else {
 		defer notificationService.Stop()
+		serverConfig.SetNotifier(notificationService.Notify)
 	}
 
-	// Build command line arguments for gh
-	ghFlags := args.BuildGHFlags()
-	sshArgs := args.BuildSSHArgs(serverConfig.SocketPath, serverConfig.Port, browserService, notificationService)
-
-	// Combine all arguments
-	finalArgs := append(ghFlags, sshArgs...)
-
 	// Upload all scripts and configure them in a single SSH call
-	if err := prepareCodespaceScripts(ctx, args.CodespaceName, browserService != nil, notificationService != nil); err != nil {
+	setup := codespaceSetup{
+		HasBrowserService:      browserService != nil,
+		HasNotificationService: notificationService != nil,
+		Secret:                 secret,
+		SocketPaths:            serviceSocketPaths(serverConfig, browserService, notificationService),
+		FeedShims:              args.FeedAuth,
+	}
+	if err := prepareCodespaceScripts(ctx, args.CodespaceName, setup); err != nil {
 		fmt.Fprintf(os.Stderr, "Warning: failed to prepare codespace scripts: %v\n", err)
 	}
 
 	// Print instructions for notification service if it's running and script upload succeeded
-	if notificationService != nil {
+	if notificationService != nil && !args.Agent {
 		fmt.Fprintf(os.Stderr, "Command completion notifications available! To enable, add to your shell config:\n")
 		fmt.Fprintf(os.Stderr, "  # For bash (~/.bashrc) or zsh (~/.zshrc)\n")
 		fmt.Fprintf(os.Stderr, "  if [ -f \"$HOME/notification-sender.sh\" ]; then\n")
@@ -189,7 +266,15 @@ func main() {
 	}
 
 	// Start the port monitor in the background
-	monitorController, err := StartPortMonitor(ctx, args.CodespaceName)
+	monitorOptions := PortMonitorOptions{
+		Reconnect:         args.Reconnect || args.Agent,
+		Rules:             portForwardRulesFor(cfg, login, args.CodespaceName),
+		LocalPortStrategy: cfg.LocalPortStrategy,
+	}
+	if notificationService != nil {
+		monitorOptions.Notify = notificationService.Notify
+	}
+	monitorController, err := StartPortMonitor(ctx, args.CodespaceName, monitorOptions)
 	if err != nil {
 		return
 	}
@@ -198,9 +283,131 @@ func main() {
 		monitorController.Wait() // Wait for cleanup
 	}()
 
-	// Execute the command
-	// Pass the cancellable context to gh.ExecInteractive
-	gh.ExecInteractive(ctx, finalArgs...)
+	// Publish the services for other sessions of this codespace and for 'agent status'
+	// and 'agent stop'
+	mode := "session"
+	if args.Agent {
+		mode = "agent"
+	}
+	startedAt := time.Now()
+	control, err := startAgentControl(args.CodespaceName, func() AgentStatus {
+		return AgentStatus{
+			Codespace:   args.CodespaceName,
+			Mode:        mode,
+			PID:         os.Getpid(),
+			StartedAt:   startedAt,
+			SocketPaths: setup.SocketPaths,
+			LogDir:      getSessionLogDirectory(),
+			Metrics:     serverConfig.Metrics(),
+		}
+	}, cancel)
+	lock.Unlock()
+	switch {
+	case err == nil:
+		defer control.Close()
+		if args.Agent {
+			fmt.Fprintf(os.Stderr, "Agent running for %s (pid %d)\n", args.CodespaceName, os.Getpid())
+		}
+	case args.Agent:
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	default:
+		// The session still works; other sessions just can't share its services
+		logDebug("Failed to publish session services: %v", err)
+	}
+
+	// In reconnect mode the session runs in tmux, when the codespace has it, so a
+	// reconnection picks up where the dropped connection left off.
+	tmuxSession := ""
+	if args.Reconnect && len(args.RemainingArgs) == 0 {
+		tmuxSession = "gh-ado-" + uuid.New().String()[:8]
+	}
+
+	connect := func(ctx context.Context, attempt int) error {
+		if attempt > 0 {
+			// The codespace may have restarted, and the dropped connection's sockets
+			// would block forwarding them again.
+			setup.Reconnect = true
+			if err := prepareCodespaceScripts(ctx, args.CodespaceName, setup); err != nil {
+				logDebug("Failed to prepare codespace for reconnection: %v", err)
+			}
+		}
+
+		// Build command line arguments for gh; reverse forwards are detected afresh
+		ghFlags := args.BuildGHFlags()
+		sshArgs := args.BuildSSHArgs(serverConfig.SocketPath, serverConfig.Local, browserService, notificationService)
+		if tmuxSession != "" {
+			sshArgs = append(sshArgs, tmuxSessionCommand(tmuxSession)...)
+		}
+
+		// Pass the cancellable context so gh stops with the session
+		return execSSHSession(ctx, append(ghFlags, sshArgs...)...)
+	}
+
+	if !args.Reconnect && !args.Agent {
+		connect(ctx, 0)
+	} else {
+		supervisor := newSessionSupervisor(connect)
+		// An agent's connection runs no remote command (-N)
+		supervisor.persistent = args.Agent
+		supervisor.run(ctx)
+	}
+
+	// Only the last session of the codespace tears the services down
+	if control != nil && !args.Agent {
+		args.Agent = true
+		tmuxSession = ""
+		keepServicesForSessions(ctx, control, args.CodespaceName, connect)
+	}
+}
+
+// checkAzureLogin explains a failed startup token probe and prints the az login command
+// that should fix it. With runLogin it runs that command and checks again. The session
+// continues either way, since the codespace can still be used without tokens.
+func checkAzureLogin(ctx context.Context, serverConfig *ServerConfig, runLogin bool) {
+	fmt.Fprintf(os.Stderr, "Warning: the auth server can't get Azure DevOps tokens: %s\n", serverConfig.LoginError.Message)
+	hint := serverConfig.LoginHint()
+	if hint == "" {
+		return
+	}
+	if !runLogin {
+		fmt.Fprintf(os.Stderr, "Run this on this machine to fix it, or pass --az-login to run it now:\n  %s\n", hint)
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Running: %s\n", hint)
+	if err := serverConfig.AzLogin(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		return
+	}
+	if loginErr := serverConfig.ProbeLogin(ctx); loginErr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: still unable to get Azure DevOps tokens: %s\n", loginErr.Message)
+		return
+	}
+	fmt.Fprintln(os.Stderr, "Azure login succeeded; tokens are available to the codespace.")
+}
+
+// reportSessionMetrics logs the session's token metrics to the auth log and, with
+// --stats, prints them when the session ends.
+func reportSessionMetrics(serverConfig *ServerConfig, printStats bool) {
+	summary := formatMetricsSummary(serverConfig.Metrics())
+	logAuthMessage("Session token metrics:\n%s", summary)
+	if printStats {
+		fmt.Fprintf(os.Stderr, "\nToken stats for this session:\n%s", summary)
+	}
+}
+
+// portForwardRulesFor returns the port forward rules for a codespace. The codespace's
+// repository is only looked up when the config has per-repository settings.
+func portForwardRulesFor(cfg AppConfig, login, codespaceName string) []PortForwardRule {
+	var repository string
+	if len(cfg.Repositories) > 0 {
+		var err error
+		if repository, err = codespaceRepository(codespaceName); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: unable to determine the repository of %s for its port forward rules: %v\n", codespaceName, err)
+		}
+	}
+	return cfg.PortForwardRulesForLogin(login, repository)
 }
 
 // initializeSessionID creates a session ID including the codespace name
@@ -244,18 +451,59 @@ func sanitizeForFilename(name string) string {
 	return result
 }
 
-// prepareCodespaceScripts writes all helper scripts to the codespace in a single SSH session.
-func prepareCodespaceScripts(ctx context.Context, codespaceName string, hasBrowserService, hasNotificationService bool) error {
-	script := buildCodespacePreparationScript(hasBrowserService, hasNotificationService)
+// codespaceSetup describes what the preparation script installs in the codespace.
+type codespaceSetup struct {
+	HasBrowserService      bool
+	HasNotificationService bool
+	// Secret is written next to each of SocketPaths for clients in the codespace.
+	Secret      string
+	SocketPaths []string
+	// FeedShims installs the package tool shims that authenticate Azure Artifacts feeds.
+	FeedShims bool
+	// Reconnect prepares the codespace again after a dropped connection: it removes
+	// the sockets the old connection left behind and skips the setup instructions.
+	Reconnect bool
+
+	// Machine is the codespace's `uname -m`, which picks the helper build to upload.
+	Machine string
+	// InstalledHelperSHA256 is the hash of the helper already in the codespace. The
+	// upload is skipped when it matches the build for Machine.
+	InstalledHelperSHA256 string
+}
+
+// prepareCodespaceScripts writes all helper scripts, and the session secret for each
+// forwarded socket, to the codespace in a single SSH session.
+func prepareCodespaceScripts(ctx context.Context, codespaceName string, setup codespaceSetup) error {
+	// Ask for the architecture first so only the matching helper build is sent
+	probeOutput, err := runCodespaceBashScript(ctx, codespaceName, authHelperProbeScript)
+	if err != nil {
+		return fmt.Errorf("error checking the codespace architecture: %w\nCommand output: %s", err, probeOutput)
+	}
+	setup.Machine, setup.InstalledHelperSHA256 = parseAuthHelperProbe(probeOutput)
+
+	script := buildCodespacePreparationScript(setup)
 	commandOutput, err := runCodespaceBashScript(ctx, codespaceName, script)
 	if err != nil {
 		return fmt.Errorf("error preparing scripts: %w\nCommand output: %s", err, commandOutput)
 	}
 
+	if setup.Reconnect {
+		return nil
+	}
+
 	// Print success messages
-	fmt.Fprintln(os.Stderr, "ADO and Azure auth helpers uploaded to the codespace and made executable")
+	if helperWarnings := authHelperWarnings(commandOutput); len(helperWarnings) > 0 {
+		for _, warning := range helperWarnings {
+			fmt.Fprintln(os.Stderr, warning)
+		}
+	} else {
+		fmt.Fprintln(os.Stderr, "ADO and Azure auth helpers uploaded to the codespace and made executable")
+	}
 	fmt.Fprintln(os.Stderr, "xdg-open installed at /usr/local/bin/xdg-open")
-	if hasBrowserService {
+	if setup.FeedShims {
+		fmt.Fprintf(os.Stderr, "Azure Artifacts feed shims for %s installed in ~/.gh-ado/shims (new shells pick them up)\n", strings.Join(feedShimTools, ", "))
+	}
+	if setup.HasBrowserService {
 		fmt.Fprintf(os.Stderr, "\nBrowser opener available! To enable browser forwarding, add to your shell config:\n")
 		fmt.Fprintf(os.Stderr, "  export BROWSER=\"$HOME/browser-opener.sh\"\n\n")
 	}
@@ -264,18 +512,12 @@ func prepareCodespaceScripts(ctx context.Context, codespaceName string, hasBrows
 }
 
 // buildCodespacePreparationScript returns the remote setup script sent over stdin.
-func buildCodespacePreparationScript(hasBrowserService, hasNotificationService bool) string {
+func buildCodespacePreparationScript(setup codespaceSetup) string {
+	hasBrowserService, hasNotificationService := setup.HasBrowserService, setup.HasNotificationService
 	var cmdParts []string
 
-	// Base64-encode and write auth helper to two destinations
-	authB64 := base64.StdEncoding.EncodeToString([]byte(adoAuthHelperScript))
-	cmdParts = append(cmdParts,
-		fmt.Sprintf("printf %%s %s | base64 -d > ~/ado-auth-helper && cp ~/ado-auth-helper ~/azure-auth-helper", authB64))
-
-	// Base64-encode and write port monitor script
-	portB64 := base64.StdEncoding.EncodeToString([]byte(portMonitorScript))
-	cmdParts = append(cmdParts,
-		fmt.Sprintf("printf %%s %s | base64 -d > ~/port-monitor.sh", portB64))
+	// Install the auth helper binary matching the codespace architecture
+	cmdParts = append(cmdParts, buildAuthHelperInstallCommand(setup.Machine, setup.InstalledHelperSHA256))
 
 	// Browser opener (only if browser service is available)
 	if hasBrowserService {
@@ -297,7 +539,7 @@ func buildCodespacePreparationScript(hasBrowserService, hasNotificationService b
 		fmt.Sprintf("printf %%s %s | base64 -d > ~/xdg-open.sh", xdgB64))
 
 	// Make all scripts executable
-	chmodFiles := "~/ado-auth-helper ~/azure-auth-helper ~/port-monitor.sh ~/xdg-open.sh"
+	chmodFiles := "~/xdg-open.sh"
 	if hasBrowserService {
 		chmodFiles += " ~/browser-opener.sh"
 	}
@@ -311,17 +553,84 @@ func buildCodespacePreparationScript(hasBrowserService, hasNotificationService b
 		"(test -L /usr/local/bin/ado-auth-helper || sudo ln -sf ~/ado-auth-helper /usr/local/bin/ado-auth-helper)")
 	cmdParts = append(cmdParts,
 		"(test -L /usr/local/bin/azure-auth-helper || sudo ln -sf ~/azure-auth-helper /usr/local/bin/azure-auth-helper)")
+	cmdParts = append(cmdParts,
+		"(test -L /usr/local/bin/docker-credential-ado || sudo ln -sf ~/docker-credential-ado /usr/local/bin/docker-credential-ado)")
 	cmdParts = append(cmdParts,
 		"(test -L /usr/local/bin/xdg-open || sudo ln -sf ~/xdg-open.sh /usr/local/bin/xdg-open)")
 
+	// Package tool shims for Azure Artifacts feeds (only if requested)
+	if setup.FeedShims {
+		cmdParts = append(cmdParts, buildFeedShimInstallCommand())
+	}
+
+	// Remove this session's sockets left behind by a dropped connection
+	if setup.Reconnect {
+		cmdParts = append(cmdParts, "rm -f "+strings.Join(setup.SocketPaths, " "))
+	}
+
 	// Clean up stale sockets
 	if cleanupCmd := buildStaleSocketCleanupCommand(hasBrowserService, hasNotificationService); cleanupCmd != "" {
 		cmdParts = append(cmdParts, cleanupCmd)
 	}
 
+	// Write the session secret clients must send to the forwarded sockets
+	cmdParts = append(cmdParts, buildStaleSecretCleanupCommand())
+	cmdParts = append(cmdParts, buildSessionSecretCommand(setup.Secret, setup.SocketPaths))
+
 	return "set -e\n" + strings.Join(cmdParts, "\n") + "\n"
 }
 
+// buildAuthHelperInstallCommand returns a command that installs the helper build for
+// machine, the codespace's `uname -m`, as ~/ado-auth-helper, with ~/azure-auth-helper
+// and ~/docker-credential-ado linked to it. The binary is written to a temporary file
+// and moved into place so a helper that is still running from an earlier session
+// doesn't block the update. It isn't sent again when installedSHA256 shows the same
+// build is already installed. When there is no build for the architecture, the
+// install is skipped with a warning so the rest of the preparation still runs.
+func buildAuthHelperInstallCommand(machine, installedSHA256 string) string {
+	links := "ln -sf ~/ado-auth-helper ~/azure-auth-helper && ln -sf ~/ado-auth-helper ~/docker-credential-ado"
+
+	goarch, ok := authHelperGoarch(machine)
+	if !ok {
+		return "echo " + quoteForShell(authHelperSkippedWarning+"unsupported architecture "+machine) + " >&2"
+	}
+	gz, err := gzippedAuthHelper(goarch)
+	if err != nil {
+		logDebug("Auth helper unavailable: %v", err)
+		return "echo " + quoteForShell(authHelperSkippedWarning+err.Error()) + " >&2"
+	}
+	if sum, err := authHelperSHA256(goarch); err == nil && sum == installedSHA256 {
+		logDebug("Auth helper for linux/%s is already installed", goarch)
+		return links
+	}
+	return fmt.Sprintf("printf %%s %s | base64 -d | gunzip > ~/.ado-auth-helper.tmp\n", base64.StdEncoding.EncodeToString(gz)) +
+		"chmod +x ~/.ado-auth-helper.tmp && mv -f ~/.ado-auth-helper.tmp ~/ado-auth-helper && " + links
+}
+
+// authHelperWarnings returns the warnings the preparation script printed about
+// skipping the helper install.
+func authHelperWarnings(output string) []string {
+	var warnings []string
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, authHelperSkippedWarning) {
+			warnings = append(warnings, line)
+		}
+	}
+	return warnings
+}
+
+// serviceSocketPaths returns the codespace socket paths of the running services.
+func serviceSocketPaths(serverConfig *ServerConfig, browserService *BrowserService, notificationService *NotificationService) []string {
+	socketPaths := []string{serverConfig.SocketPath}
+	if browserService != nil {
+		socketPaths = append(socketPaths, browserService.SocketPath)
+	}
+	if notificationService != nil {
+		socketPaths = append(socketPaths, notificationService.SocketPath)
+	}
+	return socketPaths
+}
+
 // buildCodespaceBashStdinArgs returns a short gh invocation that reads setup commands from stdin.
 func buildCodespaceBashStdinArgs(codespaceName string) []string {
 	return append(
@@ -381,10 +690,11 @@ func getSessionLogPath(logFileName string) string {
 	return filepath.Join(sessionDir, logFileName)
 }
 
-// ensureSessionLogDirectory creates the session log directory if it doesn't exist
+// ensureSessionLogDirectory creates the session log directory if it doesn't exist.
+// It lives in the shared temp directory, so only the current user can open it.
 func ensureSessionLogDirectory() error {
 	sessionDir := getSessionLogDirectory()
-	return os.MkdirAll(sessionDir, 0755)
+	return os.MkdirAll(sessionDir, 0o700)
 }
 
 // ListRecentLogFiles lists recent log files in reverse chronological order
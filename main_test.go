@@ -1,6 +1,11 @@
 package main
 
 import (
+	"encoding/base64"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 )
@@ -238,20 +243,6 @@ func TestFormatFileSize(t *testing.T) {
 	}
 }
 
-// TestUploadPortMonitorFileSignature verifies the function is accessible to main package
-func TestUploadPortMonitorFileSignature(t *testing.T) {
-	// This test ensures uploadPortMonitorFile function exists and has correct signature
-	// The function is called by prepareCodespaceScripts
-
-	// We can't call it directly in tests without a real codespace,
-	// but we can verify it compiles and has the right structure
-	t.Run("function_exists", func(t *testing.T) {
-		// If this test compiles, the function signature is correct
-		// uploadPortMonitorFile is called in main.go's prepareCodespaceScripts
-		t.Log("uploadPortMonitorFile function signature verified at compile time")
-	})
-}
-
 func TestBuildStaleSocketCleanupCommand(t *testing.T) {
 	t.Run("no services", func(t *testing.T) {
 		cmd := buildStaleSocketCleanupCommand(false, false)
@@ -310,21 +301,32 @@ func TestBuildCodespaceBashStdinArgs(t *testing.T) {
 }
 
 func TestBuildCodespacePreparationScript(t *testing.T) {
-	script := buildCodespacePreparationScript(true, true)
+	script := buildCodespacePreparationScript(codespaceSetup{
+		HasBrowserService:      true,
+		HasNotificationService: true,
+		Secret:                 "s3cret",
+		SocketPaths:            []string{"/tmp/ado-auth-1234.sock", "/tmp/gh-ado-browser-5678.sock"},
+		Machine:                "x86_64",
+	})
 
 	expectedSnippets := []string{
 		"set -e\n",
-		"> ~/ado-auth-helper && cp ~/ado-auth-helper ~/azure-auth-helper",
-		"> ~/port-monitor.sh",
+		"| base64 -d | gunzip > ~/.ado-auth-helper.tmp\n",
+		"mv -f ~/.ado-auth-helper.tmp ~/ado-auth-helper && ln -sf ~/ado-auth-helper ~/azure-auth-helper",
+		"ln -sf ~/ado-auth-helper ~/docker-credential-ado",
 		"> ~/browser-opener.sh",
 		"> ~/notification-sender.sh",
 		"> ~/xdg-open.sh",
-		"chmod +x ~/ado-auth-helper ~/azure-auth-helper ~/port-monitor.sh ~/xdg-open.sh ~/browser-opener.sh ~/notification-sender.sh",
+		"chmod +x ~/xdg-open.sh ~/browser-opener.sh ~/notification-sender.sh",
 		"sudo ln -sf ~/ado-auth-helper /usr/local/bin/ado-auth-helper",
 		"sudo ln -sf ~/azure-auth-helper /usr/local/bin/azure-auth-helper",
+		"sudo ln -sf ~/docker-credential-ado /usr/local/bin/docker-credential-ado",
 		"sudo ln -sf ~/xdg-open.sh /usr/local/bin/xdg-open",
 		"/tmp/gh-ado-browser-*.sock",
 		"/tmp/gh-ado-notification-*.sock",
+		"umask 077 && mkdir -p ~/.gh-ado/secrets",
+		"printf %s s3cret > ~/.gh-ado/secrets/ado-auth-1234",
+		"printf %s s3cret > ~/.gh-ado/secrets/gh-ado-browser-5678",
 	}
 
 	for _, snippet := range expectedSnippets {
@@ -334,6 +336,126 @@ func TestBuildCodespacePreparationScript(t *testing.T) {
 	}
 }
 
+func TestBuildCodespacePreparationScript_FeedShims(t *testing.T) {
+	setup := codespaceSetup{Secret: "s3cret", SocketPaths: []string{"/tmp/ado-auth-1234.sock"}}
+	if script := buildCodespacePreparationScript(setup); strings.Contains(script, feedShimDir) {
+		t.Errorf("expected no feed shims unless requested")
+	}
+
+	setup.FeedShims = true
+	script := buildCodespacePreparationScript(setup)
+	for _, tool := range feedShimTools {
+		if want := "ln -sf ~/ado-auth-helper ~/.gh-ado/shims/" + tool; !strings.Contains(script, want) {
+			t.Errorf("expected setup script to contain %q", want)
+		}
+	}
+	for _, rc := range []string{"~/.bashrc", "~/.zshrc"} {
+		if want := "grep -qs '.gh-ado/shims' " + rc; !strings.Contains(script, want) {
+			t.Errorf("expected PATH update for %s to be guarded, got %q", rc, script)
+		}
+	}
+}
+
+func TestBuildCodespacePreparationScript_Reconnect(t *testing.T) {
+	setup := codespaceSetup{Secret: "s3cret", SocketPaths: []string{"/tmp/ado-auth-1234.sock", "/tmp/gh-ado-browser-5678.sock"}}
+	if script := buildCodespacePreparationScript(setup); strings.Contains(script, "rm -f /tmp/ado-auth-1234.sock") {
+		t.Error("expected the first connection to leave sockets alone")
+	}
+
+	setup.Reconnect = true
+	script := buildCodespacePreparationScript(setup)
+	if !strings.Contains(script, "rm -f /tmp/ado-auth-1234.sock /tmp/gh-ado-browser-5678.sock") {
+		t.Errorf("expected reconnection to remove the old sockets, got %q", script)
+	}
+	if !strings.Contains(script, "printf %s s3cret > ~/.gh-ado/secrets/ado-auth-1234") {
+		t.Error("expected reconnection to rewrite the session secrets")
+	}
+}
+
+// runBashScript runs script with bash -s in home, as the codespace setup does.
+func runBashScript(t *testing.T, home, script string) string {
+	t.Helper()
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash is not available")
+	}
+	cmd := exec.Command("bash", "-s")
+	cmd.Stdin = strings.NewReader(script)
+	cmd.Env = append(os.Environ(), "HOME="+home)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("script failed: %v\n%s", err, output)
+	}
+	return string(output)
+}
+
+func TestBuildAuthHelperInstallCommand(t *testing.T) {
+	// The build fails without every helper, so each one is embedded
+	payloads := make(map[string]string)
+	for _, arch := range authHelperArchitectures {
+		gz, err := gzippedAuthHelper(arch.goarch)
+		if err != nil {
+			t.Fatalf("gzippedAuthHelper(%s) error = %v", arch.goarch, err)
+		}
+		payloads[arch.goarch] = base64.StdEncoding.EncodeToString(gz)
+	}
+
+	for machine, goarch := range map[string]string{"x86_64": "amd64", "aarch64": "arm64", "arm64": "arm64"} {
+		command := buildAuthHelperInstallCommand(machine, "")
+		for other, payload := range payloads {
+			if sent := strings.Contains(command, payload); sent != (other == goarch) {
+				t.Errorf("%s: sent the %s helper = %v", machine, other, sent)
+			}
+		}
+	}
+
+	sum, err := authHelperSHA256("amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	command := buildAuthHelperInstallCommand("x86_64", sum)
+	if strings.Contains(command, "base64") || !strings.Contains(command, "ln -sf ~/ado-auth-helper ~/docker-credential-ado") {
+		t.Errorf("expected an installed helper to only be linked again, got %q", command)
+	}
+}
+
+func TestAuthHelperProbe_SkipsInstalledHelper(t *testing.T) {
+	home := t.TempDir()
+	runBashScript(t, home, "set -e\n"+buildAuthHelperInstallCommand("x86_64", "")+"\n")
+
+	// The probe reports the hash of the helper the install wrote
+	machine, installed := parseAuthHelperProbe("Welcome to Codespaces!\n" + runBashScript(t, home, authHelperProbeScript))
+	if machine == "" {
+		t.Error("expected the probe to report the architecture")
+	}
+	if want, _ := authHelperSHA256("amd64"); installed != want {
+		t.Errorf("installed helper sha256 = %q, want %q", installed, want)
+	}
+	if command := buildAuthHelperInstallCommand("x86_64", installed); strings.Contains(command, "base64") {
+		t.Error("expected the installed helper not to be sent again")
+	}
+
+	if _, installed := parseAuthHelperProbe(runBashScript(t, t.TempDir(), authHelperProbeScript)); installed != "" {
+		t.Errorf("expected no hash without an installed helper, got %q", installed)
+	}
+}
+
+func TestBuildAuthHelperInstallCommand_SkipsUnsupportedArchitecture(t *testing.T) {
+	home := t.TempDir()
+
+	// The rest of the preparation script must still run under set -e
+	output := runBashScript(t, home, "set -e\n"+buildAuthHelperInstallCommand("riscv64", "")+"\necho prepared\n")
+	if !strings.Contains(output, "prepared") {
+		t.Errorf("expected the script to continue, got %q", output)
+	}
+	warnings := authHelperWarnings(output)
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "unsupported architecture riscv64") {
+		t.Errorf("authHelperWarnings() = %q", warnings)
+	}
+	if _, err := os.Lstat(filepath.Join(home, "ado-auth-helper")); err == nil {
+		t.Error("expected no helper to be installed")
+	}
+}
+
 // TestGetLogDirectory verifies log directory path generation
 func TestGetLogDirectory(t *testing.T) {
 	logDir := getLogDirectory()
@@ -387,6 +509,26 @@ func TestGetSessionLogDirectory(t *testing.T) {
 	t.Logf("Session log directory: %s", sessionLogDir)
 }
 
+// TestEnsureSessionLogDirectory_IsPrivate verifies other users can't read session logs
+func TestEnsureSessionLogDirectory_IsPrivate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permission bits don't apply on Windows")
+	}
+	t.Setenv("TMPDIR", t.TempDir())
+	initializeSessionID("private-logs")
+
+	if err := ensureSessionLogDirectory(); err != nil {
+		t.Fatalf("ensureSessionLogDirectory() error = %v", err)
+	}
+	info, err := os.Stat(getSessionLogDirectory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o700 {
+		t.Errorf("session log directory mode = %o, want 700", perm)
+	}
+}
+
 // TestGetSessionLogPath verifies log file path generation
 func TestGetSessionLogPath(t *testing.T) {
 	// Initialize a session ID first
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxLatencySamples bounds the latencies kept for percentiles; older samples are dropped.
+const maxLatencySamples = 1024
+
+// tokenMetrics counts what the auth server did during a session, so slow git operations
+// can be traced to token minting. A nil *tokenMetrics ignores everything recorded.
+type tokenMetrics struct {
+	mu          sync.Mutex
+	requests    map[string]int
+	failures    map[string]int
+	cacheHits   int
+	cacheMisses int
+	getToken    latencySamples
+	mint        latencySamples
+}
+
+// latencySamples keeps the most recent durations in a ring buffer.
+type latencySamples struct {
+	samples []time.Duration
+	next    int
+	count   int
+}
+
+// add records d, overwriting the oldest sample once the buffer is full.
+func (l *latencySamples) add(d time.Duration) {
+	l.count++
+	if len(l.samples) < maxLatencySamples {
+		l.samples = append(l.samples, d)
+		return
+	}
+	l.samples[l.next] = d
+	l.next = (l.next + 1) % maxLatencySamples
+}
+
+// stats summarizes the samples with nearest-rank percentiles.
+func (l *latencySamples) stats() LatencyStats {
+	stats := LatencyStats{Count: l.count}
+	if len(l.samples) == 0 {
+		return stats
+	}
+	sorted := slices.Clone(l.samples)
+	slices.Sort(sorted)
+	percentile := func(p int) float64 {
+		rank := (p*len(sorted) + 99) / 100
+		return durationMillis(sorted[max(rank, 1)-1])
+	}
+	stats.P50Ms = percentile(50)
+	stats.P95Ms = percentile(95)
+	stats.MaxMs = durationMillis(sorted[len(sorted)-1])
+	return stats
+}
+
+// durationMillis converts d to fractional milliseconds.
+func durationMillis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// newTokenMetrics returns an empty metrics collector.
+func newTokenMetrics() *tokenMetrics {
+	return &tokenMetrics{
+		requests: make(map[string]int),
+		failures: make(map[string]int),
+	}
+}
+
+// recordRequest counts a token request for scopes.
+func (m *tokenMetrics) recordRequest(scopes []string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[strings.Join(scopes, " ")]++
+}
+
+// recordFailure counts a failed request by its error code.
+func (m *tokenMetrics) recordFailure(code string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures[code]++
+}
+
+// recordCache counts a token cache lookup. Requests that wait on a fetch another
+// request started count as misses.
+func (m *tokenMetrics) recordCache(hit bool) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if hit {
+		m.cacheHits++
+	} else {
+		m.cacheMisses++
+	}
+}
+
+// recordGetToken records how long a request waited for its token, cached or not.
+func (m *tokenMetrics) recordGetToken(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.getToken.add(d)
+}
+
+// recordMint records how long the credential chain took to mint a new token.
+func (m *tokenMetrics) recordMint(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mint.add(d)
+}
+
+// snapshot returns a copy of the current metrics.
+func (m *tokenMetrics) snapshot() *MetricsInfo {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return &MetricsInfo{
+		Requests:    maps.Clone(m.requests),
+		Failures:    maps.Clone(m.failures),
+		CacheHits:   m.cacheHits,
+		CacheMisses: m.cacheMisses,
+		GetToken:    m.getToken.stats(),
+		Mint:        m.mint.stats(),
+	}
+}
+
+// formatMetricsSummary renders metrics as a few lines for the end of a session.
+func formatMetricsSummary(info *MetricsInfo) string {
+	if info == nil {
+		return ""
+	}
+
+	total := 0
+	for _, n := range info.Requests {
+		total += n
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Token requests: %d", total)
+	if total > 0 {
+		fmt.Fprintf(&b, " (%s)", formatCounts(info.Requests))
+	}
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "Token cache:    %d hits, %d misses\n", info.CacheHits, info.CacheMisses)
+	fmt.Fprintf(&b, "GetToken:       %s\n", formatLatency(info.GetToken))
+	fmt.Fprintf(&b, "Minting:        %s\n", formatLatency(info.Mint))
+	if len(info.Failures) == 0 {
+		b.WriteString("Failures:       none\n")
+	} else {
+		fmt.Fprintf(&b, "Failures:       %s\n", formatCounts(info.Failures))
+	}
+	return b.String()
+}
+
+// formatCounts renders counts as "key: n" pairs, largest first.
+func formatCounts(counts map[string]int) string {
+	keys := slices.Collect(maps.Keys(counts))
+	slices.SortFunc(keys, func(a, b string) int {
+		if counts[a] != counts[b] {
+			return counts[b] - counts[a]
+		}
+		return strings.Compare(a, b)
+	})
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, fmt.Sprintf("%s: %d", key, counts[key]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatLatency renders latency stats, e.g. "12 calls, p50 3ms, p95 850ms, max 1.2s".
+func formatLatency(stats LatencyStats) string {
+	if stats.Count == 0 {
+		return "no calls"
+	}
+	ms := func(v float64) string {
+		d := time.Duration(v * float64(time.Millisecond))
+		if d < 10*time.Millisecond {
+			return d.Round(10 * time.Microsecond).String()
+		}
+		return d.Round(time.Millisecond).String()
+	}
+	return fmt.Sprintf("%d calls, p50 %s, p95 %s, max %s", stats.Count, ms(stats.P50Ms), ms(stats.P95Ms), ms(stats.MaxMs))
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+func TestLatencySamples_Percentiles(t *testing.T) {
+	var samples latencySamples
+	for i := 1; i <= 100; i++ {
+		samples.add(time.Duration(i) * time.Millisecond)
+	}
+
+	stats := samples.stats()
+	if stats.Count != 100 || stats.P50Ms != 50 || stats.P95Ms != 95 || stats.MaxMs != 100 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestLatencySamples_KeepsRecentSamples(t *testing.T) {
+	var samples latencySamples
+	for i := 0; i < maxLatencySamples; i++ {
+		samples.add(time.Second)
+	}
+	for i := 0; i < maxLatencySamples; i++ {
+		samples.add(time.Millisecond)
+	}
+
+	stats := samples.stats()
+	if stats.Count != 2*maxLatencySamples {
+		t.Errorf("expected every sample to be counted, got %d", stats.Count)
+	}
+	if stats.MaxMs != 1 {
+		t.Errorf("expected old samples to be dropped, got max %vms", stats.MaxMs)
+	}
+}
+
+func TestTokenMetrics_NilIgnoresRecords(t *testing.T) {
+	var m *tokenMetrics
+	m.recordRequest([]string{defaultADOScope})
+	m.recordFailure(authErrUnavailable)
+	m.recordCache(true)
+	m.recordGetToken(time.Second)
+	m.recordMint(time.Second)
+	if m.snapshot() != nil {
+		t.Error("expected nil metrics to have no snapshot")
+	}
+}
+
+func TestCachingCredential_RecordsMetrics(t *testing.T) {
+	discardAuthLogs(t)
+	cred := &fakeCredential{expiresAt: time.Now().Add(time.Hour)}
+	cache := newCachingCredential(cred)
+	cache.metrics = newTokenMetrics()
+
+	opts := policy.TokenRequestOptions{Scopes: []string{defaultADOScope}}
+	for i := 0; i < 3; i++ {
+		if _, err := cache.GetToken(context.Background(), opts); err != nil {
+			t.Fatalf("GetToken() error = %v", err)
+		}
+	}
+
+	info := cache.metrics.snapshot()
+	if info.CacheHits != 2 || info.CacheMisses != 1 || info.Mint.Count != 1 {
+		t.Errorf("expected 2 hits, 1 miss and 1 mint, got %+v", info)
+	}
+}
+
+func TestHandleConnection_RecordsMetrics(t *testing.T) {
+	cred := &fakeCredential{expiresAt: time.Now().Add(time.Hour)}
+	auth := newTestAuthServer(cred)
+	auth.metrics = newTokenMetrics()
+
+	exchangeAuthMessage(t, auth, `{"secret":"test-secret","type":"getAccessToken","data":{}}`)
+	exchangeAuthMessage(t, auth, `{"secret":"test-secret","type":"getAccessToken","data":{"scopes":"https://graph.microsoft.com/.default"}}`)
+	exchangeAuthMessage(t, auth, `{"secret":"wrong","type":"getAccessToken","data":{}}`)
+
+	resp := exchangeAuthMessage(t, auth, `{"secret":"test-secret","type":"status"}`)
+	data, _ := resp["data"].(map[string]interface{})
+	metrics, ok := data["metrics"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected metrics in status response, got %v", data)
+	}
+
+	requests, _ := metrics["requests"].(map[string]interface{})
+	if requests[defaultADOScope] != float64(1) || requests["https://graph.microsoft.com/.default"] != float64(1) {
+		t.Errorf("unexpected requests by scope %v", requests)
+	}
+	failures, _ := metrics["failures"].(map[string]interface{})
+	if failures[authErrScopeDenied] != float64(1) || failures[authErrUnauthorized] != float64(1) {
+		t.Errorf("unexpected failures %v", failures)
+	}
+	getToken, _ := metrics["getToken"].(map[string]interface{})
+	if getToken["count"] != float64(1) {
+		t.Errorf("expected one GetToken call, got %v", getToken)
+	}
+
+	ping := exchangeAuthMessage(t, auth, `{"secret":"test-secret","type":"ping"}`)
+	if pingData, _ := ping["data"].(map[string]interface{}); pingData["metrics"] != nil {
+		t.Error("ping responses should stay small and skip metrics")
+	}
+}
+
+func TestFormatMetricsSummary(t *testing.T) {
+	m := newTokenMetrics()
+	for i := 0; i < 3; i++ {
+		m.recordRequest([]string{defaultADOScope})
+	}
+	m.recordRequest([]string{acrScope})
+	m.recordFailure(authErrNotLoggedIn)
+	m.recordCache(true)
+	m.recordCache(false)
+	m.recordGetToken(500 * time.Microsecond)
+	m.recordMint(1200 * time.Millisecond)
+
+	summary := formatMetricsSummary(m.snapshot())
+	for _, want := range []string{
+		"Token requests: 4 (" + defaultADOScope + ": 3, " + acrScope + ": 1)",
+		"Token cache:    1 hits, 1 misses",
+		"GetToken:       1 calls, p50 500µs",
+		"Minting:        1 calls, p50 1.2s, p95 1.2s, max 1.2s",
+		"Failures:       not_logged_in: 1",
+	} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("expected summary to contain %q, got:\n%s", want, summary)
+		}
+	}
+
+	if got := formatMetricsSummary(newTokenMetrics().snapshot()); !strings.Contains(got, "Token requests: 0\n") || !strings.Contains(got, "no calls") {
+		t.Errorf("unexpected empty summary:\n%s", got)
+	}
+}
@@ -15,6 +15,20 @@
 #       source "$HOME/notification-sender.sh"
 #   fi
 
+# Succeed if $1 is a socket owned by the current user, not a link to one, so
+# nothing is sent to a socket another user created in /tmp.
+__notification_owned_socket() {
+    [ -S "$1" ] && [ ! -L "$1" ] && [ -O "$1" ]
+}
+
+# Print a curl config line carrying the session secret for the socket in $1.
+# The secret is piped to curl so it never appears in the process list.
+__notification_secret_config() {
+    __notification_owned_socket "$1" || return 1
+    local secret_file="$HOME/.gh-ado/secrets/$(basename "$1" .sock)"
+    [ -r "$secret_file" ] && printf 'header = "X-GH-ADO-Secret: %s"\n' "$(cat "$secret_file")"
+}
+
 # Handle "send" subcommand for direct invocation (e.g., fish done plugin)
 # Usage: ~/notification-sender.sh send "title" "message"
 if [ "${1:-}" = "send" ]; then
@@ -31,9 +45,9 @@ if [ "${1:-}" = "send" ]; then
     fi
 
     # Find the newest notification socket
-    __ns_socket=$(find /tmp -maxdepth 1 -name "gh-ado-notification-*.sock" -type s -exec ls -t {} + 2>/dev/null | head -1)
-    if [ -n "$__ns_socket" ]; then
-        curl -s --max-time 2 --unix-socket "$__ns_socket" -X POST \
+    __ns_socket=$(find /tmp -maxdepth 1 -name "gh-ado-notification-*.sock" -type s -user "$(id -u)" -exec ls -t {} + 2>/dev/null | head -1)
+    if [ -n "$__ns_socket" ] && __notification_owned_socket "$__ns_socket"; then
+        __notification_secret_config "$__ns_socket" | curl -K - -s --max-time 2 --unix-socket "$__ns_socket" -X POST \
             -H "Content-Type: application/json" \
             -d "{\"title\":$(printf %s "$__ns_title" | jq -Rs .), \"message\":$(printf %s "$__ns_message" | jq -Rs .)}" \
             "http://localhost/notify" >/dev/null 2>&1
@@ -65,7 +79,7 @@ __find_notification_socket() {
     # Find all notification sockets in /tmp (pattern: gh-ado-notification-*.sock)
     # Sort by modification time (newest first) to prefer active sockets
     # Use find -exec for better portability across different xargs implementations
-    local NOTIFICATION_SOCKETS=$(find /tmp -maxdepth 1 -name "gh-ado-notification-*.sock" -type s -exec ls -t {} + 2>/dev/null | head -1)
+    local NOTIFICATION_SOCKETS=$(find /tmp -maxdepth 1 -name "gh-ado-notification-*.sock" -type s -user "$(id -u)" -exec ls -t {} + 2>/dev/null | head -1)
     
     if [ -n "$NOTIFICATION_SOCKETS" ]; then
         __notification_socket_cache="$NOTIFICATION_SOCKETS"
@@ -101,7 +115,7 @@ __send_notification() {
         socket_candidates="$__notification_socket_cache"$'\n'
     fi
 
-    local discovered_sockets=$(find /tmp -maxdepth 1 -name "gh-ado-notification-*.sock" -type s -exec ls -t {} + 2>/dev/null)
+    local discovered_sockets=$(find /tmp -maxdepth 1 -name "gh-ado-notification-*.sock" -type s -user "$(id -u)" -exec ls -t {} + 2>/dev/null)
     if [ -n "$discovered_sockets" ]; then
         socket_candidates="${socket_candidates}${discovered_sockets}"
     fi
@@ -114,10 +128,12 @@ __send_notification() {
     local NOTIFICATION_SOCKET
     while IFS= read -r NOTIFICATION_SOCKET; do
         [ -z "$NOTIFICATION_SOCKET" ] && continue
+        __notification_owned_socket "$NOTIFICATION_SOCKET" || continue
 
         # Send notification to the socket via HTTP POST using curl with --unix-socket
-        # --max-time 2 ensures we fail fast on dead sockets
-        if curl -s --max-time 2 --unix-socket "$NOTIFICATION_SOCKET" -X POST \
+        # --max-time 2 ensures we fail fast on dead sockets, and -f skips sockets
+        # that reject our secret (e.g. another user's session)
+        if __notification_secret_config "$NOTIFICATION_SOCKET" | curl -K - -sf --max-time 2 --unix-socket "$NOTIFICATION_SOCKET" -X POST \
             -H "Content-Type: application/json" \
             -d "{\"title\":$(printf %s "$title" | jq -Rs .), \"message\":$(printf %s "$message" | jq -Rs .)}" \
             "http://localhost/notify" >/dev/null 2>&1; then
@@ -32,7 +32,7 @@ type NotificationRequest struct {
 
 // NotificationService manages the notification service
 type NotificationService struct {
-	Port       int
+	Local      localEndpoint
 	SocketPath string
 	server     *http.Server
 	listener   net.Listener
@@ -41,27 +41,25 @@ type NotificationService struct {
 	wg         sync.WaitGroup
 }
 
-// NewNotificationService creates and starts a new notification service
-func NewNotificationService(ctx context.Context) (*NotificationService, error) {
-	// Create a local TCP listener for notification requests
-	listener, err := net.Listen("tcp", "127.0.0.1:0")
+// NewNotificationService creates and starts a new notification service.
+// Requests must carry secret in the session secret header.
+func NewNotificationService(ctx context.Context, secret string) (*NotificationService, error) {
+	// Create a private local listener for notification requests
+	listener, local, err := listenLocalService("notification")
 	if err != nil {
 		return nil, fmt.Errorf("failed to create local listener: %w", err)
 	}
 
-	// Get the actual port that was assigned
-	notificationPort := listener.Addr().(*net.TCPAddr).Port
-
 	// Generate a unique socket path for remote forwarding
 	socketId := uuid.New()
 	socketPath := "/tmp/gh-ado-notification-" + socketId.String() + ".sock"
 
-	logDebug("Local notification HTTP service created on port: %d, socket path: %s", notificationPort, socketPath)
+	logDebug("Local notification HTTP service created on %s, socket path: %s", local, socketPath)
 
 	serviceCtx, cancel := context.WithCancel(ctx)
 
 	service := &NotificationService{
-		Port:       notificationPort,
+		Local:      local,
 		SocketPath: socketPath,
 		listener:   listener,
 		ctx:        serviceCtx,
@@ -70,7 +68,7 @@ func NewNotificationService(ctx context.Context) (*NotificationService, error) {
 
 	// Create HTTP handler
 	mux := http.NewServeMux()
-	mux.HandleFunc("/notify", service.handleNotification)
+	mux.HandleFunc("/notify", requireSessionSecret(secret, service.handleNotification))
 
 	// Create HTTP server
 	service.server = &http.Server{
@@ -89,7 +87,7 @@ func (ns *NotificationService) serve() {
 	defer ns.wg.Done()
 	defer ns.listener.Close()
 
-	logDebug("Notification HTTP service starting on port %d", ns.Port)
+	logDebug("Notification HTTP service starting on %s", ns.Local)
 
 	err := ns.server.Serve(ns.listener)
 	if err != nil && err != http.ErrServerClosed {
@@ -124,6 +122,18 @@ func (ns *NotificationService) handleNotification(w http.ResponseWriter, r *http
 		return
 	}
 
+	if err := ns.Notify(req.Title, req.Message); err != nil {
+		http.Error(w, "Failed to send notification", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// Notify shows a desktop notification on the local machine. It is used for requests
+// from the codespace and for problems the extension itself wants to surface.
+func (ns *NotificationService) Notify(title, message string) error {
 	// Enforce reasonable length limits on title and message to avoid issues with
 	// desktop notification systems that may not handle very long text well.
 	const maxTitleLen = 100
@@ -144,22 +154,20 @@ func (ns *NotificationService) handleNotification(w http.ResponseWriter, r *http
 		return string(runes[:max-3]) + "..."
 	}
 
-	req.Title = truncateWithEllipsis(req.Title, maxTitleLen)
-	req.Message = truncateWithEllipsis(req.Message, maxMessageLen)
+	title = truncateWithEllipsis(title, maxTitleLen)
+	message = truncateWithEllipsis(message, maxMessageLen)
 
-	logDebug("Sending notification: title=%s, message=%s", req.Title, req.Message)
+	logDebug("Sending notification: title=%s, message=%s", title, message)
 
 	// Send the notification using beeep with an embedded icon so supported
 	// platforms avoid the default host icon when possible.
-	if err := desktopNotify(req.Title, req.Message, notificationIcon); err != nil {
+	if err := desktopNotify(title, message, notificationIcon); err != nil {
 		logDebug("Error sending notification: %v", err)
-		http.Error(w, "Failed to send notification", http.StatusInternalServerError)
-		return
+		return err
 	}
 
 	logDebug("Successfully sent notification")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
+	return nil
 }
 
 // Stop stops the notification service
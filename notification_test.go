@@ -4,9 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
 	"image/png"
-	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -18,14 +16,14 @@ func TestNewNotificationService(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	service, err := NewNotificationService(ctx)
+	service, err := NewNotificationService(ctx, testSessionSecret)
 	if err != nil {
 		t.Fatalf("Failed to create notification service: %v", err)
 	}
 	defer service.Stop()
 
-	if service.Port == 0 {
-		t.Error("Notification service port should not be 0")
+	if service.Local.Address == "" {
+		t.Error("Notification service local address should not be empty")
 	}
 
 	if service.SocketPath == "" {
@@ -50,7 +48,7 @@ func TestNotificationServiceHandlesHTTPRequest(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	service, err := NewNotificationService(ctx)
+	service, err := NewNotificationService(ctx, testSessionSecret)
 	if err != nil {
 		t.Fatalf("Failed to create notification service: %v", err)
 	}
@@ -69,8 +67,8 @@ func TestNotificationServiceHandlesHTTPRequest(t *testing.T) {
 		t.Fatalf("Failed to marshal JSON: %v", err)
 	}
 
-	resp, err := http.Post(
-		fmt.Sprintf("http://localhost:%d/notify", service.Port),
+	resp, err := localServiceClient(service.Local, testSessionSecret).Post(
+		"http://localhost/notify",
 		"application/json",
 		bytes.NewBuffer(jsonData),
 	)
@@ -92,7 +90,7 @@ func TestNotificationServiceStop(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	service, err := NewNotificationService(ctx)
+	service, err := NewNotificationService(ctx, testSessionSecret)
 	if err != nil {
 		t.Fatalf("Failed to create notification service: %v", err)
 	}
@@ -102,7 +100,7 @@ func TestNotificationServiceStop(t *testing.T) {
 
 	// Try to connect - should fail
 	time.Sleep(100 * time.Millisecond)
-	_, err = net.Dial("tcp", fmt.Sprintf("localhost:%d", service.Port))
+	_, err = service.Local.DialContext(context.Background())
 	if err == nil {
 		t.Error("Expected connection to fail after service stop, but it succeeded")
 	}
@@ -112,17 +110,17 @@ func TestBuildSSHArgsWithNotificationService(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	service, err := NewNotificationService(ctx)
+	service, err := NewNotificationService(ctx, testSessionSecret)
 	if err != nil {
 		t.Fatalf("Failed to create notification service: %v", err)
 	}
 	defer service.Stop()
 
 	args := CommandLineArgs{}
-	sshArgs := args.BuildSSHArgs("/tmp/test.sock", 8080, nil, service)
+	sshArgs := args.BuildSSHArgs("/tmp/test.sock", testAuthEndpoint, nil, service)
 
 	// Verify notification socket forward is included.
-	expectedForward := fmt.Sprintf("%s:%s:%d", service.SocketPath, localServiceHost, service.Port)
+	expectedForward := service.SocketPath + ":" + service.Local.Address
 	foundForward := false
 	for i := 0; i < len(sshArgs)-1; i++ {
 		if sshArgs[i] == "-R" && sshArgs[i+1] == expectedForward {
@@ -138,7 +136,7 @@ func TestBuildSSHArgsWithNotificationService(t *testing.T) {
 
 func TestBuildSSHArgsWithoutNotificationService(t *testing.T) {
 	args := CommandLineArgs{}
-	sshArgs := args.BuildSSHArgs("/tmp/test.sock", 8080, nil, nil)
+	sshArgs := args.BuildSSHArgs("/tmp/test.sock", testAuthEndpoint, nil, nil)
 
 	// Verify no notification-specific port forwards are included when service is nil
 	for i := 0; i < len(sshArgs)-1; i++ {
@@ -155,7 +153,7 @@ func TestNotificationHTTPEndpointMethodValidation(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	service, err := NewNotificationService(ctx)
+	service, err := NewNotificationService(ctx, testSessionSecret)
 	if err != nil {
 		t.Fatalf("Failed to create notification service: %v", err)
 	}
@@ -165,7 +163,7 @@ func TestNotificationHTTPEndpointMethodValidation(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 
 	// Test that GET requests are rejected
-	resp, err := http.Get(fmt.Sprintf("http://localhost:%d/notify", service.Port))
+	resp, err := localServiceClient(service.Local, testSessionSecret).Get("http://localhost/notify")
 	if err != nil {
 		t.Fatalf("Failed to send GET request: %v", err)
 	}
@@ -180,7 +178,7 @@ func TestNotificationHTTPEndpointMissingTitle(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	service, err := NewNotificationService(ctx)
+	service, err := NewNotificationService(ctx, testSessionSecret)
 	if err != nil {
 		t.Fatalf("Failed to create notification service: %v", err)
 	}
@@ -198,8 +196,8 @@ func TestNotificationHTTPEndpointMissingTitle(t *testing.T) {
 		t.Fatalf("Failed to marshal JSON: %v", err)
 	}
 
-	resp, err := http.Post(
-		fmt.Sprintf("http://localhost:%d/notify", service.Port),
+	resp, err := localServiceClient(service.Local, testSessionSecret).Post(
+		"http://localhost/notify",
 		"application/json",
 		bytes.NewBuffer(jsonData),
 	)
@@ -217,7 +215,7 @@ func TestNotificationHTTPEndpointMissingMessage(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	service, err := NewNotificationService(ctx)
+	service, err := NewNotificationService(ctx, testSessionSecret)
 	if err != nil {
 		t.Fatalf("Failed to create notification service: %v", err)
 	}
@@ -235,8 +233,8 @@ func TestNotificationHTTPEndpointMissingMessage(t *testing.T) {
 		t.Fatalf("Failed to marshal JSON: %v", err)
 	}
 
-	resp, err := http.Post(
-		fmt.Sprintf("http://localhost:%d/notify", service.Port),
+	resp, err := localServiceClient(service.Local, testSessionSecret).Post(
+		"http://localhost/notify",
 		"application/json",
 		bytes.NewBuffer(jsonData),
 	)
@@ -254,7 +252,7 @@ func TestNotificationHTTPEndpointInvalidJSON(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	service, err := NewNotificationService(ctx)
+	service, err := NewNotificationService(ctx, testSessionSecret)
 	if err != nil {
 		t.Fatalf("Failed to create notification service: %v", err)
 	}
@@ -264,8 +262,8 @@ func TestNotificationHTTPEndpointInvalidJSON(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 
 	// Test that requests with invalid JSON are rejected
-	resp, err := http.Post(
-		fmt.Sprintf("http://localhost:%d/notify", service.Port),
+	resp, err := localServiceClient(service.Local, testSessionSecret).Post(
+		"http://localhost/notify",
 		"application/json",
 		bytes.NewBufferString("not valid json"),
 	)
@@ -283,7 +281,7 @@ func TestNotificationTruncation(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	service, err := NewNotificationService(ctx)
+	service, err := NewNotificationService(ctx, testSessionSecret)
 	if err != nil {
 		t.Fatalf("Failed to create notification service: %v", err)
 	}
@@ -305,8 +303,8 @@ func TestNotificationTruncation(t *testing.T) {
 		t.Fatalf("Failed to marshal JSON: %v", err)
 	}
 
-	resp, err := http.Post(
-		fmt.Sprintf("http://localhost:%d/notify", service.Port),
+	resp, err := localServiceClient(service.Local, testSessionSecret).Post(
+		"http://localhost/notify",
 		"application/json",
 		bytes.NewBuffer(jsonData),
 	)
@@ -384,3 +382,28 @@ func TestNotificationHandlerUsesEmbeddedIcon(t *testing.T) {
 		t.Fatal("Expected handler to pass embedded notification icon bytes")
 	}
 }
+
+func TestNotificationHTTPEndpointRequiresSessionSecret(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	service, err := NewNotificationService(ctx, testSessionSecret)
+	if err != nil {
+		t.Fatalf("Failed to create notification service: %v", err)
+	}
+	defer service.Stop()
+
+	resp, err := localServiceClient(service.Local, "").Post(
+		"http://localhost/notify",
+		"application/json",
+		strings.NewReader(`{"title":"Test","message":"Test"}`),
+	)
+	if err != nil {
+		t.Fatalf("Failed to send POST request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status %d without a secret, got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cli/go-gh/v2"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// sshKeepaliveInterval is how often the forwarding connection is checked, so a
+	// dropped connection ends the port watcher and the monitor reconnects.
+	sshKeepaliveInterval = 15 * time.Second
+	// sshProxyExitTimeout bounds the wait for the gh proxy to exit after its
+	// connection is closed.
+	sshProxyExitTimeout = 2 * time.Second
+)
+
+// portForwarder forwards ports of the codespace to local ports.
+type portForwarder interface {
+	// Forward listens on localPort and forwards its connections to port in the
+	// codespace. The returned function stops the forward.
+	Forward(ctx context.Context, port, localPort int) (func(), error)
+}
+
+// ghPortForwarder runs one 'gh codespace ports forward' process per port. It is used
+// when the codespace can't be reached with the built-in SSH client.
+type ghPortForwarder struct {
+	codespaceName string
+}
+
+// Forward starts a gh process forwarding port, which is killed to stop the forward.
+func (f ghPortForwarder) Forward(ctx context.Context, port, localPort int) (func(), error) {
+	cmd := startPortForwarding(ctx, f.codespaceName, port, localPort)
+	return func() {
+		if cmd.Process == nil {
+			return
+		}
+		if err := cmd.Process.Kill(); err != nil {
+			logDebug("Failed to kill port forwarding for port %d: %v", port, err)
+		}
+	}, nil
+}
+
+// sshPortForwarder forwards ports over an SSH connection to the codespace, opening a
+// direct-tcpip channel per connection, so adding a forward doesn't start a process.
+type sshPortForwarder struct {
+	client *ssh.Client
+}
+
+// Forward listens on localPort on the loopback interface.
+func (f sshPortForwarder) Forward(ctx context.Context, port, localPort int) (func(), error) {
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", localPort))
+	if err != nil {
+		return nil, err
+	}
+	forward := &sshForward{
+		listener: ln,
+		remote:   net.JoinHostPort("localhost", strconv.Itoa(port)),
+		conns:    make(map[net.Conn]struct{}),
+	}
+	go forward.serve(f.client)
+	return forward.close, nil
+}
+
+// sshForward is one forwarded port and its open connections.
+type sshForward struct {
+	listener net.Listener
+	remote   string
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// serve accepts local connections until the forward is closed.
+func (f *sshForward) serve(client *ssh.Client) {
+	for {
+		local, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(client, local)
+	}
+}
+
+// handle copies a local connection to the codespace port and back.
+func (f *sshForward) handle(client *ssh.Client, local net.Conn) {
+	remote, err := client.Dial("tcp", f.remote)
+	if err != nil {
+		logDebug("Failed to connect to %s in the codespace: %v", f.remote, err)
+		local.Close()
+		return
+	}
+	if !f.track(local, remote) {
+		local.Close()
+		remote.Close()
+		return
+	}
+	defer f.untrack(local, remote)
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, local)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(local, remote)
+		done <- struct{}{}
+	}()
+	// Either side closing ends the connection
+	<-done
+	local.Close()
+	remote.Close()
+	<-done
+}
+
+// track records the connections so close can end them. It reports false once the
+// forward is closed.
+func (f *sshForward) track(conns ...net.Conn) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return false
+	}
+	for _, conn := range conns {
+		f.conns[conn] = struct{}{}
+	}
+	return true
+}
+
+// untrack forgets connections that have ended.
+func (f *sshForward) untrack(conns ...net.Conn) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, conn := range conns {
+		delete(f.conns, conn)
+	}
+}
+
+// close stops listening and ends the open connections.
+func (f *sshForward) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return
+	}
+	f.closed = true
+	f.listener.Close()
+	for conn := range f.conns {
+		conn.Close()
+	}
+}
+
+// codespaceSSHConfig is the part of 'gh codespace ssh --config' the SSH client needs.
+type codespaceSSHConfig struct {
+	User         string
+	IdentityFile string
+}
+
+// parseCodespaceSSHConfig reads the user and identity file from the ssh_config
+// printed by 'gh codespace ssh --config'.
+func parseCodespaceSSHConfig(text string) (codespaceSSHConfig, error) {
+	var cfg codespaceSSHConfig
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// Keywords are separated from their value by whitespace or '='
+		end := strings.IndexAny(line, " \t=")
+		if end < 0 {
+			continue
+		}
+		key := strings.ToLower(line[:end])
+		value := strings.Trim(strings.TrimLeft(line[end:], " \t="), `"`)
+		switch key {
+		case "user":
+			if cfg.User == "" {
+				cfg.User = value
+			}
+		case "identityfile":
+			if cfg.IdentityFile == "" {
+				cfg.IdentityFile = value
+			}
+		}
+	}
+	if cfg.User == "" || cfg.IdentityFile == "" {
+		return cfg, errors.New("no user or identity file in the codespace's ssh config")
+	}
+	return cfg, nil
+}
+
+// codespaceSSH is an SSH connection to a codespace, tunnelled through
+// 'gh codespace ssh --stdio'.
+type codespaceSSH struct {
+	client *ssh.Client
+	proxy  *exec.Cmd
+	conn   *stdioConn
+	exited chan struct{}
+	once   sync.Once
+}
+
+// dialCodespaceSSH connects to the codespace's SSH server with the key gh set up for
+// it. The connection is closed when ctx is canceled.
+func dialCodespaceSSH(ctx context.Context, codespaceName string) (*codespaceSSH, error) {
+	stdout, stderr, err := gh.ExecContext(ctx, "codespace", "ssh", "--codespace", codespaceName, "--config")
+	if err != nil {
+		return nil, fmt.Errorf("error reading ssh config: %w\nStderr: %s", err, stderr.String())
+	}
+	cfg, err := parseCodespaceSSHConfig(stdout.String())
+	if err != nil {
+		return nil, err
+	}
+	key, err := os.ReadFile(cfg.IdentityFile)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", cfg.IdentityFile, err)
+	}
+
+	// The same proxy 'gh codespace ssh --config' sets up for ssh
+	proxy := exec.Command("gh", "codespace", "ssh", "--codespace", codespaceName, "--stdio", "--", "-i", cfg.IdentityFile)
+	conn, err := startStdioConn(proxy)
+	if err != nil {
+		return nil, err
+	}
+	cs := &codespaceSSH{proxy: proxy, conn: conn, exited: make(chan struct{})}
+	go func() {
+		proxy.Wait()
+		close(cs.exited)
+	}()
+
+	// The handshake has no context; closing the proxy ends it
+	handshakeDone := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-handshakeDone:
+		}
+	}()
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, "codespace", &ssh.ClientConfig{
+		User: cfg.User,
+		Auth: []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		// gh authenticates the codespace when it opens the tunnel and turns off host
+		// key checking for ssh too
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	close(handshakeDone)
+	if err != nil {
+		cs.Close()
+		return nil, fmt.Errorf("ssh handshake: %w", err)
+	}
+	cs.client = ssh.NewClient(sshConn, chans, reqs)
+
+	go cs.keepalive(ctx)
+	return cs, nil
+}
+
+// keepalive closes the connection when ctx is canceled or the codespace stops
+// answering.
+func (cs *codespaceSSH) keepalive(ctx context.Context) {
+	ticker := time.NewTicker(sshKeepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			cs.Close()
+			return
+		case <-cs.exited:
+			cs.Close()
+			return
+		case <-ticker.C:
+			if _, _, err := cs.client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+				logDebug("SSH keepalive failed: %v", err)
+				cs.Close()
+				return
+			}
+		}
+	}
+}
+
+// Close closes the connection and waits for the proxy to exit.
+func (cs *codespaceSSH) Close() {
+	cs.once.Do(func() {
+		if cs.client != nil {
+			cs.client.Close()
+		}
+		cs.stopProxy()
+	})
+}
+
+// stopProxy waits for the proxy to exit after its stdin closed, and kills it if it
+// doesn't.
+func (cs *codespaceSSH) stopProxy() {
+	cs.conn.Close()
+	select {
+	case <-cs.exited:
+	case <-time.After(sshProxyExitTimeout):
+		cs.proxy.Process.Kill()
+		<-cs.exited
+	}
+}
+
+// stdioConn is a net.Conn over the stdin and stdout of a process.
+type stdioConn struct {
+	io.Reader
+	io.WriteCloser
+}
+
+// startStdioConn starts cmd and returns a connection to its stdin and stdout. Its
+// stderr goes to the debug log.
+func startStdioConn(cmd *exec.Cmd) (*stdioConn, error) {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			logDebug("SSH proxy: %s", scanner.Text())
+		}
+	}()
+	return &stdioConn{Reader: stdout, WriteCloser: stdin}, nil
+}
+
+func (c *stdioConn) LocalAddr() net.Addr                { return stdioAddr{} }
+func (c *stdioConn) RemoteAddr() net.Addr               { return stdioAddr{} }
+func (c *stdioConn) SetDeadline(t time.Time) error      { return nil }
+func (c *stdioConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *stdioConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// stdioAddr is the address of both ends of a stdioConn.
+type stdioAddr struct{}
+
+func (stdioAddr) Network() string { return "stdio" }
+func (stdioAddr) String() string  { return "stdio" }
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseCodespaceSSHConfig(t *testing.T) {
+	config := `Host cs.fuzzy-space.main
+	User codespace
+	ProxyCommand /usr/bin/gh cs ssh -c fuzzy-space --stdio -- -i /home/me/.ssh/codespaces.auto
+	UserKnownHostsFile=/dev/null
+	StrictHostKeyChecking no
+	IdentityFile "/home/me/my keys/codespaces.auto"
+`
+	cfg, err := parseCodespaceSSHConfig(config)
+	if err != nil {
+		t.Fatalf("parseCodespaceSSHConfig() error = %v", err)
+	}
+	if cfg.User != "codespace" || cfg.IdentityFile != "/home/me/my keys/codespaces.auto" {
+		t.Errorf("parseCodespaceSSHConfig() = %+v", cfg)
+	}
+
+	if _, err := parseCodespaceSSHConfig("Host cs.fuzzy-space.main\n\tUser codespace\n"); err == nil {
+		t.Error("expected an error without an identity file")
+	}
+}
+
+// startTestSSHServer serves one SSH connection that accepts direct-tcpip channels,
+// and returns a client connected to it.
+func startTestSSHServer(t *testing.T) *ssh.Client {
+	t.Helper()
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(signer)
+
+	// Both ends write their version first, which net.Pipe can't buffer
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		serverSide, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		_, chans, reqs, err := ssh.NewServerConn(serverSide, serverConfig)
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		for newChannel := range chans {
+			if newChannel.ChannelType() != "direct-tcpip" {
+				newChannel.Reject(ssh.UnknownChannelType, "unsupported")
+				continue
+			}
+			var target struct {
+				Host       string
+				Port       uint32
+				OriginHost string
+				OriginPort uint32
+			}
+			if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+				newChannel.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			upstream, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+			if err != nil {
+				newChannel.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				upstream.Close()
+				continue
+			}
+			go ssh.DiscardRequests(requests)
+			go func() {
+				io.Copy(channel, upstream)
+				channel.Close()
+			}()
+			go func() {
+				io.Copy(upstream, channel)
+				upstream.Close()
+			}()
+		}
+	}()
+
+	clientSide, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, chans, reqs, err := ssh.NewClientConn(clientSide, "codespace", &ssh.ClientConfig{
+		User:            "codespace",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatalf("NewClientConn() error = %v", err)
+	}
+	client := ssh.NewClient(conn, chans, reqs)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestSSHPortForwarder_Forward(t *testing.T) {
+	client := startTestSSHServer(t)
+
+	// An echo server stands in for the dev server in the codespace
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	localPort := free.Addr().(*net.TCPAddr).Port
+	free.Close()
+
+	stop, err := sshPortForwarder{client: client}.Forward(context.Background(), echo.Addr().(*net.TCPAddr).Port, localPort)
+	if err != nil {
+		t.Fatalf("Forward() error = %v", err)
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(localPort)))
+	if err != nil {
+		t.Fatalf("dial forwarded port: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("read %q, %v through the forward, want ping", buf, err)
+	}
+
+	stop()
+	if _, err := conn.Read(buf); err == nil {
+		t.Error("expected the open connection to be closed when the forward stops")
+	}
+	if c, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(localPort))); err == nil {
+		c.Close()
+		t.Error("expected the local port to be closed when the forward stops")
+	}
+	stop() // stopping twice is harmless
+}
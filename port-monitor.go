@@ -4,17 +4,20 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"time"
 
-	"github.com/cli/go-gh/v2"
+	"golang.org/x/crypto/ssh"
 )
 
 // Global variables for debug logging
@@ -32,7 +35,7 @@ func initDebugLogger() error {
 
 	logPath := getSessionLogPath("port-monitor.log")
 
-	logFile, err := os.Create(logPath)
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
 	if err != nil {
 		return fmt.Errorf("failed to create log file: %w", err)
 	}
@@ -68,19 +71,92 @@ func closeDebugLogger() {
 	}
 }
 
-//go:embed port-monitor.sh
-var portMonitorScript string
+// portWatchCommand is the remote command that reports listening ports in the codespace.
+const portWatchCommand = "~/ado-auth-helper watch-ports"
 
-// PortMessage represents a JSON message from port-monitor.sh
+// PortMessage represents a JSON port event from 'ado-auth-helper watch-ports'. The
+// process fields are empty when the owner of the socket isn't known.
 type PortMessage struct {
 	Type      string `json:"type"`
 	Action    string `json:"action"`
 	Port      int    `json:"port"`
 	Protocol  string `json:"protocol"`
+	Address   string `json:"address,omitempty"`
+	PID       int    `json:"pid,omitempty"`
+	Process   string `json:"process,omitempty"`
+	Command   string `json:"command,omitempty"`
+	Cwd       string `json:"cwd,omitempty"`
 	Timestamp string `json:"timestamp"`
 }
 
-// LogMessage represents a JSON log message from port-monitor.sh
+// interpreters are process names that run a script, so the script names the program.
+var interpreters = []string{"node", "bun", "deno", "python", "python3", "ruby", "java", "dotnet", "php", "perl"}
+
+// Label names the program listening on the port, e.g. "node (vite)", or "" when the
+// owner isn't known.
+func (m PortMessage) Label() string {
+	if m.Process == "" || !slices.Contains(interpreters, m.Process) {
+		return m.Process
+	}
+	if script := scriptName(m.Command); script != "" {
+		return fmt.Sprintf("%s (%s)", m.Process, script)
+	}
+	return m.Process
+}
+
+// Describe renders the port and its owner for logs, e.g.
+// "5173 by node (vite), pid 4242 in /workspaces/app".
+func (m PortMessage) Describe() string {
+	label := m.Label()
+	if label == "" {
+		return fmt.Sprint(m.Port)
+	}
+	description := fmt.Sprintf("%d by %s, pid %d", m.Port, label, m.PID)
+	if m.Cwd != "" {
+		description += " in " + m.Cwd
+	}
+	return description
+}
+
+// scriptName returns the base name of the first argument after the interpreter that
+// isn't an option, such as the script or module being run.
+func scriptName(command string) string {
+	fields := strings.Fields(command)
+	for i := 1; i < len(fields); i++ {
+		arg := fields[i]
+		switch {
+		case arg == "-m" && i+1 < len(fields):
+			// python -m http.server
+			return fields[i+1]
+		case strings.HasPrefix(arg, "-"):
+			continue
+		default:
+			return filepath.Base(arg)
+		}
+	}
+	return ""
+}
+
+// debugLineWriter writes each line written to it to the debug log.
+type debugLineWriter struct {
+	prefix  string
+	partial []byte
+}
+
+func (w *debugLineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		line, rest, found := bytes.Cut(w.partial, []byte("\n"))
+		if !found {
+			break
+		}
+		logDebug("%s%s", w.prefix, bytes.TrimRight(line, "\r"))
+		w.partial = rest
+	}
+	return len(p), nil
+}
+
+// LogMessage represents a JSON log message from 'ado-auth-helper watch-ports'.
 type LogMessage struct {
 	Type      string `json:"type"`
 	Message   string `json:"message"`
@@ -110,15 +186,54 @@ func (pmc *PortMonitorController) Wait() {
 	}
 }
 
+// PortMonitorOptions configures StartPortMonitor.
+type PortMonitorOptions struct {
+	// Reconnect restarts the port watcher whenever its SSH connection drops.
+	Reconnect bool
+	// Rules decide which ports are forwarded and to which local ports, as merged by
+	// MergePortForwardRules.
+	Rules []PortForwardRule
+	// LocalPortStrategy picks another local port when a forward's is in use.
+	LocalPortStrategy string
+	// Notify, if set, shows a desktop notification when a port is forwarded to another
+	// local port.
+	Notify func(title, message string) error
+}
+
+// portForwarding is what the port monitor needs to start forwards.
+type portForwarding struct {
+	policy    *portForwardPolicy
+	strategy  string
+	available func(port int) bool
+	notify    func(title, message string) error
+	forwarder portForwarder
+}
+
 // portForwardInfo tracks information about a port forwarding process
 type portForwardInfo struct {
-	active bool
-	cmd    *exec.Cmd
+	active    bool
+	stop      func() // stops the forward
+	label     string // the program listening on the port, if known
+	localPort int    // the local end of the forward when it differs from the port
+}
+
+// name describes a forwarded port for logs, e.g. "5173 (node (vite))" or
+// "3000 -> 13000".
+func (info portForwardInfo) name(port int) string {
+	name := fmt.Sprint(port)
+	if info.localPort != 0 && info.localPort != port {
+		name = fmt.Sprintf("%d -> %d", port, info.localPort)
+	}
+	if info.label != "" {
+		name += fmt.Sprintf(" (%s)", info.label)
+	}
+	return name
 }
 
-// StartPortMonitor runs the port monitor script on the specified codespace
+// StartPortMonitor runs the port watcher on the specified codespace and forwards the
+// ports it reports as opts allows.
 // It returns a PortMonitorController to manage the lifecycle of the monitor and an error if setup fails.
-func StartPortMonitor(ctx context.Context, codespaceName string) (*PortMonitorController, error) {
+func StartPortMonitor(ctx context.Context, codespaceName string, opts PortMonitorOptions) (*PortMonitorController, error) {
 	// Initialize the debug logger
 	if err := initDebugLogger(); err != nil {
 		return nil, fmt.Errorf("failed to initialize debug logger: %w", err)
@@ -130,6 +245,18 @@ func StartPortMonitor(ctx context.Context, codespaceName string) (*PortMonitorCo
 
 	logDebug("Starting port monitor for codespace: %s", codespaceName)
 
+	strategy, err := parseLocalPortStrategy(opts.LocalPortStrategy)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v; using %q\n", err, localPortStrategyOffset)
+		strategy = localPortStrategyOffset
+	}
+	forwarding := &portForwarding{
+		policy:    newPortForwardPolicy(opts.Rules),
+		strategy:  strategy,
+		available: isLocalPortAvailable,
+		notify:    opts.Notify,
+	}
+
 	// Create a new context with cancellation for the monitor itself
 	monitorCtx, cancelMonitor := context.WithCancel(ctx)
 
@@ -144,7 +271,7 @@ func StartPortMonitor(ctx context.Context, codespaceName string) (*PortMonitorCo
 		defer closeDebugLogger()
 
 		logDebug("Port monitor goroutine started.")
-		err := runPortMonitor(monitorCtx, codespaceName)
+		err := runPortMonitor(monitorCtx, codespaceName, opts.Reconnect, forwarding)
 		if err != nil && err != context.Canceled && !strings.Contains(err.Error(), "context canceled") {
 			logDebug("Error in port monitor: %v", err)
 		} else {
@@ -161,41 +288,101 @@ func StartPortMonitor(ctx context.Context, codespaceName string) (*PortMonitorCo
 	return controller, nil
 }
 
-// runPortMonitor handles the actual port monitoring logic
-func runPortMonitor(ctx context.Context, codespaceName string) error {
-	return runAndProcessOutput(ctx, codespaceName)
-}
+// runPortMonitor handles the actual port monitoring logic. With reconnect it restarts
+// the watcher with backoff until ctx is canceled; ports the watcher reports again when
+// it restarts are forwarded again. The watcher is only given up on when it fails
+// right away, such as when it isn't installed.
+func runPortMonitor(ctx context.Context, codespaceName string, reconnect bool, forwarding *portForwarding) error {
+	if !reconnect {
+		return runAndProcessOutput(ctx, codespaceName, forwarding)
+	}
 
-// uploadPortMonitorFile copies the port-monitor.sh script to the codespace
-func uploadPortMonitorFile(ctx context.Context, codespaceName string) error {
-	// Create a temporary file with the embedded script content
-	tempFile, err := os.CreateTemp("", "port-monitor*.sh")
-	if err != nil {
-		return fmt.Errorf("failed to create temporary file: %w", err)
+	backoff := newReconnectBackoff()
+	for attempt := 0; ; attempt++ {
+		started := time.Now()
+		err := runAndProcessOutput(ctx, codespaceName, forwarding)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		elapsed := time.Since(started)
+		if !shouldReconnectPersistent(err, attempt, elapsed) {
+			logDebug("Port monitor exited (%v); not restarting", err)
+			return err
+		}
+		if elapsed >= reconnectStableDuration {
+			backoff.reset()
+		}
+		delay := backoff.delay()
+		logDebug("Port monitor exited (%v); restarting in %s", err, delay)
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
 	}
-	defer os.Remove(tempFile.Name())
+}
+
+// portWatch is a running port watcher and the forwarder for the ports it reports.
+type portWatch struct {
+	stdout    io.Reader
+	wait      func() error
+	stop      func()
+	forwarder portForwarder
+}
 
-	// Write the embedded script to the temporary file
-	if _, err = tempFile.WriteString(portMonitorScript); err != nil {
-		return fmt.Errorf("failed to write script to temporary file: %w", err)
+// startPortWatch starts the port watcher over an SSH connection that also carries
+// the forwards. When the built-in SSH client can't connect, the watcher runs in
+// 'gh codespace ssh' and each port is forwarded by its own gh process.
+func startPortWatch(ctx context.Context, codespaceName string) (*portWatch, error) {
+	conn, err := dialCodespaceSSH(ctx, codespaceName)
+	if err == nil {
+		watch, err := startSSHPortWatch(conn)
+		if err == nil {
+			logDebug("Port watcher and forwards share one SSH connection")
+			return watch, nil
+		}
+		conn.Close()
+		logDebug("Failed to start the port watcher over SSH: %v", err)
+	} else if ctx.Err() != nil {
+		return nil, ctx.Err()
+	} else {
+		logDebug("Built-in SSH client unavailable, forwarding with gh processes: %v", err)
 	}
-	tempFile.Close()
+	return startGHPortWatch(ctx, codespaceName)
+}
 
-	// Use gh cs cp to copy the script to the codespace
-	// Note: We use gh.Exec here because we just need the final result and don't need to track the process
-	args := []string{"codespace", "cp", "-c", codespaceName, "-e", tempFile.Name(), "remote:~/port-monitor.sh"}
-	_, stderr, err := gh.Exec(args...)
+// startSSHPortWatch runs the port watcher in a session of conn.
+func startSSHPortWatch(conn *codespaceSSH) (*portWatch, error) {
+	session, err := conn.client.NewSession()
 	if err != nil {
-		return fmt.Errorf("error copying script to codespace: %w\nStderr: %s", err, stderr.String())
+		return nil, err
 	}
-
-	return nil
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	session.Stderr = &debugLineWriter{prefix: "Port Monitor Error: "}
+	if err := session.Start(portWatchCommand); err != nil {
+		return nil, err
+	}
+	wait := func() error {
+		err := session.Wait()
+		if err != nil && !errors.As(err, new(*ssh.ExitError)) {
+			// The channel closed without an exit status
+			return fmt.Errorf("%w: %w", errConnectionLost, err)
+		}
+		return err
+	}
+	return &portWatch{
+		stdout:    stdout,
+		wait:      wait,
+		stop:      conn.Close,
+		forwarder: sshPortForwarder{client: conn.client},
+	}, nil
 }
 
-// runAndProcessOutput runs the port-monitor.sh script and processes its output
-func runAndProcessOutput(ctx context.Context, codespaceName string) error {
-	// Start the port-monitor.sh script on the codespace
-	args := []string{"codespace", "ssh", "--codespace", codespaceName, "--", "~/port-monitor.sh"}
+// startGHPortWatch runs the port watcher with 'gh codespace ssh'.
+func startGHPortWatch(ctx context.Context, codespaceName string) (*portWatch, error) {
+	// Start the port watcher installed with the auth helper
+	args := []string{"codespace", "ssh", "--codespace", codespaceName, "--", portWatchCommand}
 
 	// Note: We use exec.CommandContext instead of gh.Exec here because:
 	// 1. We need to process the JSON output line-by-line as it's produced in real-time
@@ -204,26 +391,48 @@ func runAndProcessOutput(ctx context.Context, codespaceName string) error {
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return fmt.Errorf("failed to get stdout pipe: %w", err)
+		return nil, fmt.Errorf("failed to get stdout pipe: %w", err)
 	}
 
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return fmt.Errorf("failed to get stderr pipe: %w", err)
-	}
+	// Keep the end of stderr to tell a dropped connection from the watcher failing
+	output := &tailBuffer{max: maxSessionErrorOutput}
+	cmd.Stderr = io.MultiWriter(&debugLineWriter{prefix: "Port Monitor Error: "}, output)
 
 	// Start the command
 	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start command: %w", err)
+		return nil, fmt.Errorf("failed to start command: %w", err)
 	}
 
-	// Process stderr in a goroutine
-	go func() {
-		scanner := bufio.NewScanner(stderr)
-		for scanner.Scan() {
-			logDebug("Port Monitor Error: %s", scanner.Text())
+	wait := func() error {
+		if err := cmd.Wait(); err != nil {
+			return &sessionError{err: err, output: output.String()}
 		}
-	}()
+		return nil
+	}
+	return &portWatch{
+		stdout: stdout,
+		wait:   wait,
+		stop: func() {
+			if cmd.Process != nil {
+				cmd.Process.Kill()
+			}
+		},
+		forwarder: ghPortForwarder{codespaceName: codespaceName},
+	}, nil
+}
+
+// runAndProcessOutput runs the port watcher in the codespace and processes its output
+func runAndProcessOutput(ctx context.Context, codespaceName string, forwarding *portForwarding) error {
+	watch, err := startPortWatch(ctx, codespaceName)
+	if err != nil {
+		return err
+	}
+	stdout := watch.stdout
+
+	// The forwards of this run go over its connection
+	run := *forwarding
+	run.forwarder = watch.forwarder
+	forwarding = &run
 
 	// Map to track active forwarded ports and their associated commands
 	portForwards := make(map[int]portForwardInfo)
@@ -283,7 +492,7 @@ func runAndProcessOutput(ctx context.Context, codespaceName string) error {
 				}
 
 				// Process port message
-				handlePortMessage(forwardingCtx, codespaceName, portMsg, portForwards)
+				handlePortMessage(forwardingCtx, codespaceName, portMsg, forwarding, portForwards)
 
 			case "log":
 				var logMsg LogMessage
@@ -304,82 +513,130 @@ func runAndProcessOutput(ctx context.Context, codespaceName string) error {
 	// Wait for either the command to finish or the context to be canceled
 	waitErrCh := make(chan error, 1)
 	go func() {
-		waitErrCh <- cmd.Wait()
+		waitErrCh <- watch.wait()
 	}()
 
 	select {
 	case <-ctx.Done():
 		// Context was canceled, clean up and return
 		logDebug("Context canceled, cleaning up port monitor")
-		// Try to kill the process if it's still running
-		if cmd.Process != nil {
-			cmd.Process.Kill()
-		}
+		// Stop the watcher if it's still running
+		watch.stop()
 		<-done // Wait for stdout processing to complete
 		return ctx.Err()
 	case err := <-waitErrCh:
-		// Command finished
+		// Watcher finished
 		<-done // Wait for stdout processing to complete
+		watch.stop()
 		return err
 	}
 }
 
 // handlePortMessage processes a port event message from the script
-func handlePortMessage(ctx context.Context, codespaceName string, msg PortMessage, portForwards map[int]portForwardInfo) {
+func handlePortMessage(ctx context.Context, codespaceName string, msg PortMessage, forwarding *portForwarding, portForwards map[int]portForwardInfo) {
 	switch msg.Action {
 	case "bound":
 		// Skip ports that are being reverse-forwarded from the local machine
 		if IsReverseForwardedPort(msg.Port) {
-			logDebug("Port %d is a reverse-forwarded port, skipping port forwarding", msg.Port)
+			logDebug("Port %s is a reverse-forwarded port, skipping port forwarding", msg.Describe())
 			return
 		}
 
 		// If not already forwarded or if previously unbound, start port forwarding
 		info := portForwards[msg.Port]
 		if !info.active {
-			logDebug("Port %d bound, starting port forwarding", msg.Port)
-			cmd := startPortForwarding(ctx, codespaceName, msg.Port)
-			portForwards[msg.Port] = portForwardInfo{active: true, cmd: cmd}
+			forward, localPort := forwarding.policy.decide(msg)
+			if !forward {
+				logDebug("Port %s bound on %s, skipped by port forward rules", msg.Describe(), msg.Address)
+				return
+			}
+
+			// Forwards that are still starting don't listen yet, so check them too
+			available := func(port int) bool {
+				return !usesLocalPort(portForwards, port) && forwarding.available(port)
+			}
+			if !available(localPort) {
+				wanted := localPort
+				var err error
+				if localPort, err = pickLocalPort(wanted, forwarding.strategy, available); err != nil {
+					logDebug("Port %s bound, but local port %d is in use: %v", msg.Describe(), wanted, err)
+					return
+				}
+				forwarding.announceRemap(codespaceName, msg, wanted, localPort)
+			}
+
+			info = portForwardInfo{active: true, label: msg.Label(), localPort: localPort}
+			logDebug("Port %s bound, starting port forwarding", info.name(msg.Port))
+			stop, err := forwarding.forwarder.Forward(ctx, msg.Port, localPort)
+			if err != nil {
+				logDebug("Failed to forward port %s: %v", info.name(msg.Port), err)
+				return
+			}
+			info.stop = stop
+			portForwards[msg.Port] = info
 		}
 
 	case "unbound":
 		// Get the port forwarding info
 		info := portForwards[msg.Port]
-		if info.active && info.cmd != nil && info.cmd.Process != nil {
-			logDebug("Port %d unbound, stopping port forwarding", msg.Port)
-			// Kill the port forwarding process
-			if err := info.cmd.Process.Kill(); err != nil {
-				logDebug("Failed to kill port forwarding for port %d: %v", msg.Port, err)
-			} else {
-				logDebug("Stopped port forwarding for port %d", msg.Port)
-			}
+		if info.active && info.stop != nil {
+			logDebug("Port %s unbound, stopping port forwarding", msg.Describe())
+			info.stop()
+			logDebug("Stopped port forwarding for port %d", msg.Port)
 		}
 		// Mark the port as inactive but keep the entry in the map to remember we've seen it
-		portForwards[msg.Port] = portForwardInfo{active: false, cmd: nil}
+		portForwards[msg.Port] = portForwardInfo{active: false}
 	}
 }
 
-// cleanupPortForwards stops all active port forwarding processes
+// usesLocalPort reports whether an active forward listens on localPort.
+func usesLocalPort(portForwards map[int]portForwardInfo, localPort int) bool {
+	for port, info := range portForwards {
+		if info.active && (info.localPort == localPort || (info.localPort == 0 && port == localPort)) {
+			return true
+		}
+	}
+	return false
+}
+
+// announceRemap tells the user that a port is forwarded to another local port than
+// the one it wanted, on stderr and as a desktop notification.
+func (f *portForwarding) announceRemap(codespaceName string, msg PortMessage, wanted, localPort int) {
+	port := fmt.Sprint(msg.Port)
+	if label := msg.Label(); label != "" {
+		port += fmt.Sprintf(" (%s)", label)
+	}
+	message := fmt.Sprintf("Local port %d is in use, so port %s of %s is forwarded to localhost:%d", wanted, port, codespaceName, localPort)
+	logDebug("%s", message)
+
+	// The terminal is in raw mode while the remote shell runs, so end the line with \r\n
+	fmt.Fprintf(os.Stderr, "%s\r\n", message)
+	if f.notify != nil {
+		if err := f.notify(fmt.Sprintf("Port %d forwarded to localhost:%d", msg.Port, localPort), message); err != nil {
+			logDebug("Failed to notify about the local port of %d: %v", msg.Port, err)
+		}
+	}
+}
+
+// cleanupPortForwards stops all active port forwards
 func cleanupPortForwards(portForwards map[int]portForwardInfo) {
-	logDebug("Cleaning up %d port forwarding processes", len(portForwards))
+	logDebug("Cleaning up %d port forwards", len(portForwards))
 	for port, info := range portForwards {
-		if info.active && info.cmd != nil && info.cmd.Process != nil {
-			logDebug("Terminating port forwarding for port %d", port)
-			if err := info.cmd.Process.Kill(); err != nil {
-				logDebug("Error terminating port forwarding process for port %d: %v", port, err)
-			}
+		if info.active && info.stop != nil {
+			logDebug("Stopping port forwarding for port %s", info.name(port))
+			info.stop()
 		}
 	}
 }
 
-// startPortForwarding starts port forwarding for the specified port
+// startPortForwarding forwards localPort on this machine to port in the codespace.
 // Returns the command being executed for tracking purposes
 // Note: We use exec.CommandContext instead of gh.Exec here because:
 // 1. We need a reference to the process to kill it later when the port is unbound
 // 2. Port forwarding is a long-running process that needs to run asynchronously
-func startPortForwarding(ctx context.Context, codespaceName string, port int) *exec.Cmd {
+func startPortForwarding(ctx context.Context, codespaceName string, port, localPort int) *exec.Cmd {
 	// Construct command args
-	args := []string{"codespace", "ports", "forward", fmt.Sprintf("%d:%d", port, port), "--codespace", codespaceName}
+	args := []string{"codespace", "ports", "forward", fmt.Sprintf("%d:%d", port, localPort), "--codespace", codespaceName}
 
 	// Create the command with the provided context for proper cancellation
 	cmd := exec.CommandContext(ctx, "gh", args...)
@@ -390,7 +647,7 @@ func startPortForwarding(ctx context.Context, codespaceName string, port int) *e
 	cmd.Stderr = &stderr
 
 	// Log that we're starting port forwarding
-	logDebug("Starting port forwarding for port %d on codespace %s", port, codespaceName)
+	logDebug("Starting port forwarding for port %d to local port %d on codespace %s", port, localPort, codespaceName)
 
 	// Start the command asynchronously
 	go func() {
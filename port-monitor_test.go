@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPortMessage_ProcessFields(t *testing.T) {
+	line := `{"type":"port","action":"bound","port":5173,"protocol":"tcp","pid":4242,"process":"node","command":"node /workspaces/app/node_modules/.bin/vite --port 5173","cwd":"/workspaces/app","timestamp":"2024-05-01T12:00:00.000Z"}`
+
+	var msg PortMessage
+	if err := json.Unmarshal([]byte(line), &msg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if msg.PID != 4242 || msg.Process != "node" || msg.Cwd != "/workspaces/app" {
+		t.Errorf("PortMessage = %+v, want the owning process", msg)
+	}
+	if got, want := msg.Describe(), "5173 by node (vite), pid 4242 in /workspaces/app"; got != want {
+		t.Errorf("Describe() = %q, want %q", got, want)
+	}
+}
+
+func TestPortMessage_Label(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  PortMessage
+		want string
+	}{
+		{name: "unknown owner", msg: PortMessage{Port: 3000}, want: ""},
+		{name: "native program", msg: PortMessage{Process: "redis-server", Command: "redis-server *:6379"}, want: "redis-server"},
+		{name: "node script", msg: PortMessage{Process: "node", Command: "node --inspect=9229 dist/server.js"}, want: "node (server.js)"},
+		{name: "python module", msg: PortMessage{Process: "python3", Command: "python3 -m http.server 8080"}, want: "python3 (http.server)"},
+		{name: "interpreter without script", msg: PortMessage{Process: "node", Command: "node"}, want: "node"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.msg.Label(); got != tt.want {
+				t.Errorf("Label() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if got := (PortMessage{Port: 3000}).Describe(); got != "3000" {
+		t.Errorf("Describe() without an owner = %q, want %q", got, "3000")
+	}
+}
+
+// fakeForwarder records the forwards handlePortMessage starts and stops.
+type fakeForwarder struct {
+	started [][2]int
+	stopped []int
+}
+
+func (f *fakeForwarder) Forward(ctx context.Context, port, localPort int) (func(), error) {
+	f.started = append(f.started, [2]int{port, localPort})
+	return func() { f.stopped = append(f.stopped, port) }, nil
+}
+
+func TestHandlePortMessage_ForwardsAndStops(t *testing.T) {
+	forwarder := &fakeForwarder{}
+	forwarding := &portForwarding{available: func(int) bool { return true }, forwarder: forwarder}
+	portForwards := make(map[int]portForwardInfo)
+
+	handlePortMessage(context.Background(), "fuzzy-space", PortMessage{Action: "bound", Port: 3000}, forwarding, portForwards)
+	handlePortMessage(context.Background(), "fuzzy-space", PortMessage{Action: "bound", Port: 8080}, forwarding, portForwards)
+	handlePortMessage(context.Background(), "fuzzy-space", PortMessage{Action: "unbound", Port: 3000}, forwarding, portForwards)
+
+	if len(forwarder.started) != 2 || len(forwarder.stopped) != 1 || forwarder.stopped[0] != 3000 {
+		t.Fatalf("started %v and stopped %v, want 3000 and 8080 started and 3000 stopped", forwarder.started, forwarder.stopped)
+	}
+
+	cleanupPortForwards(portForwards)
+	if len(forwarder.stopped) != 2 || forwarder.stopped[1] != 8080 {
+		t.Errorf("stopped %v after cleanup, want 8080 stopped too", forwarder.stopped)
+	}
+}
+
+func TestHandlePortMessage_RemapsBusyLocalPort(t *testing.T) {
+	forwarder := &fakeForwarder{}
+	var notified []string
+	forwarding := &portForwarding{
+		strategy:  localPortStrategyOffset,
+		available: func(port int) bool { return port != 3000 },
+		notify: func(title, message string) error {
+			notified = append(notified, message)
+			return nil
+		},
+		forwarder: forwarder,
+	}
+	portForwards := make(map[int]portForwardInfo)
+
+	handlePortMessage(context.Background(), "fuzzy-space", PortMessage{Action: "bound", Port: 3000, Process: "node", Command: "node server.js"}, forwarding, portForwards)
+	// 4000 is free locally but the forward of 3000 is about to listen on it
+	handlePortMessage(context.Background(), "fuzzy-space", PortMessage{Action: "bound", Port: 4000}, forwarding, portForwards)
+
+	if started := forwarder.started; len(started) != 2 || started[0] != [2]int{3000, 4000} || started[1] != [2]int{4000, 5000} {
+		t.Fatalf("started forwards %v, want 3000 -> 4000 and 4000 -> 5000", started)
+	}
+	if got := portForwards[3000].localPort; got != 4000 {
+		t.Errorf("recorded local port of 3000 = %d, want 4000", got)
+	}
+	if len(notified) != 2 || !strings.Contains(notified[0], "port 3000 (node (server.js)) of fuzzy-space is forwarded to localhost:4000") {
+		t.Errorf("notifications = %q, want one per remapped port", notified)
+	}
+}
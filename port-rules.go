@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Port forwarding actions accepted in PortForwardRule.Action.
+const (
+	portActionForward = "forward"
+	portActionSkip    = "skip"
+)
+
+// Bind filters accepted in PortForwardRule.Bind besides a literal IP address.
+const (
+	portBindLoopback = "loopback" // 127.0.0.0/8 or ::1, reachable only from the codespace
+	portBindWildcard = "wildcard" // 0.0.0.0 or ::, reachable on every interface
+)
+
+// PortForwardRule decides whether a port bound in the codespace is forwarded. A rule
+// matches a port when every matcher it sets matches; a rule without matchers matches
+// every port.
+type PortForwardRule struct {
+	// Ports lists ports and ranges, e.g. "3000", "3000-3999" or "5173,8000-8099".
+	Ports string `json:"ports,omitempty"`
+	// Process is a glob matched against the owning process name and the script it runs,
+	// e.g. "node", "vite" or "*-language-server".
+	Process string `json:"process,omitempty"`
+	// Bind is "loopback", "wildcard" or an IP address the port is bound to.
+	Bind string `json:"bind,omitempty"`
+	// Action is "forward" (the default) or "skip".
+	Action string `json:"action,omitempty"`
+	// LocalPort forwards the port to another local port. With a range in Ports, ports
+	// keep their offset from the start of the range.
+	LocalPort int `json:"localPort,omitempty"`
+}
+
+// RepositoryConfig captures per-repository configuration.
+type RepositoryConfig struct {
+	PortForwardRules []PortForwardRule `json:"portForwardRules,omitempty"`
+}
+
+// MergePortForwardRules concatenates rule lists in order, so rules from later lists
+// win over earlier ones for the same port. Invalid rules are skipped with a warning.
+func MergePortForwardRules(lists ...[]PortForwardRule) []PortForwardRule {
+	var merged []PortForwardRule
+	for _, rules := range lists {
+		for _, rule := range rules {
+			rule.Ports = strings.TrimSpace(rule.Ports)
+			rule.Process = strings.TrimSpace(rule.Process)
+			rule.Bind = strings.ToLower(strings.TrimSpace(rule.Bind))
+			rule.Action = strings.ToLower(strings.TrimSpace(rule.Action))
+			if rule.Action == "" {
+				rule.Action = portActionForward
+			}
+			if err := validatePortForwardRule(rule); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: skipping port forward rule %+v: %v\n", rule, err)
+				continue
+			}
+			merged = append(merged, rule)
+		}
+	}
+	return merged
+}
+
+// validatePortForwardRule reports why a normalized rule can't be applied.
+func validatePortForwardRule(rule PortForwardRule) error {
+	ranges, err := parsePortRanges(rule.Ports)
+	if err != nil {
+		return err
+	}
+	if rule.Process != "" {
+		if _, err := path.Match(rule.Process, ""); err != nil {
+			return fmt.Errorf("invalid process pattern %q", rule.Process)
+		}
+	}
+	switch rule.Bind {
+	case "", portBindLoopback, portBindWildcard:
+	default:
+		if net.ParseIP(rule.Bind) == nil {
+			return fmt.Errorf("invalid bind %q", rule.Bind)
+		}
+	}
+	switch rule.Action {
+	case portActionForward, portActionSkip:
+	default:
+		return fmt.Errorf("unknown action %q", rule.Action)
+	}
+	if rule.LocalPort != 0 {
+		if rule.Action != portActionForward {
+			return fmt.Errorf("localPort needs the %q action", portActionForward)
+		}
+		if len(ranges) != 1 {
+			return fmt.Errorf("localPort needs a single port or range in ports")
+		}
+		if rule.LocalPort < 1 || rule.LocalPort+ranges[0].last-ranges[0].first > 65535 {
+			return fmt.Errorf("localPort %d is out of range", rule.LocalPort)
+		}
+	}
+	return nil
+}
+
+// portRange is an inclusive range of ports.
+type portRange struct {
+	first, last int
+}
+
+// parsePortRanges parses a comma-separated list of ports and ranges. An empty list
+// matches every port and parses to no ranges.
+func parsePortRanges(spec string) ([]portRange, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	var ranges []portRange
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		firstText, lastText, isRange := strings.Cut(part, "-")
+		first, err := parsePortNumber(firstText)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ports %q", spec)
+		}
+		last := first
+		if isRange {
+			if last, err = parsePortNumber(lastText); err != nil || last < first {
+				return nil, fmt.Errorf("invalid ports %q", spec)
+			}
+		}
+		ranges = append(ranges, portRange{first: first, last: last})
+	}
+	return ranges, nil
+}
+
+// parsePortNumber parses a port between 1 and 65535.
+func parsePortNumber(text string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", text)
+	}
+	return port, nil
+}
+
+// portForwardPolicy applies port forward rules to port events.
+type portForwardPolicy struct {
+	rules  []PortForwardRule
+	ranges [][]portRange
+}
+
+// newPortForwardPolicy compiles rules merged by MergePortForwardRules into a policy.
+func newPortForwardPolicy(rules []PortForwardRule) *portForwardPolicy {
+	policy := &portForwardPolicy{rules: rules}
+	for _, rule := range rules {
+		ranges, _ := parsePortRanges(rule.Ports)
+		policy.ranges = append(policy.ranges, ranges)
+	}
+	return policy
+}
+
+// decide returns whether msg's port is forwarded and the local port to forward it to.
+// The last matching rule wins; ports no rule matches are forwarded to the same port.
+// A nil policy forwards every port.
+func (p *portForwardPolicy) decide(msg PortMessage) (bool, int) {
+	if p == nil {
+		return true, msg.Port
+	}
+	for i := len(p.rules) - 1; i >= 0; i-- {
+		rule := p.rules[i]
+		start, ok := matchPortRanges(p.ranges[i], msg.Port)
+		if !ok || !matchPortProcess(rule.Process, msg) || !matchPortBind(rule.Bind, msg.Address) {
+			continue
+		}
+		if rule.Action == portActionSkip {
+			return false, 0
+		}
+		if rule.LocalPort != 0 {
+			return true, rule.LocalPort + msg.Port - start
+		}
+		return true, msg.Port
+	}
+	return true, msg.Port
+}
+
+// matchPortRanges reports whether port is in ranges and the start of its range. No
+// ranges match every port.
+func matchPortRanges(ranges []portRange, port int) (int, bool) {
+	if len(ranges) == 0 {
+		return port, true
+	}
+	for _, r := range ranges {
+		if port >= r.first && port <= r.last {
+			return r.first, true
+		}
+	}
+	return 0, false
+}
+
+// matchPortProcess reports whether pattern matches the process that owns the port or
+// the script it runs. Ports whose owner isn't known only match an empty pattern.
+func matchPortProcess(pattern string, msg PortMessage) bool {
+	if pattern == "" {
+		return true
+	}
+	for _, name := range []string{msg.Process, scriptName(msg.Command)} {
+		if name == "" {
+			continue
+		}
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPortBind reports whether a port bound to address matches a bind filter. Ports
+// whose address isn't known only match an empty filter.
+func matchPortBind(bind, address string) bool {
+	if bind == "" {
+		return true
+	}
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	switch bind {
+	case portBindLoopback:
+		return ip.IsLoopback()
+	case portBindWildcard:
+		return ip.IsUnspecified()
+	default:
+		return ip.Equal(net.ParseIP(bind))
+	}
+}
+
+// repositoryPortForwardRules returns the rules of the repositories entries matching
+// repository ("owner/name"), case-insensitively. Glob entries such as "owner/*" come
+// first in key order, so the exact entry wins.
+func repositoryPortForwardRules(repositories map[string]RepositoryConfig, repository string) [][]PortForwardRule {
+	repository = strings.ToLower(strings.TrimSpace(repository))
+	if repository == "" || len(repositories) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(repositories))
+	for key := range repositories {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		iExact := !strings.ContainsAny(keys[i], "*?[")
+		jExact := !strings.ContainsAny(keys[j], "*?[")
+		if iExact != jExact {
+			return jExact
+		}
+		return keys[i] < keys[j]
+	})
+
+	var lists [][]PortForwardRule
+	for _, key := range keys {
+		pattern := strings.ToLower(strings.TrimSpace(key))
+		if matched, err := path.Match(pattern, repository); err == nil && matched {
+			lists = append(lists, repositories[key].PortForwardRules)
+		}
+	}
+	return lists
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestMergePortForwardRules(t *testing.T) {
+	merged := MergePortForwardRules(
+		[]PortForwardRule{{Ports: " 3000-3999 ", Action: "SKIP"}, {Ports: "abc"}},
+		[]PortForwardRule{
+			{Ports: "3000", Bind: "Loopback"},
+			{Ports: "4000-3000"},
+			{Ports: "3000,4000", LocalPort: 13000},
+			{Ports: "3000", Action: portActionSkip, LocalPort: 13000},
+			{Ports: "65000-65535", LocalPort: 65001},
+			{Ports: "65000-65535", LocalPort: 100},
+			{Process: "[", Action: portActionSkip},
+			{Bind: "localhost"},
+			{Action: "block"},
+		},
+	)
+
+	want := []PortForwardRule{
+		{Ports: "3000-3999", Action: portActionSkip},
+		{Ports: "3000", Bind: portBindLoopback, Action: portActionForward},
+		{Ports: "65000-65535", Action: portActionForward, LocalPort: 100},
+	}
+	if !reflect.DeepEqual(merged, want) {
+		t.Errorf("MergePortForwardRules() = %+v, want %+v", merged, want)
+	}
+}
+
+func TestPortForwardPolicy_Decide(t *testing.T) {
+	policy := newPortForwardPolicy(MergePortForwardRules([]PortForwardRule{
+		{Ports: "5000-5999", Action: portActionSkip},
+		{Ports: "5173"},
+		{Process: "*-language-server", Action: portActionSkip},
+		{Process: "vite", Ports: "5173", LocalPort: 15173},
+		{Ports: "8000-8099", LocalPort: 18000},
+		{Ports: "9229", Bind: portBindLoopback, Action: portActionSkip},
+		{Ports: "7000", Bind: "10.0.0.5", Action: portActionSkip},
+	}))
+
+	tests := []struct {
+		name        string
+		msg         PortMessage
+		wantForward bool
+		wantLocal   int
+	}{
+		{name: "no matching rule", msg: PortMessage{Port: 3000}, wantForward: true, wantLocal: 3000},
+		{name: "skipped range", msg: PortMessage{Port: 5500}, wantForward: false},
+		{name: "later rule wins", msg: PortMessage{Port: 5173}, wantForward: true, wantLocal: 5173},
+		{name: "process glob", msg: PortMessage{Port: 4000, Process: "yaml-language-server"}, wantForward: false},
+		{name: "script name", msg: PortMessage{Port: 5173, Process: "node", Command: "node node_modules/.bin/vite"}, wantForward: true, wantLocal: 15173},
+		{name: "unknown process", msg: PortMessage{Port: 4000}, wantForward: true, wantLocal: 4000},
+		{name: "range remap keeps offset", msg: PortMessage{Port: 8042}, wantForward: true, wantLocal: 18042},
+		{name: "loopback bind", msg: PortMessage{Port: 9229, Address: "127.0.0.1"}, wantForward: false},
+		{name: "wildcard bind", msg: PortMessage{Port: 9229, Address: "::"}, wantForward: true, wantLocal: 9229},
+		{name: "unknown bind", msg: PortMessage{Port: 9229}, wantForward: true, wantLocal: 9229},
+		{name: "literal bind", msg: PortMessage{Port: 7000, Address: "10.0.0.5"}, wantForward: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forward, local := policy.decide(tt.msg)
+			if forward != tt.wantForward || (forward && local != tt.wantLocal) {
+				t.Errorf("decide() = %v, %d, want %v, %d", forward, local, tt.wantForward, tt.wantLocal)
+			}
+		})
+	}
+
+	if forward, local := (*portForwardPolicy)(nil).decide(PortMessage{Port: 3000}); !forward || local != 3000 {
+		t.Errorf("nil policy decide() = %v, %d, want every port forwarded", forward, local)
+	}
+}
+
+func TestRepositoryPortForwardRules(t *testing.T) {
+	repositories := map[string]RepositoryConfig{
+		"contoso/web":  {PortForwardRules: []PortForwardRule{{Ports: "1"}}},
+		"contoso/*":    {PortForwardRules: []PortForwardRule{{Ports: "2"}}},
+		"*/*":          {PortForwardRules: []PortForwardRule{{Ports: "3"}}},
+		"fabrikam/web": {PortForwardRules: []PortForwardRule{{Ports: "4"}}},
+	}
+
+	var ports []string
+	for _, rules := range repositoryPortForwardRules(repositories, "Contoso/Web") {
+		ports = append(ports, rules[0].Ports)
+	}
+	if want := []string{"3", "2", "1"}; !reflect.DeepEqual(ports, want) {
+		t.Errorf("repositoryPortForwardRules() ports = %v, want %v", ports, want)
+	}
+
+	if lists := repositoryPortForwardRules(repositories, ""); lists != nil {
+		t.Errorf("expected no rules without a repository, got %v", lists)
+	}
+}
+
+func TestHandlePortMessage_SkippedByRules(t *testing.T) {
+	forwarding := &portForwarding{policy: newPortForwardPolicy(MergePortForwardRules([]PortForwardRule{{Ports: "9229", Action: portActionSkip}}))}
+	portForwards := make(map[int]portForwardInfo)
+
+	handlePortMessage(context.Background(), "codespace", PortMessage{Action: "bound", Port: 9229}, forwarding, portForwards)
+
+	if info := portForwards[9229]; info.active || info.stop != nil {
+		t.Errorf("expected port 9229 not to be forwarded, got %+v", info)
+	}
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"fmt"
+	"math/rand/v2"
 	"net"
 	"os"
+	"strings"
 )
 
 // ReversePortForward represents a reverse port forward configuration
@@ -59,6 +61,80 @@ func isPortBound(port int) bool {
 	return true
 }
 
+// Strategies accepted in AppConfig.LocalPortStrategy for picking another local port
+// when the one a forward wants is in use.
+const (
+	localPortStrategyOffset = "offset" // the port plus 1000, 2000, ... (the default)
+	localPortStrategyNext   = "next"   // the next free port above it
+	localPortStrategyRandom = "random" // a random free port
+)
+
+const (
+	// localPortOffset is the step of the offset strategy, so 3000 becomes 4000.
+	localPortOffset = 1000
+	// minRandomLocalPort keeps random local ports above the ones dev servers use.
+	minRandomLocalPort = 10000
+	// maxRandomLocalPortTries bounds the random strategy before it gives up.
+	maxRandomLocalPortTries = 100
+)
+
+// parseLocalPortStrategy normalizes a configured strategy. An empty strategy is the
+// offset strategy.
+func parseLocalPortStrategy(strategy string) (string, error) {
+	switch strategy = strings.ToLower(strings.TrimSpace(strategy)); strategy {
+	case "":
+		return localPortStrategyOffset, nil
+	case localPortStrategyOffset, localPortStrategyNext, localPortStrategyRandom:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("unknown local port strategy %q", strategy)
+	}
+}
+
+// isLocalPortAvailable reports whether a forward could listen on port on this machine.
+// It checks for a listener first, since some platforms let a loopback listener share a
+// port with a wildcard one.
+func isLocalPortAvailable(port int) bool {
+	if isPortBound(port) {
+		return false
+	}
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return false
+	}
+	ln.Close()
+	return true
+}
+
+// pickLocalPort returns another local port for a forward that wants port, following
+// strategy. available reports whether a port can be used.
+func pickLocalPort(port int, strategy string, available func(int) bool) (int, error) {
+	switch strategy {
+	case localPortStrategyRandom:
+		for range maxRandomLocalPortTries {
+			candidate := minRandomLocalPort + rand.IntN(65536-minRandomLocalPort)
+			if candidate != port && available(candidate) {
+				return candidate, nil
+			}
+		}
+	case localPortStrategyOffset:
+		for candidate := port + localPortOffset; candidate <= 65535; candidate += localPortOffset {
+			if available(candidate) {
+				return candidate, nil
+			}
+		}
+		// Ports near the top of the range have no offset left; look above them instead
+		fallthrough
+	default:
+		for candidate := port + 1; candidate <= 65535; candidate++ {
+			if available(candidate) {
+				return candidate, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("no free local port for %d", port)
+}
+
 // GetBoundReverseForwards returns a list of ports that should be reverse forwarded
 // based on what's currently bound on the local machine or marked as AlwaysForward
 func GetBoundReverseForwards() []ReversePortForward {
@@ -353,7 +353,7 @@ func TestBuildSSHArgsWithReverseForwards(t *testing.T) {
 
 	// Build SSH args
 	args := CommandLineArgs{}
-	sshArgs := args.BuildSSHArgs("/tmp/test.sock", 8080, nil, nil)
+	sshArgs := args.BuildSSHArgs("/tmp/test.sock", testAuthEndpoint, nil, nil)
 
 	// Verify the test port is included
 	expectedForward := fmt.Sprintf("%d:localhost:%d", testPort, testPort)
@@ -380,7 +380,7 @@ func TestPrepareCodespaceScripts(t *testing.T) {
 	t.Run("function_signature", func(t *testing.T) {
 		// Verify function exists and has correct signature
 		// by attempting to reference it (compilation check)
-		var f func(context.Context, string, bool, bool) error = prepareCodespaceScripts
+		var f func(context.Context, string, codespaceSetup) error = prepareCodespaceScripts
 		if f == nil {
 			t.Error("prepareCodespaceScripts function should be defined")
 		}
@@ -395,7 +395,7 @@ func TestConsolidatedChmodCall(t *testing.T) {
 	expectedFiles := []string{
 		"~/ado-auth-helper",
 		"~/azure-auth-helper",
-		"~/port-monitor.sh",
+		"~/xdg-open.sh",
 		"~/browser-opener.sh",
 	}
 
@@ -418,7 +418,7 @@ func TestSSHArgsWithUserArguments(t *testing.T) {
 	args := CommandLineArgs{
 		RemainingArgs: []string{"-L", "3000:localhost:3000", "echo", "test"},
 	}
-	sshArgs := args.BuildSSHArgs("/tmp/test.sock", 8080, nil, nil)
+	sshArgs := args.BuildSSHArgs("/tmp/test.sock", testAuthEndpoint, nil, nil)
 	// Verify user args are at the end
 	if len(sshArgs) < 4 {
 		t.Fatal("Not enough SSH args")
@@ -453,3 +453,61 @@ func TestSSHArgsWithUserArguments(t *testing.T) {
 
 	t.Logf("User args correctly appended: %v", remainingArgs)
 }
+
+func TestParseLocalPortStrategy(t *testing.T) {
+	for input, want := range map[string]string{"": localPortStrategyOffset, " Next ": localPortStrategyNext, "random": localPortStrategyRandom} {
+		if got, err := parseLocalPortStrategy(input); err != nil || got != want {
+			t.Errorf("parseLocalPortStrategy(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+	if _, err := parseLocalPortStrategy("nearest"); err == nil {
+		t.Error("expected an error for an unknown strategy")
+	}
+}
+
+func TestPickLocalPort(t *testing.T) {
+	inUse := map[int]bool{3000: true, 3001: true, 4000: true, 65000: true, 65001: true}
+	available := func(port int) bool { return !inUse[port] }
+
+	tests := []struct {
+		name     string
+		port     int
+		strategy string
+		want     int
+	}{
+		{name: "offset", port: 3000, strategy: localPortStrategyOffset, want: 5000},
+		{name: "offset near the top falls back to next", port: 65000, strategy: localPortStrategyOffset, want: 65002},
+		{name: "next", port: 3000, strategy: localPortStrategyNext, want: 3002},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := pickLocalPort(tt.port, tt.strategy, available); err != nil || got != tt.want {
+				t.Errorf("pickLocalPort(%d, %q) = %d, %v, want %d", tt.port, tt.strategy, got, err, tt.want)
+			}
+		})
+	}
+
+	got, err := pickLocalPort(3000, localPortStrategyRandom, available)
+	if err != nil || got < minRandomLocalPort || got > 65535 {
+		t.Errorf("pickLocalPort(random) = %d, %v, want a port from %d", got, err, minRandomLocalPort)
+	}
+
+	if _, err := pickLocalPort(3000, localPortStrategyNext, func(int) bool { return false }); err == nil {
+		t.Error("expected an error when no port is free")
+	}
+}
+
+func TestIsLocalPortAvailable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create test listener: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	if isLocalPortAvailable(port) {
+		t.Errorf("isLocalPortAvailable(%d) = true for a bound port", port)
+	}
+	listener.Close()
+	if !isLocalPortAvailable(port) {
+		t.Errorf("isLocalPortAvailable(%d) = false after the listener closed", port)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// approvalTimeout bounds how long a prompt waits for an answer. It stays below the
+// helper's 60 second socket timeout so the client receives the denial.
+const approvalTimeout = 45 * time.Second
+
+// Button labels shown in approval dialogs.
+const (
+	approvalLabelDeny    = "Deny"
+	approvalLabelOnce    = "Allow once"
+	approvalLabelSession = "Allow for session"
+)
+
+// approvalDecision is the user's answer to an approval prompt.
+type approvalDecision int
+
+const (
+	approvalDeny approvalDecision = iota
+	approvalAllowOnce
+	approvalAllowSession
+)
+
+// approvalRequest describes a token request that needs the user's approval.
+type approvalRequest struct {
+	Scopes       []string
+	Organization string
+	Client       string
+}
+
+// errNoApprovalDialog is returned when the local machine has no way to show a prompt.
+var errNoApprovalDialog = errors.New("no approval dialog available (install zenity or kdialog)")
+
+// promptScopeApproval asks the local user to approve a token request.
+// It is a variable so tests can replace it.
+var promptScopeApproval = promptScopeApprovalDialog
+
+// scopeApprover asks the user before tokens are issued for scopes with the "prompt"
+// policy, and remembers "allow for session" answers.
+type scopeApprover struct {
+	prompt func(context.Context, approvalRequest) (approvalDecision, error)
+
+	// promptMu keeps to one dialog at a time so concurrent requests for the same
+	// scopes see an earlier "allow for session" answer instead of prompting again.
+	promptMu sync.Mutex
+
+	mu      sync.Mutex
+	session map[string]bool
+}
+
+// newScopeApprover returns an approver that prompts with promptScopeApproval.
+func newScopeApprover() *scopeApprover {
+	return &scopeApprover{
+		prompt:  promptScopeApproval,
+		session: make(map[string]bool),
+	}
+}
+
+// approve reports whether req may be served, along with a reason for the audit log.
+// A nil approver denies every request.
+func (a *scopeApprover) approve(ctx context.Context, req approvalRequest) (bool, string) {
+	if a == nil {
+		return false, "approval prompts are unavailable"
+	}
+
+	key := tokenCacheKey(policy.TokenRequestOptions{Scopes: req.Scopes})
+	if a.approvedForSession(key) {
+		return true, "approved for session"
+	}
+
+	a.promptMu.Lock()
+	defer a.promptMu.Unlock()
+
+	// Another request may have been approved for the session while we waited.
+	if a.approvedForSession(key) {
+		return true, "approved for session"
+	}
+
+	promptCtx, cancel := context.WithTimeout(ctx, approvalTimeout)
+	defer cancel()
+
+	logAuthMessage("Prompting for approval of scopes %v requested by %s", req.Scopes, req.Client)
+	decision, err := a.prompt(promptCtx, req)
+	switch {
+	case err != nil:
+		logAuthMessage("Approval prompt failed: %v", err)
+		if promptCtx.Err() != nil {
+			return false, "approval timed out"
+		}
+		return false, fmt.Sprintf("approval prompt failed: %v", err)
+	case decision == approvalAllowSession:
+		a.mu.Lock()
+		a.session[key] = true
+		a.mu.Unlock()
+		return true, "approved for session"
+	case decision == approvalAllowOnce:
+		return true, "approved once"
+	default:
+		return false, "denied by user"
+	}
+}
+
+// approvedForSession reports whether the scope set keyed by key was allowed for the session.
+func (a *scopeApprover) approvedForSession(key string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.session[key]
+}
+
+// approvalMessage builds the text shown in approval prompts.
+func approvalMessage(req approvalRequest) string {
+	var b strings.Builder
+	b.WriteString("A codespace is requesting an access token for:\n\n")
+	for _, scope := range req.Scopes {
+		fmt.Fprintf(&b, "  %s\n", scope)
+	}
+	if req.Organization != "" {
+		fmt.Fprintf(&b, "\nOrganization: %s\n", req.Organization)
+	}
+	b.WriteString("\nAllow the codespace to use this token?")
+	return b.String()
+}
+
+// promptScopeApprovalDialog shows a native dialog with deny, allow once and allow
+// for session buttons. A desktop notification is sent first in case the dialog
+// opens behind the terminal.
+func promptScopeApprovalDialog(ctx context.Context, req approvalRequest) (approvalDecision, error) {
+	message := approvalMessage(req)
+
+	if err := desktopNotify("gh ado-codespaces", "Approval needed for a token request: "+strings.Join(req.Scopes, " "), notificationIcon); err != nil {
+		logAuthMessage("Failed to send approval notification: %v", err)
+	}
+
+	switch runtime.GOOS {
+	case "darwin":
+		return promptApprovalOSAScript(ctx, message)
+	case "windows":
+		return promptApprovalPowerShell(ctx, message)
+	default:
+		return promptApprovalLinux(ctx, message)
+	}
+}
+
+// approvalMessageEnv passes the prompt text to dialog scripts without quoting it into the script.
+const approvalMessageEnv = "GH_ADO_APPROVAL_MESSAGE"
+
+// dialogCommand builds a dialog command that can read the message from approvalMessageEnv.
+func dialogCommand(ctx context.Context, message, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append(os.Environ(), approvalMessageEnv+"="+message)
+	return cmd
+}
+
+// promptApprovalOSAScript shows an AppleScript dialog on macOS.
+func promptApprovalOSAScript(ctx context.Context, message string) (approvalDecision, error) {
+	script := fmt.Sprintf(`display dialog (system attribute "%s") with title "gh ado-codespaces" buttons {"%s", "%s", "%s"} default button "%s" with icon caution giving up after %d`,
+		approvalMessageEnv, approvalLabelDeny, approvalLabelOnce, approvalLabelSession, approvalLabelDeny, int(approvalTimeout.Seconds()))
+
+	output, err := dialogCommand(ctx, message, "osascript", "-e", script).Output()
+	if err != nil {
+		return approvalDeny, fmt.Errorf("osascript: %w", err)
+	}
+	return parseOSAScriptDecision(string(output)), nil
+}
+
+// parseOSAScriptDecision maps osascript output such as "button returned:Allow once, gave up:false".
+func parseOSAScriptDecision(output string) approvalDecision {
+	for _, field := range strings.Split(strings.TrimSpace(output), ", ") {
+		if label, ok := strings.CutPrefix(field, "button returned:"); ok {
+			return decisionForLabel(label)
+		}
+	}
+	return approvalDeny
+}
+
+// promptApprovalPowerShell shows a Windows Forms dialog with the same labelled buttons
+// as the other platforms. Closing the dialog or leaving it unanswered denies.
+func promptApprovalPowerShell(ctx context.Context, message string) (approvalDecision, error) {
+	output, err := dialogCommand(ctx, message, "powershell", "-NoProfile", "-NonInteractive", "-Command", powerShellApprovalScript()).Output()
+	if err != nil {
+		return approvalDeny, fmt.Errorf("powershell: %w", err)
+	}
+	return decisionForLabel(string(output)), nil
+}
+
+// powerShellApprovalScript builds the dialog, which prints the label of the clicked
+// button. Deny is the default and Escape button, and a timer closes the dialog after
+// approvalTimeout.
+func powerShellApprovalScript() string {
+	return strings.Join([]string{
+		"Add-Type -AssemblyName System.Windows.Forms, System.Drawing",
+		"$form = New-Object System.Windows.Forms.Form",
+		"$form.Text = 'gh ado-codespaces'",
+		"$form.Tag = '" + approvalLabelDeny + "'",
+		"$form.FormBorderStyle = 'FixedDialog'",
+		"$form.MaximizeBox = $false",
+		"$form.MinimizeBox = $false",
+		"$form.TopMost = $true",
+		"$form.StartPosition = 'CenterScreen'",
+		"$form.AutoSize = $true",
+		"$form.AutoSizeMode = 'GrowAndShrink'",
+		"$layout = New-Object System.Windows.Forms.FlowLayoutPanel",
+		"$layout.FlowDirection = 'TopDown'",
+		"$layout.AutoSize = $true",
+		"$layout.Padding = New-Object System.Windows.Forms.Padding(12)",
+		"$label = New-Object System.Windows.Forms.Label",
+		"$label.Text = $env:" + approvalMessageEnv,
+		"$label.AutoSize = $true",
+		"$label.MaximumSize = New-Object System.Drawing.Size(480, 0)",
+		"$layout.Controls.Add($label)",
+		"$buttons = New-Object System.Windows.Forms.FlowLayoutPanel",
+		"$buttons.AutoSize = $true",
+		fmt.Sprintf("foreach ($text in @('%s', '%s', '%s')) {", approvalLabelDeny, approvalLabelOnce, approvalLabelSession),
+		"$button = New-Object System.Windows.Forms.Button",
+		"$button.Text = $text",
+		"$button.AutoSize = $true",
+		"$button.Add_Click({ param($sender) $f = $sender.FindForm(); $f.Tag = $sender.Text; $f.Close() })",
+		"$buttons.Controls.Add($button)",
+		"}",
+		"$form.AcceptButton = $buttons.Controls[0]",
+		"$form.CancelButton = $buttons.Controls[0]",
+		"$layout.Controls.Add($buttons)",
+		"$form.Controls.Add($layout)",
+		"$timer = New-Object System.Windows.Forms.Timer",
+		fmt.Sprintf("$timer.Interval = %d", approvalTimeout.Milliseconds()),
+		"$timer.Add_Tick({ $form.Close() })",
+		"$timer.Start()",
+		"[void]$form.ShowDialog()",
+		"$timer.Stop()",
+		"$form.Tag",
+	}, "\n")
+}
+
+// promptApprovalLinux shows a zenity or kdialog dialog, whichever is installed.
+func promptApprovalLinux(ctx context.Context, message string) (approvalDecision, error) {
+	if path, err := exec.LookPath("zenity"); err == nil {
+		cmd := dialogCommand(ctx, message, path,
+			"--question", "--no-markup",
+			"--title=gh ado-codespaces",
+			"--text="+message,
+			"--ok-label="+approvalLabelOnce,
+			"--cancel-label="+approvalLabelDeny,
+			"--extra-button="+approvalLabelSession,
+			fmt.Sprintf("--timeout=%d", int(approvalTimeout.Seconds())),
+		)
+		output, err := cmd.Output()
+		return zenityDecision(ctx, string(output), err)
+	}
+
+	if path, err := exec.LookPath("kdialog"); err == nil {
+		cmd := dialogCommand(ctx, message, path,
+			"--title", "gh ado-codespaces",
+			"--yes-label", approvalLabelOnce,
+			"--no-label", approvalLabelSession,
+			"--cancel-label", approvalLabelDeny,
+			"--yesnocancel", message,
+		)
+		err := cmd.Run()
+		var exitErr *exec.ExitError
+		switch {
+		case err == nil:
+			return approvalAllowOnce, nil
+		case ctx.Err() != nil:
+			return approvalDeny, ctx.Err()
+		case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+			return approvalAllowSession, nil
+		case errors.As(err, &exitErr):
+			return approvalDeny, nil
+		default:
+			return approvalDeny, fmt.Errorf("kdialog: %w", err)
+		}
+	}
+
+	return approvalDeny, errNoApprovalDialog
+}
+
+// zenityDecision interprets zenity's result. The OK button exits 0, the extra button
+// prints its label and exits 1, and Deny or closing the dialog exits 1 without output.
+func zenityDecision(ctx context.Context, output string, err error) (approvalDecision, error) {
+	if err == nil {
+		return approvalAllowOnce, nil
+	}
+	if ctx.Err() != nil {
+		return approvalDeny, ctx.Err()
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return approvalDeny, fmt.Errorf("zenity: %w", err)
+	}
+	return decisionForLabel(output), nil
+}
+
+// decisionForLabel maps a dialog button label to a decision. Unknown labels deny.
+func decisionForLabel(label string) approvalDecision {
+	switch strings.TrimSpace(label) {
+	case approvalLabelOnce:
+		return approvalAllowOnce
+	case approvalLabelSession:
+		return approvalAllowSession
+	default:
+		return approvalDeny
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScopeApprover_RemembersSessionApproval(t *testing.T) {
+	discardAuthLogs(t)
+	var prompts atomic.Int32
+	approver := newScopeApprover()
+	approver.prompt = func(ctx context.Context, req approvalRequest) (approvalDecision, error) {
+		prompts.Add(1)
+		return approvalAllowSession, nil
+	}
+
+	req := approvalRequest{Scopes: []string{"https://management.azure.com/.default"}}
+	for i := 0; i < 2; i++ {
+		if ok, reason := approver.approve(context.Background(), req); !ok {
+			t.Fatalf("expected approval, got denial: %s", reason)
+		}
+	}
+	if got := prompts.Load(); got != 1 {
+		t.Errorf("expected 1 prompt for a session approval, got %d", got)
+	}
+
+	other := approvalRequest{Scopes: []string{"https://vault.azure.net/.default"}}
+	approver.approve(context.Background(), other)
+	if got := prompts.Load(); got != 2 {
+		t.Errorf("expected a new prompt for different scopes, got %d prompts", got)
+	}
+}
+
+func TestScopeApprover_Decisions(t *testing.T) {
+	discardAuthLogs(t)
+
+	tests := []struct {
+		name     string
+		decision approvalDecision
+		err      error
+		wantOK   bool
+	}{
+		{name: "allow once", decision: approvalAllowOnce, wantOK: true},
+		{name: "deny", decision: approvalDeny, wantOK: false},
+		{name: "prompt error", err: errors.New("no display"), wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var prompts atomic.Int32
+			approver := newScopeApprover()
+			approver.prompt = func(ctx context.Context, req approvalRequest) (approvalDecision, error) {
+				prompts.Add(1)
+				return tt.decision, tt.err
+			}
+
+			req := approvalRequest{Scopes: []string{"https://management.azure.com/.default"}}
+			if ok, _ := approver.approve(context.Background(), req); ok != tt.wantOK {
+				t.Fatalf("approve() = %v, want %v", ok, tt.wantOK)
+			}
+			approver.approve(context.Background(), req)
+			if got := prompts.Load(); got != 2 {
+				t.Errorf("expected the decision not to be remembered, got %d prompts", got)
+			}
+		})
+	}
+}
+
+func TestScopeApprover_TimesOut(t *testing.T) {
+	discardAuthLogs(t)
+	approver := newScopeApprover()
+	approver.prompt = func(ctx context.Context, req approvalRequest) (approvalDecision, error) {
+		<-ctx.Done()
+		return approvalDeny, ctx.Err()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	ok, reason := approver.approve(ctx, approvalRequest{Scopes: []string{"scope"}})
+	if ok || reason != "approval timed out" {
+		t.Errorf("expected timeout denial, got ok=%v reason=%q", ok, reason)
+	}
+}
+
+func TestParseOSAScriptDecision(t *testing.T) {
+	tests := []struct {
+		output string
+		want   approvalDecision
+	}{
+		{output: "button returned:Allow once, gave up:false\n", want: approvalAllowOnce},
+		{output: "button returned:Allow for session, gave up:false", want: approvalAllowSession},
+		{output: "button returned:Deny, gave up:false", want: approvalDeny},
+		{output: "button returned:, gave up:true", want: approvalDeny},
+		{output: "", want: approvalDeny},
+	}
+
+	for _, tt := range tests {
+		if got := parseOSAScriptDecision(tt.output); got != tt.want {
+			t.Errorf("parseOSAScriptDecision(%q) = %v, want %v", tt.output, got, tt.want)
+		}
+	}
+}
+
+func TestPowerShellApprovalScript(t *testing.T) {
+	script := powerShellApprovalScript()
+	for _, want := range []string{
+		"$label.Text = $env:" + approvalMessageEnv,
+		"$form.Tag = 'Deny'",
+		"@('Deny', 'Allow once', 'Allow for session')",
+		"$form.CancelButton = $buttons.Controls[0]",
+		"$timer.Interval = 45000",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("expected %q in the dialog script", want)
+		}
+	}
+
+	// PowerShell prints the clicked button's label; a closed or unanswered dialog prints Deny
+	tests := []struct {
+		output string
+		want   approvalDecision
+	}{
+		{output: "Allow once\r\n", want: approvalAllowOnce},
+		{output: "Allow for session\r\n", want: approvalAllowSession},
+		{output: "Deny\r\n", want: approvalDeny},
+		{output: "No\r\n", want: approvalDeny},
+		{output: "", want: approvalDeny},
+	}
+	for _, tt := range tests {
+		if got := decisionForLabel(tt.output); got != tt.want {
+			t.Errorf("decisionForLabel(%q) = %v, want %v", tt.output, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scope policies accepted in ScopeRule.Policy.
+const (
+	scopePolicyAllow  = "allow"
+	scopePolicyDeny   = "deny"
+	scopePolicyPrompt = "prompt"
+)
+
+// ScopeRule sets the policy for token scopes matching Scope, which may contain '*' wildcards.
+type ScopeRule struct {
+	Scope  string `json:"scope"`
+	Policy string `json:"policy"`
+}
+
+// DefaultScopeRules only allows Azure DevOps and Azure Container Registry tokens to be
+// handed to the codespace.
+var DefaultScopeRules = []ScopeRule{
+	{Scope: "499b84ac-1321-427f-aa17-267ca6975798/*", Policy: scopePolicyAllow},
+	{Scope: "https://containerregistry.azure.net/*", Policy: scopePolicyAllow},
+}
+
+// MergeScopeRules merges rule lists by scope pattern. Later lists override earlier
+// entries for the same pattern. Entries with an empty scope or unknown policy are
+// skipped with a warning.
+func MergeScopeRules(lists ...[]ScopeRule) []ScopeRule {
+	mergedByScope := make(map[string]ScopeRule)
+	var order []string
+
+	for _, rules := range lists {
+		for _, rule := range rules {
+			rule.Scope = strings.TrimSpace(rule.Scope)
+			rule.Policy = strings.ToLower(strings.TrimSpace(rule.Policy))
+			if rule.Scope == "" || !isValidScopePolicy(rule.Policy) {
+				fmt.Fprintf(os.Stderr, "Warning: skipping scope rule with invalid scope %q or policy %q\n", rule.Scope, rule.Policy)
+				continue
+			}
+			if _, exists := mergedByScope[rule.Scope]; !exists {
+				order = append(order, rule.Scope)
+			}
+			mergedByScope[rule.Scope] = rule
+		}
+	}
+
+	merged := make([]ScopeRule, 0, len(order))
+	for _, scope := range order {
+		merged = append(merged, mergedByScope[scope])
+	}
+
+	return merged
+}
+
+// isValidScopePolicy reports whether policy is a known scope policy.
+func isValidScopePolicy(policy string) bool {
+	switch policy {
+	case scopePolicyAllow, scopePolicyDeny, scopePolicyPrompt:
+		return true
+	default:
+		return false
+	}
+}
+
+// scopePolicy decides which token scopes may be issued to the codespace.
+type scopePolicy struct {
+	rules    []ScopeRule
+	patterns []*regexp.Regexp
+}
+
+// newScopePolicy compiles rules into a policy.
+func newScopePolicy(rules []ScopeRule) *scopePolicy {
+	policy := &scopePolicy{rules: rules}
+	for _, rule := range rules {
+		policy.patterns = append(policy.patterns, compileScopePattern(rule.Scope))
+	}
+	return policy
+}
+
+// compileScopePattern turns a scope pattern with '*' wildcards into an anchored,
+// case-insensitive regular expression.
+func compileScopePattern(pattern string) *regexp.Regexp {
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.MustCompile("(?i)^" + strings.Join(parts, ".*") + "$")
+}
+
+// policyFor returns the policy for a single scope. Exact matches win over wildcard
+// patterns; among patterns the last matching rule wins. Unmatched scopes are denied.
+func (p *scopePolicy) policyFor(scope string) string {
+	for _, rule := range p.rules {
+		if strings.EqualFold(rule.Scope, scope) {
+			return rule.Policy
+		}
+	}
+
+	policy := scopePolicyDeny
+	for i, pattern := range p.patterns {
+		if pattern.MatchString(scope) {
+			policy = p.rules[i].Policy
+		}
+	}
+	return policy
+}
+
+// evaluate returns the policy for a request and the scope that decided it. A denied
+// scope wins over one that needs a prompt; when every scope is allowed the scope is "".
+// A nil policy applies DefaultScopeRules.
+func (p *scopePolicy) evaluate(scopes []string) (string, string) {
+	if p == nil {
+		p = newScopePolicy(DefaultScopeRules)
+	}
+
+	promptScope := ""
+	for _, scope := range scopes {
+		switch p.policyFor(scope) {
+		case scopePolicyAllow:
+		case scopePolicyPrompt:
+			if promptScope == "" {
+				promptScope = scope
+			}
+		default:
+			return scope, scopePolicyDeny
+		}
+	}
+	if promptScope != "" {
+		return promptScope, scopePolicyPrompt
+	}
+	return "", scopePolicyAllow
+}
+
+// tokenAuditEntry is one line of the token audit log.
+type tokenAuditEntry struct {
+	Time         string   `json:"time"`
+	Decision     string   `json:"decision"`
+	Scopes       []string `json:"scopes"`
+	Organization string   `json:"organization,omitempty"`
+	Client       string   `json:"client,omitempty"`
+	Reason       string   `json:"reason,omitempty"`
+}
+
+// tokenAuditLog appends token decisions as JSON lines to the session's token-audit.log.
+type tokenAuditLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// openTokenAuditLog creates the audit log in the session log directory.
+func openTokenAuditLog() (*tokenAuditLog, error) {
+	if err := ensureSessionLogDirectory(); err != nil {
+		return nil, fmt.Errorf("failed to create session log directory: %w", err)
+	}
+
+	logPath := getSessionLogPath("token-audit.log")
+	file, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token audit log: %w", err)
+	}
+
+	return &tokenAuditLog{file: file}, nil
+}
+
+// record appends an entry to the audit log. It is a no-op on a nil log.
+func (a *tokenAuditLog) record(entry tokenAuditEntry) {
+	if a == nil {
+		return
+	}
+	if entry.Time == "" {
+		entry.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		logAuthMessage("Failed to marshal audit entry: %v", err)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return
+	}
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		logAuthMessage("Failed to write audit entry: %v", err)
+	}
+}
+
+// Close closes the audit log file.
+func (a *tokenAuditLog) Close() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file != nil {
+		a.file.Close()
+		a.file = nil
+	}
+}
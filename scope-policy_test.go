@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestScopePolicy_Evaluate(t *testing.T) {
+	policy := newScopePolicy(MergeScopeRules(DefaultScopeRules, []ScopeRule{
+		{Scope: "https://management.azure.com/*", Policy: "allow"},
+		{Scope: "https://management.azure.com/user_impersonation", Policy: "deny"},
+		{Scope: "https://management.azure.com/.default", Policy: "prompt"},
+	}))
+
+	tests := []struct {
+		name       string
+		scopes     []string
+		wantPolicy string
+		wantScope  string
+	}{
+		{name: "default ADO scope", scopes: []string{defaultADOScope}, wantPolicy: scopePolicyAllow},
+		{name: "wildcard is case-insensitive", scopes: []string{"499B84AC-1321-427F-AA17-267CA6975798/vso.code"}, wantPolicy: scopePolicyAllow},
+		{name: "unmatched scope denied", scopes: []string{"https://graph.microsoft.com/.default"}, wantPolicy: scopePolicyDeny, wantScope: "https://graph.microsoft.com/.default"},
+		{name: "exact deny beats wildcard allow", scopes: []string{"https://management.azure.com/user_impersonation"}, wantPolicy: scopePolicyDeny, wantScope: "https://management.azure.com/user_impersonation"},
+		{name: "one denied scope denies request", scopes: []string{defaultADOScope, "https://vault.azure.net/.default"}, wantPolicy: scopePolicyDeny, wantScope: "https://vault.azure.net/.default"},
+		{name: "prompt scope", scopes: []string{defaultADOScope, "https://management.azure.com/.default"}, wantPolicy: scopePolicyPrompt, wantScope: "https://management.azure.com/.default"},
+		{name: "deny beats prompt", scopes: []string{"https://management.azure.com/.default", "https://graph.microsoft.com/.default"}, wantPolicy: scopePolicyDeny, wantScope: "https://graph.microsoft.com/.default"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scope, decision := policy.evaluate(tt.scopes)
+			if decision != tt.wantPolicy || scope != tt.wantScope {
+				t.Errorf("evaluate(%v) = (%q, %q), want (%q, %q)", tt.scopes, scope, decision, tt.wantScope, tt.wantPolicy)
+			}
+		})
+	}
+}
+
+func TestScopePolicy_LastMatchingPatternWins(t *testing.T) {
+	policy := newScopePolicy([]ScopeRule{
+		{Scope: "https://*", Policy: "allow"},
+		{Scope: "https://graph.microsoft.com/*", Policy: "deny"},
+	})
+
+	if _, decision := policy.evaluate([]string{"https://storage.azure.com/.default"}); decision != scopePolicyAllow {
+		t.Error("expected broad allow pattern to apply")
+	}
+	if _, decision := policy.evaluate([]string{"https://graph.microsoft.com/.default"}); decision != scopePolicyDeny {
+		t.Error("expected later deny pattern to override earlier allow")
+	}
+}
+
+func TestScopePolicy_NilUsesDefaults(t *testing.T) {
+	var policy *scopePolicy
+	if _, decision := policy.evaluate([]string{defaultADOScope}); decision != scopePolicyAllow {
+		t.Error("expected nil policy to allow the default ADO scope")
+	}
+	if _, decision := policy.evaluate([]string{acrScope}); decision != scopePolicyAllow {
+		t.Error("expected nil policy to allow the ACR scope")
+	}
+	if _, decision := policy.evaluate([]string{"https://graph.microsoft.com/.default"}); decision != scopePolicyDeny {
+		t.Error("expected nil policy to deny other scopes")
+	}
+}
+
+func TestMergeScopeRules(t *testing.T) {
+	merged := MergeScopeRules(
+		DefaultScopeRules,
+		[]ScopeRule{{Scope: " https://vault.azure.net/* ", Policy: "ALLOW"}, {Scope: "", Policy: "allow"}},
+		[]ScopeRule{{Scope: DefaultScopeRules[0].Scope, Policy: "deny"}, {Scope: "x", Policy: "maybe"}},
+	)
+
+	want := []ScopeRule{
+		{Scope: DefaultScopeRules[0].Scope, Policy: scopePolicyDeny},
+		DefaultScopeRules[1],
+		{Scope: "https://vault.azure.net/*", Policy: scopePolicyAllow},
+	}
+	if !reflect.DeepEqual(merged, want) {
+		t.Errorf("MergeScopeRules() = %v, want %v", merged, want)
+	}
+}
@@ -0,0 +1,33 @@
+#!/usr/bin/env bash
+# Release build used by cli/gh-extension-precompile. The extension embeds the
+# codespace-side ado-auth-helper, so it must be generated before cross-compiling.
+set -euo pipefail
+
+platforms=(
+  darwin-amd64
+  darwin-arm64
+  freebsd-amd64
+  freebsd-arm64
+  linux-386
+  linux-amd64
+  linux-arm
+  linux-arm64
+  windows-386
+  windows-amd64
+  windows-arm64
+)
+
+version="${1:-${GITHUB_REF_NAME:-dev}}"
+
+go generate ./...
+
+mkdir -p dist
+for platform in "${platforms[@]}"; do
+  goos="${platform%-*}"
+  goarch="${platform#*-}"
+  ext=""
+  if [ "$goos" = "windows" ]; then
+    ext=".exe"
+  fi
+  GOOS="$goos" GOARCH="$goarch" CGO_ENABLED=0 go build -trimpath -ldflags="-s -w -X main.version=${version}" -o "dist/${platform}${ext}" .
+done
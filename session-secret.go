@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// sessionSecretHeader carries the session secret on browser and notification requests.
+const sessionSecretHeader = "X-GH-ADO-Secret"
+
+// remoteSecretDir holds one secret file per forwarded socket in the codespace, named
+// after the socket without its .sock suffix. The sockets live in the shared /tmp, so the
+// secrets are kept in the home directory where other users can't read them.
+const remoteSecretDir = "~/.gh-ado/secrets"
+
+// newSessionSecret returns a random secret that codespace clients must present to
+// the local services for this session.
+func newSessionSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate session secret: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validSessionSecret reports whether got matches the session secret. An empty secret
+// never matches, so a service that wasn't given one rejects everything.
+func validSessionSecret(secret, got string) bool {
+	if secret == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(got)) == 1
+}
+
+// requireSessionSecret rejects requests that don't carry the session secret header.
+func requireSessionSecret(secret string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !validSessionSecret(secret, r.Header.Get(sessionSecretHeader)) {
+			logDebug("Rejected %s %s without a valid session secret", r.Method, r.URL.Path)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// remoteSecretPath returns the codespace path of the secret file for a forwarded socket.
+func remoteSecretPath(socketPath string) string {
+	return remoteSecretDir + "/" + strings.TrimSuffix(path.Base(socketPath), ".sock")
+}
+
+// buildSessionSecretCommand returns a command that writes the session secret for each
+// socket into a private directory in the codespace. The secret is part of the script
+// read from stdin, so it never appears in a process's arguments.
+func buildSessionSecretCommand(secret string, socketPaths []string) string {
+	var b strings.Builder
+	b.WriteString("(umask 077 && mkdir -p " + remoteSecretDir + " && chmod 700 ~/.gh-ado " + remoteSecretDir)
+	for _, socketPath := range socketPaths {
+		fmt.Fprintf(&b, " && printf %%s %s > %s", secret, remoteSecretPath(socketPath))
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+// buildStaleSecretCleanupCommand removes secrets whose socket is gone. Secrets newer than
+// an hour are kept because another session may have written them before its ssh
+// connection forwarded the socket.
+func buildStaleSecretCleanupCommand() string {
+	return `for secret in ` + remoteSecretDir + `/*; do [ -f "$secret" ] || continue; [ -S "/tmp/$(basename "$secret").sock" ] && continue; [ -n "$(find "$secret" -mmin +60 2>/dev/null)" ] && rm -f "$secret"; done; true`
+}
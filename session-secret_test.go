@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testSessionSecret is the session secret given to services under test.
+const testSessionSecret = "test-secret"
+
+func TestNewSessionSecret(t *testing.T) {
+	first, err := newSessionSecret()
+	if err != nil {
+		t.Fatalf("newSessionSecret() error = %v", err)
+	}
+	second, err := newSessionSecret()
+	if err != nil {
+		t.Fatalf("newSessionSecret() error = %v", err)
+	}
+
+	if len(first) != 64 {
+		t.Errorf("expected 64 hex characters, got %d", len(first))
+	}
+	if first == second {
+		t.Error("expected a different secret each time")
+	}
+}
+
+func TestValidSessionSecret(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		got    string
+		want   bool
+	}{
+		{name: "match", secret: "abc", got: "abc", want: true},
+		{name: "mismatch", secret: "abc", got: "abd", want: false},
+		{name: "missing", secret: "abc", got: "", want: false},
+		{name: "no secret configured", secret: "", got: "", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validSessionSecret(tt.secret, tt.got); got != tt.want {
+				t.Errorf("validSessionSecret(%q, %q) = %v, want %v", tt.secret, tt.got, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireSessionSecret(t *testing.T) {
+	handler := requireSessionSecret(testSessionSecret, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	for _, tt := range []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{name: "valid", header: testSessionSecret, want: http.StatusNoContent},
+		{name: "invalid", header: "nope", want: http.StatusUnauthorized},
+		{name: "missing", header: "", want: http.StatusUnauthorized},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/open", nil)
+			if tt.header != "" {
+				req.Header.Set(sessionSecretHeader, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("expected status %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestRemoteSecretPath(t *testing.T) {
+	got := remoteSecretPath("/tmp/gh-ado-browser-1234.sock")
+	if want := "~/.gh-ado/secrets/gh-ado-browser-1234"; got != want {
+		t.Errorf("remoteSecretPath() = %q, want %q", got, want)
+	}
+}
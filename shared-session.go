@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// sessionLockPollInterval is how often a session waiting for the codespace lock
+	// checks it again.
+	sessionLockPollInterval = 200 * time.Millisecond
+	// sessionLockTimeout bounds the wait for another session of the same codespace to
+	// finish starting, which can include an az login.
+	sessionLockTimeout = 5 * time.Minute
+)
+
+// sessionLock serializes finding and starting the shared services of a codespace, so
+// two sessions started together don't both start them.
+type sessionLock struct {
+	path string
+}
+
+// sessionLockPath returns the lock file of codespace for the current user.
+func sessionLockPath(codespace string) string {
+	name := fmt.Sprintf("%d-%s.lock", os.Getuid(), agentFileName(codespace))
+	return filepath.Join(getLogDirectory(), "sessions", name)
+}
+
+// lockCodespace waits until it holds the lock of codespace. A lock left behind by a
+// process that is no longer running is taken over.
+func lockCodespace(ctx context.Context, codespace string) (*sessionLock, error) {
+	path := sessionLockPath(codespace)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(sessionLockTimeout)
+	waiting := false
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			fmt.Fprint(file, os.Getpid())
+			file.Close()
+			return &sessionLock{path: path}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		if pid, ok := lockHolder(path); ok && !processRunning(pid) {
+			logDebug("Removing session lock %s left by pid %d", path, pid)
+			os.Remove(path)
+			continue
+		}
+		if !waiting {
+			fmt.Fprintf(os.Stderr, "Waiting for another session of %s to start...\n", codespace)
+			waiting = true
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for session lock %s", path)
+		}
+		if err := sleepContext(ctx, sessionLockPollInterval); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// lockHolder returns the pid recorded in a lock file. It reports false while the
+// holder is still writing it.
+func lockHolder(path string) (int, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid, err == nil
+}
+
+// Unlock releases the lock. It is safe to call more than once.
+func (l *sessionLock) Unlock() {
+	if l == nil || l.path == "" {
+		return
+	}
+	if pid, ok := lockHolder(l.path); ok && pid == os.Getpid() {
+		os.Remove(l.path)
+	}
+	l.path = ""
+}
+
+// claimCodespace takes the lock of codespace and returns the running session or agent
+// whose services can be shared, or the held lock when this process should start them.
+// A session that can't take the lock starts its own services without sharing them.
+func claimCodespace(ctx context.Context, codespace string) (*sessionLock, *AgentStatus) {
+	lock, err := lockCodespace(ctx, codespace)
+	if err != nil {
+		logDebug("Failed to lock codespace %s: %v", codespace, err)
+		return nil, nil
+	}
+	if status := findRunningAgent(ctx, codespace); status != nil {
+		lock.Unlock()
+		return nil, status
+	}
+	return lock, nil
+}
+
+// attachToAgent registers this session with the process serving codespace, which keeps
+// its services running until every attached session has ended. The returned function
+// detaches.
+func attachToAgent(ctx context.Context, codespace string) (func(), error) {
+	path, err := agentRecordPath(codespace)
+	if err != nil {
+		return nil, err
+	}
+	record, err := readAgentRecord(path)
+	if err != nil {
+		return nil, err
+	}
+
+	attachCtx, cancel := context.WithCancel(ctx)
+	req, err := http.NewRequestWithContext(attachCtx, http.MethodGet, "http://agent/attach", nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set(sessionSecretHeader, record.Secret)
+
+	// The lease lasts as long as the response, so it can't use the client timeout
+	client := agentClient(record)
+	client.Timeout = 0
+	resp, err := client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("agent returned %s", resp.Status)
+	}
+	return func() {
+		cancel()
+		resp.Body.Close()
+	}, nil
+}
+
+// keepServicesForSessions keeps the forwards of a session whose own shell has ended
+// until the sessions attached to it have ended too. connect holds an SSH connection
+// without a shell.
+func keepServicesForSessions(ctx context.Context, control *agentControl, codespace string, connect func(ctx context.Context, attempt int) error) {
+	sessions := control.attachedSessions()
+	if sessions == 0 || ctx.Err() != nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Keeping the services for %s running for %d other session(s)...\n", codespace, sessions)
+
+	keepCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		control.waitForSessions(keepCtx)
+		cancel()
+	}()
+
+	// The shell's connection carried the forwards; replace it like a reconnection
+	supervisor := newSessionSupervisor(func(ctx context.Context, attempt int) error {
+		return connect(ctx, attempt+1)
+	})
+	supervisor.persistent = true
+	supervisor.run(keepCtx)
+	logDebug("Last attached session of %s ended", codespace)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLockCodespace(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	ctx := context.Background()
+
+	lock, err := lockCodespace(ctx, "fuzzy-space")
+	if err != nil {
+		t.Fatalf("lockCodespace() error = %v", err)
+	}
+	if pid, ok := lockHolder(lock.path); !ok || pid != os.Getpid() {
+		t.Errorf("lock holder = %d, %t, want %d", pid, ok, os.Getpid())
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+	defer cancel()
+	if _, err := lockCodespace(waitCtx, "fuzzy-space"); err == nil {
+		t.Fatal("lockCodespace() should wait while another process holds the lock")
+	}
+
+	other, err := lockCodespace(ctx, "other-codespace")
+	if err != nil {
+		t.Fatalf("lockCodespace() for another codespace error = %v", err)
+	}
+	other.Unlock()
+
+	path := lock.path
+	lock.Unlock()
+	lock.Unlock()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Unlock() should remove the lock file, stat error = %v", err)
+	}
+}
+
+func TestLockCodespace_TakesOverStaleLock(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	path := sessionLockPath("fuzzy-space")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// No process has this pid, since it is above every platform's limit
+	if err := os.WriteFile(path, []byte(strconv.Itoa(1<<30)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	lock, err := lockCodespace(ctx, "fuzzy-space")
+	if err != nil {
+		t.Fatalf("lockCodespace() should take over a stale lock, error = %v", err)
+	}
+	lock.Unlock()
+}
+
+func TestAttachToAgent_KeepsServicesUntilDetached(t *testing.T) {
+	useTestRuntimeDir(t)
+	ctx := context.Background()
+
+	control, err := startAgentControl("fuzzy-space", func() AgentStatus {
+		return AgentStatus{Codespace: "fuzzy-space", Mode: "session", PID: 42}
+	}, func() {})
+	if err != nil {
+		t.Fatalf("startAgentControl() error = %v", err)
+	}
+	defer control.Close()
+
+	detach, err := attachToAgent(ctx, "fuzzy-space")
+	if err != nil {
+		t.Fatalf("attachToAgent() error = %v", err)
+	}
+	if status := findRunningAgent(ctx, "fuzzy-space"); status == nil || status.Sessions != 1 {
+		t.Fatalf("findRunningAgent() = %+v, want 1 attached session", status)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		control.waitForSessions(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("waitForSessions() returned while a session is attached")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	detach()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("waitForSessions() did not return after the session detached")
+	}
+}
+
+func TestStartAgentControl_RejectsSecondPublisher(t *testing.T) {
+	useTestRuntimeDir(t)
+
+	control, err := startAgentControl("fuzzy-space", func() AgentStatus { return AgentStatus{PID: 1} }, func() {})
+	if err != nil {
+		t.Fatalf("startAgentControl() error = %v", err)
+	}
+	defer control.Close()
+
+	if second, err := startAgentControl("fuzzy-space", func() AgentStatus { return AgentStatus{PID: 2} }, func() {}); err == nil {
+		second.Close()
+		t.Fatal("startAgentControl() should fail while another process serves the codespace")
+	}
+	if status := findRunningAgent(context.Background(), "fuzzy-space"); status == nil || status.PID != 1 {
+		t.Errorf("findRunningAgent() = %+v, want the first publisher", status)
+	}
+}
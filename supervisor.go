@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/cli/go-gh/v2"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// reconnectInitialDelay is the wait before the first reconnection attempt.
+	reconnectInitialDelay = time.Second
+	// reconnectMaxDelay caps the exponential backoff between attempts.
+	reconnectMaxDelay = time.Minute
+	// reconnectStableDuration is how long a connection must last to reset the backoff.
+	reconnectStableDuration = time.Minute
+	// reconnectConnectWindow is how quickly a reconnection attempt must fail for the
+	// failure to count as a failed connection rather than the user leaving the session.
+	reconnectConnectWindow = 15 * time.Second
+	// sshConnectionLostExitCode is the exit status ssh uses when the connection fails.
+	sshConnectionLostExitCode = 255
+	// maxSessionErrorOutput is how much of gh's stderr is kept to tell why a session
+	// ended.
+	maxSessionErrorOutput = 4096
+)
+
+// errConnectionLost marks a session that ended because its connection dropped.
+var errConnectionLost = errors.New("connection to the codespace lost")
+
+// ghShellClosedPattern matches the error gh prints when ssh exits with a status. gh
+// itself then exits with status 1, whatever ssh's status was.
+var ghShellClosedPattern = regexp.MustCompile(`shell closed: exit status (\d+)`)
+
+// sessionError is a failed 'gh codespace ssh' run and the end of what gh printed.
+type sessionError struct {
+	err    error
+	output string
+}
+
+func (e *sessionError) Error() string { return e.err.Error() }
+func (e *sessionError) Unwrap() error { return e.err }
+
+// remoteExitStatus returns the exit status the remote side reported for a session:
+// the remote command's own status, or 255 when ssh lost the connection.
+func remoteExitStatus(err error) (int, bool) {
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), true
+	}
+	var sessErr *sessionError
+	if errors.As(err, &sessErr) {
+		matches := ghShellClosedPattern.FindAllStringSubmatch(sessErr.output, -1)
+		if len(matches) > 0 {
+			status, err := strconv.Atoi(matches[len(matches)-1][1])
+			return status, err == nil
+		}
+	}
+	return 0, false
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	max  int
+	data []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.data = append(b.data, p...)
+	if len(b.data) > b.max {
+		b.data = b.data[len(b.data)-b.max:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string { return string(b.data) }
+
+// execSSHSession runs 'gh codespace ssh' like gh.ExecInteractive, and keeps the end of
+// its stderr so shouldReconnect can tell why it ended.
+func execSSHSession(ctx context.Context, args ...string) error {
+	ghExe, err := gh.Path()
+	if err != nil {
+		return err
+	}
+	output := &tailBuffer{max: maxSessionErrorOutput}
+	cmd := exec.CommandContext(ctx, ghExe, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = io.MultiWriter(os.Stderr, output)
+	// Don't wait on stderr held open by something ssh left running
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		return &sessionError{err: fmt.Errorf("gh execution failed: %w", err), output: output.String()}
+	}
+	return nil
+}
+
+// reconnectBackoff computes exponentially growing delays between reconnection attempts.
+type reconnectBackoff struct {
+	initial time.Duration
+	max     time.Duration
+	next    time.Duration
+}
+
+// newReconnectBackoff returns a backoff starting at reconnectInitialDelay.
+func newReconnectBackoff() *reconnectBackoff {
+	return &reconnectBackoff{initial: reconnectInitialDelay, max: reconnectMaxDelay}
+}
+
+// delay returns the wait before the next attempt and doubles the one after it.
+func (b *reconnectBackoff) delay() time.Duration {
+	if b.next == 0 {
+		b.next = b.initial
+	}
+	d := b.next
+	b.next = min(b.next*2, b.max)
+	return d
+}
+
+// reset starts the backoff over after a connection that stayed up.
+func (b *reconnectBackoff) reset() {
+	b.next = 0
+}
+
+// sleepContext waits for d or until ctx is canceled.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// exitCode returns the exit status of a finished command, or -1 when err isn't an exit.
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// sessionSupervisor reruns an SSH session while the connection keeps dropping, so
+// the local services and forwards outlive network blips and codespace restarts.
+type sessionSupervisor struct {
+	// connect runs one SSH session; attempt is 0 for the first connection.
+	connect func(ctx context.Context, attempt int) error
+	sleep   func(ctx context.Context, d time.Duration) error
+	now     func() time.Time
+	backoff *reconnectBackoff
+
+	// persistent sessions run no remote command, so they only end when the
+	// connection does.
+	persistent bool
+}
+
+// newSessionSupervisor returns a supervisor that runs connect until the session ends.
+func newSessionSupervisor(connect func(ctx context.Context, attempt int) error) *sessionSupervisor {
+	return &sessionSupervisor{
+		connect: connect,
+		sleep:   sleepContext,
+		now:     time.Now,
+		backoff: newReconnectBackoff(),
+	}
+}
+
+// run connects and reconnects until the remote session exits on its own, the
+// context is canceled, or gh can't be started. It returns the last session's error.
+func (s *sessionSupervisor) run(ctx context.Context) error {
+	for attempt := 0; ; attempt++ {
+		started := s.now()
+		err := s.connect(ctx, attempt)
+		elapsed := s.now().Sub(started)
+
+		if ctx.Err() != nil || !s.shouldReconnect(err, attempt, elapsed) {
+			return err
+		}
+		if elapsed >= reconnectStableDuration {
+			s.backoff.reset()
+		}
+
+		delay := s.backoff.delay()
+		logDebug("SSH session ended after %s (%v); reconnecting in %s", elapsed.Round(time.Second), err, delay)
+		fmt.Fprintf(os.Stderr, "\nConnection to the codespace was lost. Reconnecting in %s (Ctrl+C to stop)...\n", delay)
+		if s.sleep(ctx, delay) != nil {
+			return err
+		}
+	}
+}
+
+// shouldReconnect applies shouldReconnectPersistent to persistent sessions and
+// shouldReconnect to the others.
+func (s *sessionSupervisor) shouldReconnect(err error, attempt int, elapsed time.Duration) bool {
+	if s.persistent {
+		return shouldReconnectPersistent(err, attempt, elapsed)
+	}
+	return shouldReconnect(err, attempt, elapsed)
+}
+
+// shouldReconnect reports whether a session that ended with err should be retried.
+// When the remote side reported an exit status, 255 means ssh lost the connection and
+// any other status is the remote command's own. gh exits with status 1 for every
+// other failure, such as the tunnel closing, so those count as a drop once the
+// session was up.
+func shouldReconnect(err error, attempt int, elapsed time.Duration) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, errConnectionLost):
+		return true
+	}
+	if status, ok := remoteExitStatus(err); ok {
+		return status == sshConnectionLostExitCode
+	}
+	switch code := exitCode(err); code {
+	case -1:
+		// gh couldn't be started
+		return false
+	case sshConnectionLostExitCode:
+		return true
+	default:
+		return sessionWasUp(attempt, elapsed)
+	}
+}
+
+// shouldReconnectPersistent is shouldReconnect for sessions whose remote side never
+// ends on its own, such as ssh -N or the port watcher: once one was up it is retried
+// however it ended, even with a normal exit.
+func shouldReconnectPersistent(err error, attempt int, elapsed time.Duration) bool {
+	ran := err == nil || errors.Is(err, errConnectionLost) ||
+		errors.As(err, new(*sessionError)) || errors.As(err, new(*ssh.ExitError))
+	return (ran && sessionWasUp(attempt, elapsed)) || shouldReconnect(err, attempt, elapsed)
+}
+
+// sessionWasUp reports whether a session that ended after elapsed had connected.
+// The first connection failing within reconnectConnectWindow is taken to be a
+// codespace that can't be reached rather than a dropped session; reconnection
+// attempts keep retrying while the codespace restarts.
+func sessionWasUp(attempt int, elapsed time.Duration) bool {
+	return attempt > 0 || elapsed >= reconnectConnectWindow
+}
+
+// tmuxSessionCommand returns a remote command that attaches to the tmux session name,
+// creating it if needed, or starts a login shell when tmux isn't installed.
+func tmuxSessionCommand(name string) []string {
+	return wrapBashLoginCommand(fmt.Sprintf(`if command -v tmux >/dev/null 2>&1; then exec tmux new-session -A -s %s; else exec "${SHELL:-bash}" -l; fi`, name))
+}
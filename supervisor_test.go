@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exitError returns the error a command exiting with code produces.
+func exitError(t *testing.T, code int) error {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	err := exec.Command("sh", "-c", fmt.Sprintf("exit %d", code)).Run()
+	if exitCode(err) != code {
+		t.Fatalf("expected exit status %d, got %v", code, err)
+	}
+	return fmt.Errorf("gh execution failed: %w", err)
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	backoff := newReconnectBackoff()
+	var delays []time.Duration
+	for i := 0; i < 8; i++ {
+		delays = append(delays, backoff.delay())
+	}
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 32 * time.Second, time.Minute, time.Minute}
+	for i := range want {
+		if delays[i] != want[i] {
+			t.Fatalf("delays = %v, want %v", delays, want)
+		}
+	}
+
+	backoff.reset()
+	if d := backoff.delay(); d != reconnectInitialDelay {
+		t.Errorf("expected reset to start over at %s, got %s", reconnectInitialDelay, d)
+	}
+}
+
+func TestShouldReconnect(t *testing.T) {
+	lost := exitError(t, sshConnectionLostExitCode)
+	failed := exitError(t, 1)
+
+	tests := []struct {
+		name    string
+		err     error
+		attempt int
+		elapsed time.Duration
+		want    bool
+	}{
+		{name: "clean exit", err: nil, want: false},
+		{name: "gh not started", err: errors.New("gh not found"), want: false},
+		{name: "connection lost", err: lost, elapsed: time.Hour, want: true},
+		{name: "dropped after it was up", err: failed, elapsed: time.Hour, want: true},
+		{name: "first connection failed", err: failed, elapsed: time.Second, want: false},
+		{name: "reconnection failed quickly", err: failed, attempt: 1, elapsed: time.Second, want: true},
+		{name: "reconnected session dropped", err: failed, attempt: 1, elapsed: time.Hour, want: true},
+		{name: "connection lost without status", err: fmt.Errorf("%w: EOF", errConnectionLost), want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shouldReconnect(tt.err, tt.attempt, tt.elapsed); got != tt.want {
+				t.Errorf("shouldReconnect() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// runFakeGH runs execSSHSession against a stand-in for gh that prints stderr and
+// exits with code, the way gh reports the end of a session.
+func runFakeGH(t *testing.T, stderr string, code int) error {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	script := filepath.Join(t.TempDir(), "gh")
+	body := fmt.Sprintf("#!/bin/sh\nprintf '%%s\\n' %q >&2\nexit %d\n", stderr, code)
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GH_PATH", script)
+	return execSSHSession(context.Background(), "codespace", "ssh")
+}
+
+func TestShouldReconnect_GHSessionErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		stderr  string
+		attempt int
+		elapsed time.Duration
+		want    bool
+	}{
+		{name: "ssh lost the connection", stderr: "shell closed: exit status 255", elapsed: time.Second, want: true},
+		{name: "remote command failed", stderr: "shell closed: exit status 130", elapsed: time.Hour, want: false},
+		{name: "remote command failed after reconnecting", stderr: "shell closed: exit status 2", attempt: 1, want: false},
+		{name: "tunnel closed", stderr: "tunnel closed: read tcp 10.0.0.2:50312: connection reset by peer", elapsed: time.Hour, want: true},
+		{name: "codespace unreachable", stderr: "error connecting to codespace: codespace is not running", elapsed: time.Second, want: false},
+		{name: "codespace restarting", stderr: "error connecting to codespace: codespace is not running", attempt: 2, elapsed: time.Second, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runFakeGH(t, tt.stderr, 1)
+			if err == nil {
+				t.Fatal("expected an error from gh")
+			}
+			if got := shouldReconnect(err, tt.attempt, tt.elapsed); got != tt.want {
+				t.Errorf("shouldReconnect(%v, %q) = %v, want %v", err, tt.stderr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShouldReconnectPersistent(t *testing.T) {
+	closed := runFakeGH(t, "shell closed: exit status 0", 1)
+
+	tests := []struct {
+		name    string
+		err     error
+		attempt int
+		elapsed time.Duration
+		want    bool
+	}{
+		{name: "clean exit after it was up", err: nil, elapsed: time.Hour, want: true},
+		{name: "remote side exited after it was up", err: closed, elapsed: time.Hour, want: true},
+		{name: "first connection failed", err: closed, elapsed: time.Second, want: false},
+		{name: "gh not started", err: errors.New("gh not found"), elapsed: time.Hour, want: false},
+		{name: "connection lost", err: errConnectionLost, elapsed: time.Second, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shouldReconnectPersistent(tt.err, tt.attempt, tt.elapsed); got != tt.want {
+				t.Errorf("shouldReconnectPersistent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionSupervisor_ReconnectsUntilSessionExits(t *testing.T) {
+	lost := exitError(t, sshConnectionLostExitCode)
+	failed := exitError(t, 1)
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	results := []struct {
+		err      error
+		duration time.Duration
+	}{
+		{lost, 2 * time.Hour},   // dropped after a long session
+		{failed, time.Second},   // codespace still restarting
+		{lost, time.Second},     // connected briefly and dropped again
+		{nil, 30 * time.Minute}, // user exits the shell
+		{lost, time.Second},     // never reached
+	}
+
+	var attempts []int
+	var delays []time.Duration
+	supervisor := newSessionSupervisor(func(ctx context.Context, attempt int) error {
+		attempts = append(attempts, attempt)
+		r := results[attempt]
+		now = now.Add(r.duration)
+		return r.err
+	})
+	supervisor.now = func() time.Time { return now }
+	supervisor.sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+
+	if err := supervisor.run(context.Background()); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if len(attempts) != 4 {
+		t.Errorf("expected 4 connections, got %v", attempts)
+	}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}
+	if fmt.Sprint(delays) != fmt.Sprint(want) {
+		t.Errorf("delays = %v, want %v", delays, want)
+	}
+}
+
+func TestSessionSupervisor_StopsWhenCanceled(t *testing.T) {
+	lost := exitError(t, sshConnectionLostExitCode)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	calls := 0
+	supervisor := newSessionSupervisor(func(ctx context.Context, attempt int) error {
+		calls++
+		return lost
+	})
+	supervisor.sleep = func(ctx context.Context, d time.Duration) error {
+		cancel()
+		return ctx.Err()
+	}
+
+	if err := supervisor.run(ctx); exitCode(err) != sshConnectionLostExitCode {
+		t.Errorf("expected the last session error, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected no reconnection after cancel, got %d calls", calls)
+	}
+}
+
+func TestTmuxSessionCommand(t *testing.T) {
+	command := strings.Join(tmuxSessionCommand("gh-ado-1234"), " ")
+	for _, want := range []string{"bash -lc", "tmux new-session -A -s gh-ado-1234", `exec "${SHELL:-bash}" -l`} {
+		if !strings.Contains(command, want) {
+			t.Errorf("expected %q in %s", want, command)
+		}
+	}
+}
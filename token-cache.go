@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// tokenRefreshMargin is how long before expiry a cached token is refreshed in the background.
+const tokenRefreshMargin = 5 * time.Minute
+
+// cachingCredential wraps a TokenCredential with an in-memory token cache keyed by scope set.
+// Concurrent requests for the same scopes share a single underlying GetToken call.
+type cachingCredential struct {
+	cred    azcore.TokenCredential
+	metrics *tokenMetrics
+	now     func() time.Time
+	mu      sync.Mutex
+	entries map[string]*tokenCacheEntry
+}
+
+// tokenCacheEntry holds the cached token for one scope set and any fetch in progress.
+type tokenCacheEntry struct {
+	token    azcore.AccessToken
+	hasToken bool
+	inflight *tokenFetch
+}
+
+// tokenFetch tracks a single in-flight GetToken call shared by all waiters.
+type tokenFetch struct {
+	done  chan struct{}
+	token azcore.AccessToken
+	err   error
+}
+
+// newCachingCredential returns a credential that caches tokens issued by cred.
+func newCachingCredential(cred azcore.TokenCredential) *cachingCredential {
+	return &cachingCredential{
+		cred:    cred,
+		now:     time.Now,
+		entries: make(map[string]*tokenCacheEntry),
+	}
+}
+
+// uncachedCredential returns the credential a cachingCredential wraps, or cred itself,
+// for checks that must reach the credential sources.
+func uncachedCredential(cred azcore.TokenCredential) azcore.TokenCredential {
+	if c, ok := cred.(*cachingCredential); ok {
+		return c.cred
+	}
+	return cred
+}
+
+// GetToken returns a cached token when it is still fresh, otherwise fetches a new one.
+// Tokens inside the refresh window are returned immediately while a refresh runs in the background.
+func (c *cachingCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	// Claims challenges require a fresh token, so bypass the cache entirely.
+	if opts.Claims != "" {
+		return c.cred.GetToken(ctx, opts)
+	}
+
+	key := tokenCacheKey(opts)
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &tokenCacheEntry{}
+		c.entries[key] = entry
+	}
+
+	now := c.now()
+	if entry.hasToken && now.Before(entry.token.ExpiresOn) {
+		if now.Before(refreshTime(entry.token)) {
+			c.mu.Unlock()
+			logAuthMessage("Token cache hit for scopes %v", opts.Scopes)
+			c.metrics.recordCache(true)
+			return entry.token, nil
+		}
+
+		// Still valid but close to expiry: serve the cached token and refresh proactively.
+		if entry.inflight == nil {
+			logAuthMessage("Token for scopes %v expires at %s, refreshing in background", opts.Scopes, entry.token.ExpiresOn.Format(time.RFC3339))
+			c.startFetch(ctx, key, entry, opts)
+		}
+		token := entry.token
+		c.mu.Unlock()
+		c.metrics.recordCache(true)
+		return token, nil
+	}
+
+	fetch := entry.inflight
+	if fetch == nil {
+		logAuthMessage("Token cache miss for scopes %v", opts.Scopes)
+		fetch = c.startFetch(ctx, key, entry, opts)
+	} else {
+		logAuthMessage("Waiting on in-flight token request for scopes %v", opts.Scopes)
+	}
+	c.mu.Unlock()
+	c.metrics.recordCache(false)
+
+	select {
+	case <-fetch.done:
+		return fetch.token, fetch.err
+	case <-ctx.Done():
+		return azcore.AccessToken{}, ctx.Err()
+	}
+}
+
+// startFetch launches the underlying GetToken call for entry. Callers must hold c.mu.
+func (c *cachingCredential) startFetch(ctx context.Context, key string, entry *tokenCacheEntry, opts policy.TokenRequestOptions) *tokenFetch {
+	fetch := &tokenFetch{done: make(chan struct{})}
+	entry.inflight = fetch
+
+	// The fetch is shared by every waiter, so it must not be canceled when the
+	// request that happened to start it goes away.
+	fetchCtx := context.WithoutCancel(ctx)
+
+	go func() {
+		start := time.Now()
+		token, err := c.cred.GetToken(fetchCtx, opts)
+		c.metrics.recordMint(time.Since(start))
+
+		c.mu.Lock()
+		entry.inflight = nil
+		if err == nil {
+			entry.token = token
+			entry.hasToken = true
+		} else {
+			logAuthMessage("Token refresh for %s failed: %v", key, err)
+		}
+		c.mu.Unlock()
+
+		fetch.token = token
+		fetch.err = err
+		close(fetch.done)
+	}()
+
+	return fetch
+}
+
+// refreshTime returns when a token should be proactively refreshed.
+func refreshTime(token azcore.AccessToken) time.Time {
+	if !token.RefreshOn.IsZero() && token.RefreshOn.Before(token.ExpiresOn) {
+		return token.RefreshOn
+	}
+	return token.ExpiresOn.Add(-tokenRefreshMargin)
+}
+
+// tokenCacheKey builds a stable cache key from the request's scope set and tenant.
+func tokenCacheKey(opts policy.TokenRequestOptions) string {
+	scopes := make([]string, 0, len(opts.Scopes))
+	seen := make(map[string]bool, len(opts.Scopes))
+	for _, scope := range opts.Scopes {
+		scope = strings.TrimSpace(scope)
+		if scope == "" || seen[scope] {
+			continue
+		}
+		seen[scope] = true
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+
+	key := strings.Join(scopes, " ")
+	if opts.TenantID != "" {
+		key += "|tenant=" + opts.TenantID
+	}
+	if opts.EnableCAE {
+		key += "|cae"
+	}
+	return key
+}
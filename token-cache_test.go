@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// fakeCredential is a TokenCredential that counts calls and returns configurable tokens.
+type fakeCredential struct {
+	calls     atomic.Int32
+	delay     time.Duration
+	err       error
+	mu        sync.Mutex
+	expiresAt time.Time
+}
+
+func (f *fakeCredential) setExpiry(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expiresAt = t
+}
+
+func (f *fakeCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	n := f.calls.Add(1)
+	if f.delay > 0 {
+		time.Sleep(f.delay)
+	}
+	if f.err != nil {
+		return azcore.AccessToken{}, f.err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return azcore.AccessToken{Token: fmt.Sprintf("token-%d", n), ExpiresOn: f.expiresAt}, nil
+}
+
+func TestCachingCredential_ReusesFreshToken(t *testing.T) {
+	discardAuthLogs(t)
+	fake := &fakeCredential{expiresAt: time.Now().Add(time.Hour)}
+	cache := newCachingCredential(fake)
+	opts := policy.TokenRequestOptions{Scopes: []string{"scope/.default"}}
+
+	first, err := cache.GetToken(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+	second, err := cache.GetToken(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+
+	if first.Token != second.Token {
+		t.Errorf("expected cached token %q, got %q", first.Token, second.Token)
+	}
+	if got := fake.calls.Load(); got != 1 {
+		t.Errorf("expected 1 underlying call, got %d", got)
+	}
+}
+
+func TestCachingCredential_DeduplicatesConcurrentRequests(t *testing.T) {
+	discardAuthLogs(t)
+	fake := &fakeCredential{delay: 50 * time.Millisecond, expiresAt: time.Now().Add(time.Hour)}
+	cache := newCachingCredential(fake)
+	opts := policy.TokenRequestOptions{Scopes: []string{"scope/.default"}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.GetToken(context.Background(), opts); err != nil {
+				t.Errorf("GetToken() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := fake.calls.Load(); got != 1 {
+		t.Errorf("expected 1 underlying call for concurrent requests, got %d", got)
+	}
+}
+
+func TestCachingCredential_RefreshesNearExpiry(t *testing.T) {
+	discardAuthLogs(t)
+	now := time.Now()
+	fake := &fakeCredential{expiresAt: now.Add(time.Hour)}
+	cache := newCachingCredential(fake)
+	cache.now = func() time.Time { return now }
+	opts := policy.TokenRequestOptions{Scopes: []string{"scope/.default"}}
+
+	if _, err := cache.GetToken(context.Background(), opts); err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+
+	// Move inside the refresh window: the cached token is served and a refresh starts.
+	cache.now = func() time.Time { return now.Add(time.Hour - time.Minute) }
+	token, err := cache.GetToken(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+	if token.Token != "token-1" {
+		t.Errorf("expected cached token while refreshing, got %q", token.Token)
+	}
+
+	refreshed := func() bool {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		entry := cache.entries[tokenCacheKey(opts)]
+		return entry.inflight == nil && entry.token.Token == "token-2"
+	}
+	deadline := time.Now().Add(time.Second)
+	for !refreshed() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !refreshed() {
+		t.Fatalf("expected background refresh, got %d calls", fake.calls.Load())
+	}
+
+	// Once the token has expired the caller must wait for a new one.
+	fake.setExpiry(now.Add(3 * time.Hour))
+	cache.now = func() time.Time { return now.Add(2 * time.Hour) }
+	token, err = cache.GetToken(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+	if token.Token != "token-3" {
+		t.Errorf("expected fresh token after expiry, got %q", token.Token)
+	}
+}
+
+func TestCachingCredential_DoesNotCacheErrors(t *testing.T) {
+	discardAuthLogs(t)
+	fake := &fakeCredential{err: errors.New("az failed")}
+	cache := newCachingCredential(fake)
+	opts := policy.TokenRequestOptions{Scopes: []string{"scope/.default"}}
+
+	for i := 0; i < 2; i++ {
+		if _, err := cache.GetToken(context.Background(), opts); err == nil {
+			t.Fatal("expected error from GetToken()")
+		}
+	}
+	if got := fake.calls.Load(); got != 2 {
+		t.Errorf("expected failed requests to be retried, got %d calls", got)
+	}
+}
+
+func TestTokenCacheKey(t *testing.T) {
+	a := tokenCacheKey(policy.TokenRequestOptions{Scopes: []string{"b", "a", "a"}})
+	b := tokenCacheKey(policy.TokenRequestOptions{Scopes: []string{"a", "b"}})
+	if a != b {
+		t.Errorf("expected scope order and duplicates to be ignored: %q != %q", a, b)
+	}
+
+	withTenant := tokenCacheKey(policy.TokenRequestOptions{Scopes: []string{"a", "b"}, TenantID: "tenant"})
+	if withTenant == b {
+		t.Errorf("expected tenant to be part of the cache key")
+	}
+}
@@ -23,6 +23,20 @@ fi
 # URL handling
 # ---------------------------------------------------------------------------
 
+# gh_ado_owned_socket: succeed if $1 is a socket owned by the current user, not
+# a link to one, so nothing is sent to a socket another user created in /tmp.
+gh_ado_owned_socket() {
+    [[ -S "$1" && ! -L "$1" && -O "$1" ]]
+}
+
+# gh_ado_secret_config: print a curl config line carrying the session secret for
+# the socket in $1. The secret is piped to curl so it never appears in argv.
+gh_ado_secret_config() {
+    gh_ado_owned_socket "$1" || return 1
+    local secret_file="$HOME/.gh-ado/secrets/$(basename "$1" .sock)"
+    [[ -r "$secret_file" ]] && printf 'header = "X-GH-ADO-Secret: %s"\n' "$(cat "$secret_file")"
+}
+
 # open_url: try multiple strategies to open a URL, falling back gracefully.
 open_url() {
     local url="$1"
@@ -37,12 +51,13 @@ open_url() {
         local sock
         while IFS= read -r sock; do
             [[ -z "$sock" ]] && continue
-            if curl -s --max-time 2 --unix-socket "$sock" \
+            gh_ado_owned_socket "$sock" || continue
+            if gh_ado_secret_config "$sock" | curl -K - -sf --max-time 2 --unix-socket "$sock" \
                     -X POST "http://localhost/open?url=${encoded_url}" \
                     >/dev/null 2>&1; then
                 return 0
             fi
-        done < <(find /tmp -maxdepth 1 -name "gh-ado-browser-*.sock" -type s \
+        done < <(find /tmp -maxdepth 1 -name "gh-ado-browser-*.sock" -type s -user "$(id -u)" \
                      -exec ls -t {} + 2>/dev/null)
     fi
 